@@ -1,73 +1,412 @@
 package main
 
 import (
+	"ImageProcessor/internal/fetcher"
 	storage "ImageProcessor/internal/file_storage"
+	"ImageProcessor/internal/imagehash"
 	"ImageProcessor/internal/messagebroker"
 	"ImageProcessor/internal/models"
 	"ImageProcessor/internal/modifer"
 	"ImageProcessor/internal/repository"
+	"ImageProcessor/internal/service/deadletter_service"
 	"ImageProcessor/internal/service/image_service"
+	"ImageProcessor/internal/service/outbox_service"
+	"ImageProcessor/internal/service/reaper_service"
+	"ImageProcessor/internal/service/render_service"
+	"ImageProcessor/internal/service/upload_service"
 	"ImageProcessor/internal/service/worker_service"
 	"ImageProcessor/internal/transport/handlers"
 	"ImageProcessor/internal/transport/router"
+	"ImageProcessor/internal/urlsigner"
 	"ImageProcessor/pkg/logger"
+	"cmp"
 	"context"
-	"errors"
+	"fmt"
 	"github.com/wb-go/wbf/config"
+	"github.com/wb-go/wbf/retry"
 	"go.uber.org/zap"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/template"
 )
 
+// Режимы запуска: API и воркер можно масштабировать отдельно друг от друга.
+const (
+	modeAPI    = "api"
+	modeWorker = "worker"
+	modeAll    = "all"
+)
+
+// app — зависимости, общие для API и воркера.
+type app struct {
+	cfg         *config.Config
+	log         *zap.Logger
+	repo        *repository.Repository
+	urlSigner   *urlsigner.Signer
+	fileStorage *storage.FileStorage
+	hasher      *imagehash.Hasher
+	broker      messagebroker.Broker
+	lanes       []messagebroker.Lane
+	// keys строит пути результатов одинаково во всех режимах
+	keys models.OperationKeys
+	// background отслеживает фоновые циклы, которые нужно дождаться при остановке
+	background sync.WaitGroup
+	closers    []closer
+}
+
+type closer struct {
+	name  string
+	close func() error
+}
+
+// onClose регистрирует ресурс; ресурсы закрываются в обратном порядке, поэтому
+// база, открытая первой, закрывается последней.
+func (a *app) onClose(name string, close func() error) {
+	a.closers = append(a.closers, closer{name: name, close: close})
+}
+
+func (a *app) close() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		if err := a.closers[i].close(); err != nil {
+			a.log.Error("Failed to close "+a.closers[i].name, zap.Error(err))
+		}
+	}
+}
+
+// newApp создает зависимости, общие для всех режимов.
+func newApp(cfg *config.Config, mode string, log *zap.Logger) *app {
+	a := &app{cfg: cfg, log: log}
+	var err error
+	a.repo, err = repository.NewRepository(cfg.GetString("master_dsn"), cfg.GetStringSlice("slaveDSNs"), log)
+	if err != nil {
+		log.Fatal("failed to init repository", zap.Error(err))
+	}
+	a.onClose("repository", a.repo.Close)
+	a.urlSigner, err = newURLSigner(cfg)
+	if err != nil {
+		log.Fatal("failed to init url signer", zap.Error(err))
+	}
+
+	backend, err := newStorageBackend(cfg, a.urlSigner, log)
+	if err != nil {
+		log.Fatal("failed to init storage backend", zap.Error(err))
+	}
+	a.fileStorage = storage.NewFileStorage(backend, log)
+	a.hasher = imagehash.NewHasher(a.fileStorage, log)
+	a.broker, err = newBroker(cfg, mode)
+	if err != nil {
+		log.Fatal("failed to init message broker", zap.Error(err))
+	}
+	a.lanes = newPriorityLanes(cfg)
+	a.keys = models.OperationKeys{WatermarkVersion: cfg.GetString("watermarkVersion")}
+	return a
+}
+
 func main() {
 
-	ctx := context.Background()
+	// ctx отменяется по SIGINT/SIGTERM и останавливает фоновые циклы и воркер
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	cfg := config.New()
+	if err := cfg.DefineFlag("m", "mode", "mode", modeAll, "run mode: api, worker or all"); err != nil {
+		panic(err)
+	}
+	if err := cfg.ParseFlags(); err != nil {
+		panic(err)
+	}
 	_ = cfg.LoadConfigFiles("./config/config.yaml")
 	log, err := logger.NewLogger(cfg.GetString("log_level"))
 	if err != nil {
 		panic(err)
 	}
 	defer log.Sync()
-	repo, err := repository.NewRepository(cfg.GetString("master_dsn"), cfg.GetStringSlice("slaveDSNs"), log)
+
+	mode := cfg.GetString("mode")
+	if mode != modeAPI && mode != modeWorker && mode != modeAll {
+		log.Fatal("unknown run mode", zap.String("mode", mode))
+	}
+	log.Info("Starting", zap.String("mode", mode))
+
+	a := newApp(cfg, mode, log)
+
+	var srv *http.Server
+	if mode != modeWorker {
+		srv = startAPI(ctx, a)
+	}
+	if mode != modeAPI {
+		startWorker(ctx, a)
+	}
+
+	// В режиме worker сервера нет, и serverErr остается nil — select ждет только сигнала
+	var serverErr chan error
+	if srv != nil {
+		serverErr = make(chan error, 1)
+		go func() {
+			log.Info("Starting server", zap.String("addr", srv.Addr))
+			serverErr <- srv.ListenAndServe()
+		}()
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		log.Info("Shutdown signal received")
+	case err := <-serverErr:
+		log.Error("Failed to listen and server", zap.Error(err))
+		exitCode = 1
+	}
+	stop()
+
+	// Сервер перестает принимать соединения и дожидается текущих запросов; воркер
+	// тем временем дорабатывает или бросает свою задачу
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.GetDuration("shutdown_timeout"))
+	defer cancel()
+	if srv != nil {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error("Failed to drain HTTP requests", zap.Error(err))
+		}
+	}
+	if !waitGroup(shutdownCtx, &a.background) {
+		log.Warn("Background tasks did not stop before shutdown timeout")
+	}
+
+	a.close()
+	log.Info("Shutdown complete")
+	_ = log.Sync()
+	os.Exit(exitCode)
+}
+
+// startAPI собирает HTTP-сервер и фоновые задачи API: отправку outbox, очистку,
+// прием недоставленных сообщений и поиск задач с истекшей арендой.
+func startAPI(ctx context.Context, a *app) *http.Server {
+	cfg, log := a.cfg, a.log
+
+	produce := messagebroker.NewPriorityProducer(a.broker, a.lanes, log)
+	a.onClose("producer", produce.Close)
+
+	relay := outbox_service.NewRelay(a.repo, produce, outbox_service.Config{
+		Interval:     cfg.GetDuration("outbox.interval"),
+		BatchSize:    cfg.GetInt("outbox.batch_size"),
+		Retention:    cfg.GetDuration("outbox.retention"),
+		MaxAttempts:  cfg.GetInt("outbox.max_attempts"),
+		ClaimTimeout: cfg.GetDuration("outbox.claim_timeout"),
+	}, log)
+	a.background.Go(func() { relay.Run(ctx) })
+
+	service := image_service.NewImageService(a.repo, a.fileStorage, relay, a.hasher, image_service.Config{
+		DuplicateDistance: cfg.GetInt("duplicate_max_distance"),
+		URLTTL:            cfg.GetDuration("signing.url_ttl"),
+		IdempotencyTTL:    cfg.GetDuration("idempotency.ttl"),
+		Keys:              a.keys,
+	}, log)
+	a.background.Go(func() { service.RunCleanup(ctx, cfg.GetDuration("idempotency.cleanup_interval")) })
+
+	deadLetterConsumer := messagebroker.NewConsumer(a.broker, cfg.GetString("dead_letter.topic"), cfg.GetString("dead_letter.group_id"), log)
+	a.onClose("dead letter consumer", deadLetterConsumer.Close)
+	deadLetterService := deadletter_service.NewDeadLetterService(a.repo, deadLetterConsumer, produce, log)
+	a.background.Go(func() { deadLetterService.Run(ctx) })
+	deadLetterHandlers := handlers.NewDeadLetterHandler(deadLetterService)
+
+	reaper := reaper_service.NewReaper(a.repo, relay, reaper_service.Config{
+		Interval:        cfg.GetDuration("reaper.interval"),
+		MaxAttempts:     cfg.GetInt("reaper.max_attempts"),
+		BatchSize:       cfg.GetInt("reaper.batch_size"),
+		UnleasedTimeout: cfg.GetDuration("reaper.unleased_timeout"),
+	}, log)
+	a.background.Go(func() { reaper.Run(ctx) })
+	reaperHandlers := handlers.NewReaperHandler(reaper)
+
+	allowedNetworks, err := parsePrefixes(cfg.GetStringSlice("fetch.allowed_networks"))
 	if err != nil {
-		log.Fatal("failed to init repository", zap.Error(err))
+		log.Fatal("invalid fetch.allowed_networks", zap.Error(err))
 	}
-	fileStorage, err := storage.NewFileStorage(models.BasePath, log)
+	remoteFetcher := fetcher.New(fetcher.Config{
+		MaxSize:         cfg.GetInt64("fetch.max_size"),
+		Timeout:         cfg.GetDuration("fetch.timeout"),
+		MaxRedirects:    cfg.GetInt("fetch.max_redirects"),
+		AllowedNetworks: allowedNetworks,
+	}, log)
+	imageHandlers := handlers.NewImageHandler(service, a.fileStorage, remoteFetcher, cfg.GetInt64("uploads.max_size"))
+
+	fileHandlers := handlers.NewFileHandler(a.fileStorage, a.urlSigner)
+
+	captionTemplate, err := newCaptionTemplate(cfg)
 	if err != nil {
-		log.Fatal("failed to init file storage", zap.Error(err))
+		log.Fatal("failed to parse caption template", zap.Error(err))
 	}
+	// Рендеру на лету водяной знак не нужен, поэтому API работает без его файла
+	renderer := modifer.NewRenderer(models.BasePath, a.fileStorage, log)
+	renderService := render_service.NewRenderService(a.repo, a.fileStorage, renderer, render_service.Config{
+		MaxDimension:    uint(cfg.GetInt("render.max_dimension")),
+		AllowedWidths:   toUintSlice(cfg.GetIntSlice("render.allowed_widths")),
+		AllowedHeights:  toUintSlice(cfg.GetIntSlice("render.allowed_heights")),
+		CaptionTemplate: captionTemplate,
+	}, log)
+	renderHandlers := handlers.NewRenderHandler(renderService, a.fileStorage, a.urlSigner)
+	iiifHandlers := handlers.NewIIIFHandler(renderService, a.fileStorage, a.urlSigner)
 
-	produce := messagebroker.NewProducer(cfg.GetStringSlice("brokers"), cfg.GetString("topic"), log)
+	uploadService := upload_service.NewUploadService(a.repo, a.fileStorage, service, upload_service.Config{
+		MaxSize:     cfg.GetInt64("uploads.max_size"),
+		Expiration:  cfg.GetDuration("uploads.expiration"),
+		LockTimeout: cfg.GetDuration("uploads.lock_timeout"),
+	}, log)
+	a.background.Go(func() { uploadService.RunCleanup(ctx, cfg.GetDuration("uploads.cleanup_interval")) })
+	tusHandlers := handlers.NewTusHandler(uploadService)
 
-	service := image_service.NewImageService(repo, fileStorage, produce, log)
+	if cfg.GetString("admin.token") == "" {
+		log.Warn("Admin token is not set, /admin is disabled")
+	}
+	rout := router.NewRouter(cfg.GetString("log_level"), imageHandlers, fileHandlers, renderHandlers, iiifHandlers, tusHandlers, deadLetterHandlers, reaperHandlers, cfg.GetString("admin.token"), log)
+	return &http.Server{
+		Addr:    cfg.GetString("addr"),
+		Handler: rout.GetEngine(),
+	}
+}
 
-	modif, err := modifer.NewModifier(cfg.GetString("watermarkPath"), models.BasePath, fileStorage, log)
+// startWorker запускает обработку команд из брокера.
+func startWorker(ctx context.Context, a *app) {
+	cfg, log := a.cfg, a.log
 
+	modif, err := modifer.NewModifier(cfg.GetString("watermarkPath"), models.BasePath, a.fileStorage, log)
 	if err != nil {
 		log.Fatal("failed to init modifier", zap.Error(err))
 	}
 
-	consum := messagebroker.NewConsumer(cfg.GetStringSlice("brokers"), cfg.GetString("topic"), cfg.GetString("group_id"), log)
+	consum, err := messagebroker.NewPriorityConsumer(a.broker, cfg.GetString("group_id"), a.lanes, log)
+	if err != nil {
+		log.Fatal("failed to init consumer", zap.Error(err))
+	}
+	a.onClose("consumer", consum.Close)
 
-	defer consum.Close()
+	deadLetterProducer := messagebroker.NewDeadLetterProducer(a.broker, cfg.GetString("dead_letter.topic"), log)
+	a.onClose("dead letter producer", deadLetterProducer.Close)
 
-	worker := worker_service.NewWorker(consum, modif, repo, log)
+	worker := worker_service.NewWorker(consum, modif, a.fileStorage, a.hasher, a.repo, deadLetterProducer, worker_service.Config{
+		Retry: retry.Strategy{
+			Attempts: cfg.GetInt("worker.retry.attempts"),
+			Delay:    cfg.GetDuration("worker.retry.delay"),
+			Backoff:  cfg.GetFloat64("worker.retry.backoff"),
+		},
+		LeaseDuration: cfg.GetDuration("worker.lease_duration"),
+		ShutdownGrace: cfg.GetDuration("worker.shutdown_grace"),
+		Keys:          a.keys,
+	}, log)
 
-	go func() {
+	a.background.Go(func() {
 		if err := worker.Starting(ctx); err != nil {
-			log.Fatal("failed to start worker", zap.Error(err))
+			log.Error("worker stopped with error", zap.Error(err))
 		}
+	})
+}
+
+// waitGroup ждет wg и сообщает false, если ctx истек раньше.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
 	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-	imageHandlers := handlers.NewImageHandler(service)
+func newStorageBackend(cfg *config.Config, urlSigner storage.URLSigner, log *zap.Logger) (storage.Backend, error) {
+	switch backendType := cfg.GetString("storage.type"); backendType {
+	case "", "local":
+		return storage.NewLocalBackend(models.BasePath, urlSigner, log)
+	case "s3":
+		return storage.NewS3Backend(storage.S3Config{
+			Endpoint:  cfg.GetString("storage.s3.endpoint"),
+			Region:    cfg.GetString("storage.s3.region"),
+			Bucket:    cfg.GetString("storage.s3.bucket"),
+			AccessKey: cfg.GetString("storage.s3.access_key"),
+			SecretKey: cfg.GetString("storage.s3.secret_key"),
+			PathStyle: cfg.GetBool("storage.s3.path_style"),
+		}, log)
+	default:
+		return nil, fmt.Errorf("%w: %s", storage.ErrUnsupportedBackend, backendType)
+	}
+}
 
-	rout := router.NewRouter(cfg.GetString("log_level"), imageHandlers, log)
-	srv := &http.Server{
-		Addr:    cfg.GetString("addr"),
-		Handler: rout.GetEngine(),
+// newBroker выбирает брокер сообщений. Брокер в памяти связывает API и воркер
+// только внутри одного процесса, поэтому работает лишь в режиме all.
+func newBroker(cfg *config.Config, mode string) (messagebroker.Broker, error) {
+	switch brokerType := cfg.GetString("broker_type"); brokerType {
+	case "", "kafka":
+		return messagebroker.NewKafkaBroker(cfg.GetStringSlice("brokers")), nil
+	case "memory":
+		if mode != modeAll {
+			return nil, fmt.Errorf("in-memory broker requires mode %q, got %q", modeAll, mode)
+		}
+		return messagebroker.NewMemoryBroker(cfg.GetDuration("memory_broker.redelivery_timeout")), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", messagebroker.ErrUnsupportedBroker, brokerType)
+	}
+}
+
+// newPriorityLanes читает топики и веса приоритетов; топик обычного приоритета
+// по умолчанию совпадает с общим topic.
+func newPriorityLanes(cfg *config.Config) []messagebroker.Lane {
+	lanes := make([]messagebroker.Lane, 0, len(models.Priorities))
+	for _, priority := range models.Priorities {
+		key := "priorities." + string(priority)
+		topic := cfg.GetString(key + ".topic")
+		if priority == models.PriorityNormal {
+			topic = cmp.Or(topic, cfg.GetString("topic"))
+		}
+		lanes = append(lanes, messagebroker.Lane{
+			Priority: priority,
+			Topic:    topic,
+			Weight:   cfg.GetInt(key + ".weight"),
+		})
+	}
+	return lanes
+}
+
+func newURLSigner(cfg *config.Config) (*urlsigner.Signer, error) {
+	var keys map[string]string
+	if err := cfg.UnmarshalKey("signing.keys", &keys); err != nil {
+		return nil, fmt.Errorf("failed to read signing keys: %w", err)
+	}
+	return urlsigner.New(models.ImagesURLPrefix, keys, cfg.GetString("signing.active_key"))
+}
+
+// newCaptionTemplate разбирает шаблон текстового водяного знака; пустой шаблон отключает водяные знаки.
+func newCaptionTemplate(cfg *config.Config) (*template.Template, error) {
+	text := cfg.GetString("render.caption_template")
+	if text == "" {
+		return nil, nil
 	}
-	log.Info("Starting server", zap.String("addr", srv.Addr))
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatal("Failed to listen and server", zap.Error(err))
+	return template.New("caption").Option("missingkey=zero").Parse(text)
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	result := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse network %q: %w", v, err)
+		}
+		result = append(result, prefix)
+	}
+	return result, nil
+}
+
+func toUintSlice(values []int) []uint {
+	result := make([]uint, 0, len(values))
+	for _, v := range values {
+		if v > 0 {
+			result = append(result, uint(v))
+		}
 	}
+	return result
 }
@@ -0,0 +1,190 @@
+// Package fetcher скачивает изображения по ссылкам партнеров с защитой от SSRF.
+package fetcher
+
+import (
+	"ImageProcessor/internal/models"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInvalidURL       = errors.New("invalid url")
+	ErrBlockedAddress   = errors.New("address is not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrTooLarge         = errors.New("remote file is too large")
+	ErrUnsupportedType  = errors.New("unsupported content type")
+	ErrUpstream         = errors.New("remote server error")
+)
+
+// blockedPrefixes — диапазоны, не покрытые методами netip.Addr.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+type Config struct {
+	MaxSize      int64
+	Timeout      time.Duration
+	MaxRedirects int
+	// AllowedNetworks разрешает непубличные сети, например внутренний сервер
+	// партнера или локальный сервер в тестах.
+	AllowedNetworks []netip.Prefix
+}
+
+// Fetcher скачивает файлы только с публичных адресов. Адрес проверяется
+// после разрешения имени при каждом соединении, включая редиректы, поэтому
+// подмена DNS-записи между проверкой и подключением не помогает.
+type Fetcher struct {
+	client  *http.Client
+	maxSize int64
+	log     *zap.Logger
+}
+
+func New(cfg Config, log *zap.Logger) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+			}
+			if !isAllowed(addrPort.Addr(), cfg.AllowedNetworks) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// Прокси из окружения не используется: иначе проверялся бы адрес прокси, а не цели
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > cfg.MaxRedirects {
+					return ErrTooManyRedirects
+				}
+				return checkScheme(req.URL)
+			},
+		},
+		maxSize: cfg.MaxSize,
+		log:     log.Named("fetcher"),
+	}
+}
+
+// Fetch скачивает изображение и возвращает его содержимое и расширение,
+// определенное по сигнатуре файла.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (io.Reader, string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Host == "" {
+		return nil, "", fmt.Errorf("%w: %q", ErrInvalidURL, rawURL)
+	}
+	if err := checkScheme(target); err != nil {
+		return nil, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	req.Header.Set("Accept", "image/jpeg, image/png, image/gif")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		f.log.Warn("Failed to fetch remote file", zap.String("url", target.Redacted()), zap.Error(err))
+		switch {
+		case errors.Is(err, ErrBlockedAddress), errors.Is(err, ErrTooManyRedirects), errors.Is(err, ErrInvalidURL):
+			return nil, "", err
+		default:
+			return nil, "", fmt.Errorf("%w: %v", ErrUpstream, err)
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%w: status %d", ErrUpstream, resp.StatusCode)
+	}
+	if resp.ContentLength > f.maxSize {
+		return nil, "", fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %q", ErrUnsupportedType, resp.Header.Get("Content-Type"))
+	}
+	if _, ok := models.MediaTypeExtensions[mediaType]; !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedType, mediaType)
+	}
+
+	// Content-Length может отсутствовать или быть неверным, поэтому читаем не больше лимита
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to read body: %v", ErrUpstream, err)
+	}
+	if int64(len(body)) > f.maxSize {
+		return nil, "", fmt.Errorf("%w: more than %d bytes", ErrTooLarge, f.maxSize)
+	}
+
+	// Заголовку сервера не доверяем: тип должен совпадать с сигнатурой файла
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(body))
+	if sniffed != mediaType {
+		return nil, "", fmt.Errorf("%w: declared %s, got %s", ErrUnsupportedType, mediaType, sniffed)
+	}
+
+	f.log.Debug("Fetched remote file", zap.String("url", target.Redacted()), zap.Int("size", len(body)))
+	return bytes.NewReader(body), models.MediaTypeExtensions[mediaType], nil
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q is not allowed", ErrInvalidURL, u.Scheme)
+	}
+	return nil
+}
+
+func isAllowed(addr netip.Addr, allowed []netip.Prefix) bool {
+	for _, prefix := range allowed {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return isPublic(addr)
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
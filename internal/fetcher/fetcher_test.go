@@ -0,0 +1,261 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopback разрешает только адрес тестового сервера; остальные
+// непубличные адреса по-прежнему запрещены.
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+
+func newFetcher(allowed []netip.Prefix) *Fetcher {
+	return New(Config{
+		MaxSize:         1024,
+		Timeout:         200 * time.Millisecond,
+		MaxRedirects:    2,
+		AllowedNetworks: allowed,
+	}, zap.NewNop())
+}
+
+func encode(t *testing.T, format string) []byte {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	var buf bytes.Buffer
+	var err error
+	if format == "gif" {
+		err = gif.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func serve(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func respond(contentType string, body []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write(body)
+	}
+}
+
+func TestFetchImage(t *testing.T) {
+	body := encode(t, "png")
+	server := serve(t, respond("image/png", body))
+
+	r, ext, err := newFetcher(loopback).Fetch(context.Background(), server.URL+"/a.png")
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	if ext != ".png" || !bytes.Equal(got, body) {
+		t.Fatalf("got %d bytes with extension %q", len(got), ext)
+	}
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	server := serve(t, respond("image/png", encode(t, "png")))
+
+	// Без разрешения локальный сервер недоступен
+	if _, _, err := newFetcher(nil).Fetch(context.Background(), server.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("got %v, want ErrBlockedAddress", err)
+	}
+
+	for _, target := range []string{
+		"http://127.0.0.2/",
+		"http://10.0.0.1/",
+		"http://192.168.1.1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://100.64.0.1/",
+		"http://0.0.0.0/",
+	} {
+		t.Run(target, func(t *testing.T) {
+			// Редирект с разрешенного адреса на внутренний тоже проверяется при подключении
+			redirect := serve(t, func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, target, http.StatusFound)
+			})
+			if _, _, err := newFetcher(loopback).Fetch(context.Background(), redirect.URL); !errors.Is(err, ErrBlockedAddress) {
+				t.Fatalf("got %v, want ErrBlockedAddress", err)
+			}
+		})
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	body := encode(t, "png")
+	var server *httptest.Server
+	server = serve(t, func(w http.ResponseWriter, r *http.Request) {
+		hops, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if hops > 0 {
+			http.Redirect(w, r, server.URL+"/"+strconv.Itoa(hops-1), http.StatusFound)
+			return
+		}
+		respond("image/png", body)(w, r)
+	})
+
+	fetcher := newFetcher(loopback)
+	if _, _, err := fetcher.Fetch(context.Background(), server.URL+"/2"); err != nil {
+		t.Fatalf("redirects within the limit: %v", err)
+	}
+	if _, _, err := fetcher.Fetch(context.Background(), server.URL+"/5"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("got %v, want ErrTooManyRedirects", err)
+	}
+
+	toFile := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	if _, _, err := fetcher.Fetch(context.Background(), toFile.URL); !errors.Is(err, ErrInvalidURL) {
+		t.Fatalf("got %v, want ErrInvalidURL", err)
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	large := append(encode(t, "png"), make([]byte, 2048)...)
+
+	declared := serve(t, respond("image/png", large))
+	if _, _, err := newFetcher(loopback).Fetch(context.Background(), declared.URL); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("declared length: got %v, want ErrTooLarge", err)
+	}
+
+	// Без Content-Length размер проверяется при чтении
+	chunked := serve(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		for chunk := range chunks(large, 256) {
+			w.Write(chunk)
+			w.(http.Flusher).Flush()
+		}
+	})
+	if _, _, err := newFetcher(loopback).Fetch(context.Background(), chunked.URL); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("chunked body: got %v, want ErrTooLarge", err)
+	}
+}
+
+func chunks(data []byte, size int) func(func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for len(data) > 0 {
+			n := min(size, len(data))
+			if !yield(data[:n]) {
+				return
+			}
+			data = data[n:]
+		}
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	slowHeaders := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	start := time.Now()
+	if _, _, err := newFetcher(loopback).Fetch(context.Background(), slowHeaders.URL); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("slow headers: got %v, want ErrUpstream", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("fetch took %s despite the timeout", elapsed)
+	}
+
+	slowBody := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(encode(t, "png")[:8])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	if _, _, err := newFetcher(loopback).Fetch(context.Background(), slowBody.URL); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("slow body: got %v, want ErrUpstream", err)
+	}
+}
+
+func TestFetchContentType(t *testing.T) {
+	pngData, gifData := encode(t, "png"), encode(t, "gif")
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		ext         string
+		err         error
+	}{
+		{"gif", "image/gif", gifData, ".gif", nil},
+		{"parameters", "image/png; charset=binary", pngData, ".png", nil},
+		{"html", "text/html", []byte("<html></html>"), "", ErrUnsupportedType},
+		{"svg", "image/svg+xml", []byte("<svg/>"), "", ErrUnsupportedType},
+		{"missing", "", pngData, "", ErrUnsupportedType},
+		// Заявленный тип должен совпадать с сигнатурой
+		{"mismatch", "image/png", gifData, "", ErrUnsupportedType},
+		{"disguised html", "image/png", []byte("<html><script></script></html>"), "", ErrUnsupportedType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := serve(t, func(w http.ResponseWriter, _ *http.Request) {
+				// Пустой Content-Type иначе будет определен сервером автоматически
+				w.Header()["Content-Type"] = []string{tt.contentType}
+				w.Write(tt.body)
+			})
+			_, ext, err := newFetcher(loopback).Fetch(context.Background(), server.URL)
+			if !errors.Is(err, tt.err) || (err == nil && ext != tt.ext) {
+				t.Fatalf("got %q, %v; want %q, %v", ext, err, tt.ext, tt.err)
+			}
+		})
+	}
+}
+
+func TestFetchRejectsBadInput(t *testing.T) {
+	fetcher := newFetcher(loopback)
+	for _, target := range []string{"ftp://example.com/a.png", "file:///etc/passwd", "not a url", "/relative"} {
+		if _, _, err := fetcher.Fetch(context.Background(), target); !errors.Is(err, ErrInvalidURL) {
+			t.Errorf("%s: got %v, want ErrInvalidURL", target, err)
+		}
+	}
+
+	notFound := serve(t, http.NotFound)
+	if _, _, err := fetcher.Fetch(context.Background(), notFound.URL); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("404: got %v, want ErrUpstream", err)
+	}
+}
+
+func TestIsAllowed(t *testing.T) {
+	allowed := []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}
+	tests := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"10.1.2.3":         true,
+		"::ffff:10.1.2.3":  true,
+		"10.2.0.1":         false,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"::ffff:127.0.0.1": false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"169.254.169.254":  false,
+		"198.18.0.1":       false,
+		"224.0.0.1":        false,
+		"64:ff9b::a00:1":   false,
+	}
+	for raw, want := range tests {
+		if got := isAllowed(netip.MustParseAddr(raw), allowed); got != want {
+			t.Errorf("%s: got %v, want %v", raw, got, want)
+		}
+	}
+}
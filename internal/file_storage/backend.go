@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound              = errors.New("object not found")
+	ErrPresignNotSupported   = errors.New("presigned urls are not supported by storage backend")
+	ErrUnsupportedBackend    = errors.New("unsupported storage backend")
+	errPresignTTLNonPositive = errors.New("presign ttl must be positive")
+	// ErrDecode — файл прочитан целиком, но не является корректным изображением.
+	ErrDecode = errors.New("failed to decode image")
+)
+
+// Backend — хранилище объектов по ключу. Ключи всегда разделены "/",
+// независимо от реализации (локальный диск или S3-совместимое хранилище).
+type Backend interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	Stat(key string) (*ObjectInfo, error)
+	List(prefix string) ([]ObjectInfo, error)
+	Presign(key string, ttl time.Duration) (string, error)
+}
+
+// renamer реализуют бэкенды, умеющие перемещать объект без копирования данных.
+type renamer interface {
+	Rename(from, to string) error
+}
+
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
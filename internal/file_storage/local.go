@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// URLSigner выдает подписанные ссылки на объекты, которые отдает само приложение.
+type URLSigner interface {
+	SignURL(key string, ttl time.Duration) string
+}
+
+// LocalBackend хранит объекты в файлах внутри basePath.
+type LocalBackend struct {
+	basePath  string
+	urlSigner URLSigner
+	log       *zap.Logger
+}
+
+// NewLocalBackend создает локальное хранилище. Если urlSigner равен nil, Presign не поддерживается.
+func NewLocalBackend(basePath string, urlSigner URLSigner, log *zap.Logger) (*LocalBackend, error) {
+	info, err := os.Stat(basePath)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(basePath, 0755); err != nil {
+			return nil, fmt.Errorf("cannot create base directory %s: %w", basePath, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot stat base directory %s: %w", basePath, err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("base path %s is not a directory", basePath)
+	}
+
+	return &LocalBackend{
+		basePath:  basePath,
+		urlSigner: urlSigner,
+		log:       log.Named("local"),
+	}, nil
+}
+
+// resolve переводит ключ в путь на диске и не дает выйти за пределы basePath.
+func (b *LocalBackend) resolve(key string) (string, error) {
+	fullPath := filepath.Join(b.basePath, filepath.FromSlash(key))
+	rel, err := filepath.Rel(b.basePath, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return fullPath, nil
+}
+
+func (b *LocalBackend) Put(key string, r io.Reader) error {
+	fullPath, err := b.resolve(key)
+	if err != nil {
+		return err
+	}
+	return b.writeAtomic(fullPath, func(w io.Writer) error {
+		if _, err := io.Copy(w, r); err != nil {
+			return fmt.Errorf("failed to write data to file %s: %w", fullPath, err)
+		}
+		return nil
+	})
+}
+
+func (b *LocalBackend) Get(key string) (io.ReadCloser, error) {
+	fullPath, err := b.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to open file %s: %w", fullPath, err)
+	}
+	return file, nil
+}
+
+func (b *LocalBackend) Delete(key string) error {
+	fullPath, err := b.resolve(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fullPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return fmt.Errorf("failed to delete file %s: %w", fullPath, err)
+	}
+	return nil
+}
+
+func (b *LocalBackend) Stat(key string) (*ObjectInfo, error) {
+	fullPath, err := b.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to stat file %s: %w", fullPath, err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is a directory", ErrNotFound, key)
+	}
+	return &ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List возвращает все объекты, ключ которых начинается с prefix.
+func (b *LocalBackend) List(prefix string) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+	// Обходим только каталог, в котором может лежать префикс, а не все хранилище
+	root, err := b.resolve(prefix[:strings.LastIndex(prefix, "/")+1])
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(b.basePath, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files with prefix %s: %w", prefix, err)
+	}
+	return objects, nil
+}
+
+// Presign возвращает ссылку на обработчик приложения, подписанную HMAC.
+func (b *LocalBackend) Presign(key string, ttl time.Duration) (string, error) {
+	if b.urlSigner == nil {
+		return "", ErrPresignNotSupported
+	}
+	if ttl <= 0 {
+		return "", errPresignTTLNonPositive
+	}
+	if _, err := b.resolve(key); err != nil {
+		return "", err
+	}
+	return b.urlSigner.SignURL(key, ttl), nil
+}
+
+func (b *LocalBackend) Rename(from, to string) error {
+	fromPath, err := b.resolve(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := b.resolve(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(fromPath, toPath); err != nil {
+		return fmt.Errorf("failed to rename file %s to %s: %w", fromPath, toPath, err)
+	}
+	syncDir(filepath.Dir(toPath))
+	return nil
+}
+
+// writeAtomic пишет данные во временный файл в той же директории, делает fsync
+// и переименовывает его в итоговый путь. При любой ошибке временный файл удаляется,
+// поэтому по итоговому пути никогда не окажется недописанный файл.
+func (b *LocalBackend) writeAtomic(fullPath string, write func(w io.Writer) error) (err error) {
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		b.log.Error("Failed to create directory", zap.String("dir", dir), zap.Error(err))
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fullPath)+".tmp-*")
+	if err != nil {
+		b.log.Error("Failed to create temp file", zap.String("path", fullPath), zap.Error(err))
+		return fmt.Errorf("failed to create temp file for %s: %w", fullPath, err)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) {
+				b.log.Error("Failed to remove temp file", zap.String("path", tmpPath), zap.Error(removeErr))
+			}
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		b.log.Error("Failed to write file", zap.String("path", fullPath), zap.Error(err))
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		b.log.Error("Failed to sync file", zap.String("path", tmpPath), zap.Error(err))
+		return fmt.Errorf("failed to sync file %s: %w", tmpPath, err)
+	}
+	if err = tmp.Close(); err != nil {
+		b.log.Error("Failed to close file", zap.String("path", tmpPath), zap.Error(err))
+		return fmt.Errorf("failed to close file %s: %w", tmpPath, err)
+	}
+	if err = os.Chmod(tmpPath, 0644); err != nil {
+		return fmt.Errorf("failed to chmod file %s: %w", tmpPath, err)
+	}
+	if err = os.Rename(tmpPath, fullPath); err != nil {
+		b.log.Error("Failed to rename temp file", zap.String("from", tmpPath), zap.String("to", fullPath), zap.Error(err))
+		return fmt.Errorf("failed to rename %s to %s: %w", tmpPath, fullPath, err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir фиксирует на диске запись о переименовании файла.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	_ = d.Sync()
+}
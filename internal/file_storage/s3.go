@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3Service        = "s3"
+	s3UnsignedBody   = "UNSIGNED-PAYLOAD"
+	s3DateFormat     = "20060102T150405Z"
+	s3ShortDate      = "20060102"
+	s3RequestTimeout = time.Minute
+	s3MaxPresignTTL  = 7 * 24 * time.Hour
+)
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle включает адресацию вида endpoint/bucket/key, нужную для MinIO.
+	PathStyle bool
+}
+
+// S3Backend хранит объекты в S3-совместимом хранилище (AWS S3, MinIO).
+// Запросы подписываются AWS Signature Version 4.
+type S3Backend struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	log      *zap.Logger
+}
+
+func NewS3Backend(cfg S3Config, log *zap.Logger) (*S3Backend, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is not configured")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	b := &S3Backend{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: s3RequestTimeout},
+		log:      log.Named("s3"),
+	}
+	if err := b.ensureBucket(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *S3Backend) ensureBucket() error {
+	resp, err := b.do(http.MethodHead, "", nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket %s: %w", b.cfg.Bucket, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to check bucket %s: unexpected status %s", b.cfg.Bucket, resp.Status)
+	}
+
+	b.log.Info("Bucket does not exist, creating", zap.String("bucket", b.cfg.Bucket))
+	resp, err = b.do(http.MethodPut, "", nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create bucket %s: %w", b.cfg.Bucket, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to create bucket %s: %s", b.cfg.Bucket, readS3Error(resp))
+	}
+	return nil
+}
+
+func (b *S3Backend) Put(key string, r io.Reader) error {
+	// S3 требует Content-Length, поэтому поток сначала буферизуется во временный файл
+	tmp, err := os.CreateTemp("", "s3-upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create buffer file: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return fmt.Errorf("failed to buffer object %s: %w", key, err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind buffer file: %w", err)
+	}
+
+	resp, err := b.do(http.MethodPut, key, nil, tmp, size)
+	if err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to put object %s: %s", key, readS3Error(resp))
+	}
+	return nil
+}
+
+func (b *S3Backend) Get(key string) (io.ReadCloser, error) {
+	resp, err := b.do(http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	default:
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("failed to get object %s: %s", key, readS3Error(resp))
+	}
+}
+
+func (b *S3Backend) Delete(key string) error {
+	// S3 отвечает 204 и на удаление несуществующего объекта
+	if _, err := b.Stat(key); err != nil {
+		return err
+	}
+	resp, err := b.do(http.MethodDelete, key, nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to delete object %s: %s", key, readS3Error(resp))
+	}
+	return nil
+}
+
+func (b *S3Backend) Stat(key string) (*ObjectInfo, error) {
+	resp, err := b.do(http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object %s: %w", key, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	default:
+		return nil, fmt.Errorf("failed to stat object %s: unexpected status %s", key, resp.Status)
+	}
+
+	info := &ObjectInfo{Key: key, Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (b *S3Backend) List(prefix string) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := b.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects with prefix %s: %w", prefix, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := fmt.Errorf("failed to list objects with prefix %s: %s", prefix, readS3Error(resp))
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode list response: %w", err)
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, ObjectInfo{Key: c.Key, Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Presign возвращает ссылку на GET объекта, подписанную через query-параметры.
+func (b *S3Backend) Presign(key string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", errPresignTTLNonPositive
+	}
+	if ttl > s3MaxPresignTTL {
+		ttl = s3MaxPresignTTL
+	}
+
+	now := time.Now().UTC()
+	u := b.objectURL(key)
+	query := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {b.cfg.AccessKey + "/" + b.scope(now)},
+		"X-Amz-Date":          {now.Format(s3DateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	headers := http.Header{}
+	headers.Set("Host", u.Host)
+	signature := b.signature(http.MethodGet, u, query, headers, []string{"host"}, s3UnsignedBody, now)
+	query.Set("X-Amz-Signature", signature)
+	u.RawQuery = canonicalQuery(query)
+	return u.String(), nil
+}
+
+func (b *S3Backend) do(method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := b.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	now := time.Now().UTC()
+	req.Header.Set("Host", u.Host)
+	req.Header.Set("X-Amz-Date", now.Format(s3DateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedBody)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	signature := b.signature(method, u, query, req.Header, signedHeaders, s3UnsignedBody, now)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, b.cfg.AccessKey, b.scope(now), strings.Join(signedHeaders, ";"), signature))
+
+	return b.client.Do(req)
+}
+
+func (b *S3Backend) objectURL(key string) *url.URL {
+	u := *b.endpoint
+	if b.cfg.PathStyle {
+		u.Path = "/" + b.cfg.Bucket
+		if key != "" {
+			u.Path += "/" + key
+		}
+	} else {
+		u.Host = b.cfg.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = encodePath(u.Path)
+	return &u
+}
+
+func (b *S3Backend) scope(t time.Time) string {
+	return strings.Join([]string{t.Format(s3ShortDate), b.cfg.Region, s3Service, "aws4_request"}, "/")
+}
+
+func (b *S3Backend) signature(method string, u *url.URL, query url.Values, headers http.Header, signedHeaders []string, payloadHash string, t time.Time) string {
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		canonicalHeaders.WriteString(name)
+		canonicalHeaders.WriteString(":")
+		canonicalHeaders.WriteString(strings.TrimSpace(headers.Get(name)))
+		canonicalHeaders.WriteString("\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		encodePath(u.Path),
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		t.Format(s3DateFormat),
+		b.scope(t),
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+b.cfg.SecretKey), t.Format(s3ShortDate))
+	key = hmacSHA256(key, b.cfg.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func encodePath(path string) string {
+	return s3Escape(path, false)
+}
+
+// s3Escape кодирует строку по правилам SigV4: без изменений остаются только
+// неразрезервированные символы, "/" сохраняется в пути и кодируется в query.
+func s3Escape(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func readS3Error(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&s3Err); err == nil && s3Err.Code != "" {
+		return fmt.Sprintf("%s: %s (%s)", resp.Status, s3Err.Code, s3Err.Message)
+	}
+	return resp.Status
+}
@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 — S3-совместимый сервер в памяти процесса. Он поддерживает ровно то,
+// чем пользуется S3Backend (адресация endpoint/bucket/key, HEAD/PUT бакета,
+// PUT/GET/HEAD/DELETE объекта, ListObjectsV2 с постраничной выдачей), и как
+// настоящий S3 проверяет подписи Signature Version 4 в заголовке и в query.
+// Каноничный запрос строится независимо от кода S3Backend, иначе ошибки
+// кодирования совпали бы на обеих сторонах и тест бы их не заметил.
+type fakeS3 struct {
+	server    *httptest.Server
+	accessKey string
+	secretKey string
+	region    string
+	// pageSize — сколько ключей ListObjectsV2 отдает за одну страницу
+	pageSize int
+
+	mu      sync.Mutex
+	buckets map[string]map[string]fakeObject
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{
+		accessKey: "test-access",
+		secretKey: "test-secret",
+		region:    "us-east-1",
+		pageSize:  1000,
+		buckets:   make(map[string]map[string]fakeObject),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeS3) config(bucket string) S3Config {
+	return S3Config{
+		Endpoint:  f.server.URL,
+		Region:    f.region,
+		Bucket:    bucket,
+		AccessKey: f.accessKey,
+		SecretKey: f.secretKey,
+		PathStyle: true,
+	}
+}
+
+func (f *fakeS3) objects(bucket string) map[string]fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buckets[bucket]
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	if code, message := f.authenticate(r); code != "" {
+		writeFakeError(w, http.StatusForbidden, code, message)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	objects, exists := f.buckets[bucket]
+
+	if key == "" {
+		switch {
+		case r.Method == http.MethodHead && exists:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut && exists:
+			writeFakeError(w, http.StatusConflict, "BucketAlreadyOwnedByYou", bucket)
+		case r.Method == http.MethodPut:
+			f.buckets[bucket] = make(map[string]fakeObject)
+		case r.Method == http.MethodGet && exists && r.URL.Query().Get("list-type") == "2":
+			f.list(w, r, objects)
+		case !exists:
+			writeFakeError(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		default:
+			writeFakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+		}
+		return
+	}
+	if !exists {
+		writeFakeError(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.ContentLength < 0 || len(r.TransferEncoding) > 0 {
+			writeFakeError(w, http.StatusLengthRequired, "MissingContentLength", key)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		objects[key] = fakeObject{data: data, modTime: time.Now().UTC().Truncate(time.Second)}
+	case http.MethodGet, http.MethodHead:
+		object, ok := objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+			} else {
+				writeFakeError(w, http.StatusNotFound, "NoSuchKey", key)
+			}
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modTime.Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request, objects map[string]fakeObject) {
+	query := r.URL.Query()
+	prefix, after := query.Get("prefix"), query.Get("continuation-token")
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	}
+	result := struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Prefix                string    `xml:"Prefix"`
+		KeyCount              int       `xml:"KeyCount"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+		Contents              []content `xml:"Contents"`
+	}{Prefix: prefix}
+	if len(keys) > f.pageSize {
+		keys = keys[:f.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		object := objects[key]
+		result.Contents = append(result.Contents, content{Key: key, Size: int64(len(object.data)), LastModified: object.modTime})
+	}
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// authenticate проверяет подпись запроса и возвращает код ошибки S3, если она неверна.
+func (f *fakeS3) authenticate(r *http.Request) (code, message string) {
+	query := r.URL.Query()
+	var credential, signedHeaders, signature, date, payloadHash string
+	if query.Has("X-Amz-Signature") {
+		if query.Get("X-Amz-Algorithm") != s3Algorithm {
+			return "AuthorizationQueryParametersError", "unsupported algorithm"
+		}
+		credential, signedHeaders = query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders")
+		signature, date, payloadHash = query.Get("X-Amz-Signature"), query.Get("X-Amz-Date"), "UNSIGNED-PAYLOAD"
+		signedAt, err := time.Parse("20060102T150405Z", date)
+		expires, errExpires := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || errExpires != nil || expires > 7*24*3600 {
+			return "AuthorizationQueryParametersError", "invalid date or expiration"
+		}
+		if time.Now().After(signedAt.Add(time.Duration(expires) * time.Second)) {
+			return "AccessDenied", "Request has expired"
+		}
+		query.Del("X-Amz-Signature")
+	} else {
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), s3Algorithm+" ")
+		if !ok {
+			return "AccessDenied", "missing authorization"
+		}
+		for _, field := range strings.Split(auth, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+		date, payloadHash = r.Header.Get("X-Amz-Date"), r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return "InvalidRequest", "missing x-amz-content-sha256"
+		}
+	}
+
+	scope := strings.SplitN(credential, "/", 2)
+	if len(scope) != 2 || scope[0] != f.accessKey {
+		return "InvalidAccessKeyId", credential
+	}
+	if len(date) < 8 || scope[1] != date[:8]+"/"+f.region+"/s3/aws4_request" {
+		return "AuthorizationHeaderMalformed", "bad credential scope " + scope[1]
+	}
+
+	headers := strings.Split(signedHeaders, ";")
+	if !slices.Contains(headers, "host") {
+		return "AccessDenied", "host header must be signed"
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		fakeEscape(r.URL.Path, false),
+		fakeCanonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{s3Algorithm, date, scope[1], hexSHA256([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + f.secretKey)
+	for _, part := range []string{date[:8], f.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	expected := fmt.Sprintf("%x", hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "SignatureDoesNotMatch", "canonical request:\n" + canonicalRequest
+	}
+	return "", ""
+}
+
+func fakeCanonicalQuery(query url.Values) string {
+	type pair struct{ key, value string }
+	pairs := make([]pair, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, pair{fakeEscape(key, true), fakeEscape(value, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return pairs[i].value < pairs[j].value
+	})
+	parts := make([]string, len(pairs))
+	for i, p := range pairs {
+		parts[i] = p.key + "=" + p.value
+	}
+	return strings.Join(parts, "&")
+}
+
+// fakeEscape кодирует строку по правилам SigV4 через стандартную библиотеку.
+func fakeEscape(s string, query bool) string {
+	escaped := strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	escaped = strings.ReplaceAll(escaped, "%7E", "~")
+	if !query {
+		escaped = strings.ReplaceAll(escaped, "%2F", "/")
+	}
+	return escaped
+}
+
+func writeFakeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"go.uber.org/zap"
 	"image"
@@ -8,119 +9,216 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
-	"os"
-	"path/filepath"
+	"time"
 )
 
+// FileStorage — работа с изображениями поверх произвольного Backend.
 type FileStorage struct {
-	basePath string
-	log      *zap.Logger
+	backend Backend
+	log     *zap.Logger
 }
 
-func NewFileStorage(basePath string, log *zap.Logger) (*FileStorage, error) {
-	info, err := os.Stat(basePath)
-	if os.IsNotExist(err) {
-		if err := os.MkdirAll(basePath, 0755); err != nil {
-			return nil, fmt.Errorf("cannot create base directory %s: %w", basePath, err)
-		}
-	} else if !info.IsDir() {
-		return nil, fmt.Errorf("base path %s is not a directory", basePath)
-	}
-
+func NewFileStorage(backend Backend, log *zap.Logger) *FileStorage {
 	return &FileStorage{
-		basePath: basePath,
-		log:      log.Named("file"),
-	}, nil
+		backend: backend,
+		log:     log.Named("file"),
+	}
 }
 
 func (fs *FileStorage) Save(path string, image io.Reader) error {
-	fullPath := filepath.Join(fs.basePath, path)
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		fs.log.Error("Failed to create directory", zap.String("dir", dir), zap.Error(err))
-		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	if err := fs.backend.Put(path, image); err != nil {
+		fs.log.Error("Failed to save file", zap.String("path", path), zap.Error(err))
+		return fmt.Errorf("failed to save file %s: %w", path, err)
 	}
+	return nil
+}
 
-	file, err := os.Create(fullPath)
-	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", fullPath, err)
-	}
-	defer file.Close()
+func (fs *FileStorage) Delete(path string) error {
+	fs.log.Info("Attempting to delete file", zap.String("path", path))
 
-	_, err = io.Copy(file, image)
+	err := fs.backend.Delete(path)
 	if err != nil {
-		return fmt.Errorf("failed to write data to file %s: %w", fullPath, err)
+		if errors.Is(err, ErrNotFound) {
+			fs.log.Warn("Attempted to delete a file that does not exist", zap.String("path", path))
+			return nil
+		}
+		fs.log.Error("Failed to delete file", zap.String("path", path), zap.Error(err))
+		return fmt.Errorf("failed to delete file %s: %w", path, err)
 	}
 
+	fs.log.Info("Successfully deleted file", zap.String("path", path))
 	return nil
 }
 
-func (fs *FileStorage) Delete(path string) error {
-	fullPath := filepath.Join(fs.basePath, path)
-	fs.log.Info("Attempting to delete file", zap.String("path", fullPath))
+func (fs *FileStorage) Exists(path string) (bool, error) {
+	_, err := fs.backend.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to stat file %s: %w", path, err)
+}
+
+func (fs *FileStorage) Stat(path string) (*ObjectInfo, error) {
+	return fs.backend.Stat(path)
+}
+
+func (fs *FileStorage) Open(path string) (io.ReadCloser, error) {
+	return fs.backend.Get(path)
+}
+
+func (fs *FileStorage) List(prefix string) ([]ObjectInfo, error) {
+	return fs.backend.List(prefix)
+}
 
-	err := os.Remove(fullPath)
+// DeletePrefix удаляет все объекты, ключ которых начинается с prefix.
+func (fs *FileStorage) DeletePrefix(prefix string) error {
+	objects, err := fs.backend.List(prefix)
 	if err != nil {
-		if os.IsNotExist(err) {
-			fs.log.Warn("Attempted to delete a file that does not exist", zap.String("path", fullPath))
-			return nil
+		fs.log.Error("Failed to list files for deletion", zap.String("prefix", prefix), zap.Error(err))
+		return fmt.Errorf("failed to list files with prefix %s: %w", prefix, err)
+	}
+	var errs []error
+	for _, object := range objects {
+		if err := fs.Delete(object.Key); err != nil {
+			errs = append(errs, err)
 		}
-		fs.log.Error("Failed to delete file", zap.String("path", fullPath), zap.Error(err))
-		return fmt.Errorf("failed to delete file %s: %w", fullPath, err)
 	}
+	return errors.Join(errs...)
+}
 
-	fs.log.Info("Successfully deleted file", zap.String("path", fullPath))
-	return nil
+func (fs *FileStorage) Presign(path string, ttl time.Duration) (string, error) {
+	return fs.backend.Presign(path, ttl)
 }
 
-// LoadImage загружает файл с диска и декодирует его в image.Image.
+// Rename перемещает файл внутри хранилища. Если бэкенд не умеет перемещать
+// объекты, файл копируется и исходный удаляется.
+func (fs *FileStorage) Rename(from, to string) error {
+	if r, ok := fs.backend.(renamer); ok {
+		if err := r.Rename(from, to); err != nil {
+			fs.log.Error("Failed to rename file", zap.String("from", from), zap.String("to", to), zap.Error(err))
+			return fmt.Errorf("failed to rename file %s to %s: %w", from, to, err)
+		}
+		return nil
+	}
+
+	src, err := fs.backend.Get(from)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", from, err)
+	}
+	defer src.Close()
+	if err := fs.backend.Put(to, src); err != nil {
+		fs.log.Error("Failed to copy file", zap.String("from", from), zap.String("to", to), zap.Error(err))
+		return fmt.Errorf("failed to copy file %s to %s: %w", from, to, err)
+	}
+	return fs.Delete(from)
+}
+
+// LoadImage загружает файл из хранилища и декодирует его в image.Image.
 func (fs *FileStorage) LoadImage(path string) (image.Image, string, error) {
-	fullPath := filepath.Join(fs.basePath, path)
-	file, err := os.Open(fullPath)
+	file, err := fs.backend.Get(path)
 	if err != nil {
-		fs.log.Error("Failed to open image file", zap.String("path", fullPath), zap.Error(err))
-		return nil, "", fmt.Errorf("failed to open file %s: %w", fullPath, err)
+		fs.log.Error("Failed to open image file", zap.String("path", path), zap.Error(err))
+		return nil, "", fmt.Errorf("failed to open file %s: %w", path, err)
 	}
 	defer file.Close()
 
 	// image.Decode сам определяет формат (jpeg, png, gif) по сигнатуре файла
-	img, format, err := image.Decode(file)
+	src := &readErrorRecorder{r: file}
+	img, format, err := image.Decode(src)
 	if err != nil {
-		fs.log.Error("Failed to decode image", zap.String("path", fullPath), zap.Error(err))
-		return nil, "", fmt.Errorf("failed to decode image %s: %w", fullPath, err)
+		fs.log.Error("Failed to decode image", zap.String("path", path), zap.Error(err))
+		return nil, "", decodeError(path, src, err)
 	}
 
-	fs.log.Debug("Successfully loaded image", zap.String("path", fullPath), zap.String("format", format))
+	fs.log.Debug("Successfully loaded image", zap.String("path", path), zap.String("format", format))
 	return img, format, nil
 }
 
-// SaveImage сохраняет image.Image в файл, кодируя его в нужный формат.
-func (fs *FileStorage) SaveImage(path string, img image.Image, format string) error {
-	fullPath := filepath.Join(fs.basePath, path)
+// ImageSize читает только заголовок файла и возвращает размеры изображения.
+func (fs *FileStorage) ImageSize(path string) (int, int, error) {
+	file, err := fs.backend.Get(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer file.Close()
 
-	// Убедимся, что директория для сохранения существует
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
-		fs.log.Error("Failed to create directory for saving image", zap.String("path", fullPath), zap.Error(err))
-		return fmt.Errorf("failed to create directory: %w", err)
+	src := &readErrorRecorder{r: file}
+	cfg, _, err := image.DecodeConfig(src)
+	if err != nil {
+		fs.log.Error("Failed to decode image config", zap.String("path", path), zap.Error(err))
+		return 0, 0, decodeError(path, src, err)
 	}
+	return cfg.Width, cfg.Height, nil
+}
 
-	file, err := os.Create(fullPath)
+// readErrorRecorder запоминает ошибку чтения, чтобы отличить обрыв связи с
+// хранилищем от испорченного файла: декодер возвращает их одинаково.
+type readErrorRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrorRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// decodeError оборачивает ошибку декодера в ErrDecode, только если файл был прочитан без ошибок.
+func decodeError(path string, src *readErrorRecorder, err error) error {
+	if src.err != nil {
+		return fmt.Errorf("failed to read image %s: %w", path, src.err)
+	}
+	return fmt.Errorf("%w %s: %w", ErrDecode, path, err)
+}
+
+// SaveImage сохраняет image.Image в файл, кодируя его в нужный формат.
+// Изображение кодируется прямо в поток записи бэкенда; при ошибке кодирования
+// запись прерывается и недописанный файл не сохраняется.
+func (fs *FileStorage) SaveImage(path string, img image.Image, format string) error {
+	encode, err := encoderFor(format)
 	if err != nil {
-		fs.log.Error("Failed to create file for saving image", zap.String("path", fullPath), zap.Error(err))
-		return fmt.Errorf("failed to create file: %w", err)
+		fs.log.Error("Unsupported image format for saving", zap.String("format", format))
+		return err
 	}
-	defer file.Close()
 
+	pr, pw := io.Pipe()
+	go func() {
+		if err := encode(pw, img); err != nil {
+			pw.CloseWithError(fmt.Errorf("failed to encode %s image: %w", format, err))
+			return
+		}
+		pw.Close()
+	}()
+
+	if err := fs.backend.Put(path, pr); err != nil {
+		// Разблокируем кодировщик, если бэкенд перестал читать поток
+		pr.CloseWithError(err)
+		fs.log.Error("Failed to save image", zap.String("path", path), zap.Error(err))
+		return fmt.Errorf("failed to save image %s: %w", path, err)
+	}
+	return nil
+}
+
+func encoderFor(format string) (func(w io.Writer, img image.Image) error, error) {
 	// Кодируем изображение в зависимости от его оригинального формата
 	switch format {
 	case "jpeg":
-		return jpeg.Encode(file, img, &jpeg.Options{Quality: 90})
+		return func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+		}, nil
 	case "png":
-		return png.Encode(file, img)
+		return png.Encode, nil
 	case "gif":
-		return gif.Encode(file, img, nil)
+		return func(w io.Writer, img image.Image) error {
+			return gif.Encode(w, img, nil)
+		}, nil
 	default:
-		fs.log.Error("Unsupported image format for saving", zap.String("format", format))
-		return fmt.Errorf("unsupported format for saving: %s", format)
+		return nil, fmt.Errorf("unsupported format for saving: %s", format)
 	}
 }
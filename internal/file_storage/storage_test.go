@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// brokenBackend отдает файл, чтение которого обрывается после первых байт.
+type brokenBackend struct {
+	Backend
+	data string
+}
+
+func (b brokenBackend) Get(string) (io.ReadCloser, error) {
+	return io.NopCloser(io.MultiReader(strings.NewReader(b.data), errReader{})), nil
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, syscall.ECONNRESET }
+
+func TestLoadImageClassifiesErrors(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewFileStorage(backend, zap.NewNop())
+
+	// Испорченный GIF с верной сигнатурой: повтор не поможет
+	for name, data := range map[string]string{
+		"truncated.gif": "GIF89a\x10\x00\x10\x00",
+		"garbage.png":   "not an image at all",
+	} {
+		if err := fs.Save(name, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := fs.LoadImage(name); !errors.Is(err, ErrDecode) {
+			t.Errorf("%s: got %v, want ErrDecode", name, err)
+		}
+		if _, _, err := fs.ImageSize(name); !errors.Is(err, ErrDecode) {
+			t.Errorf("%s size: got %v, want ErrDecode", name, err)
+		}
+	}
+
+	// Обрыв чтения — ошибка хранилища, а не файла
+	broken := NewFileStorage(brokenBackend{data: "GIF89a"}, zap.NewNop())
+	_, _, err = broken.LoadImage("any.gif")
+	if errors.Is(err, ErrDecode) || !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("interrupted read: got %v", err)
+	}
+}
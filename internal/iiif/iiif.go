@@ -0,0 +1,405 @@
+// Package iiif разбирает запросы IIIF Image API 3.0 и переводит их в план преобразования изображения.
+package iiif
+
+import (
+	"ImageProcessor/internal/models"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	Context     = "http://iiif.io/api/image/3/context.json"
+	Protocol    = "http://iiif.io/api/image"
+	ProfileLink = "http://iiif.io/api/image/3/level1.json"
+	Profile     = "level1"
+
+	QualityDefault = "default"
+	QualityColor   = "color"
+	QualityGray    = "gray"
+)
+
+var (
+	// ErrBadRequest — синтаксически неверный запрос или запрос за пределами изображения (400).
+	ErrBadRequest = errors.New("invalid iiif request")
+	// ErrNotImplemented — корректный запрос, который сервер не поддерживает (501).
+	ErrNotImplemented = errors.New("iiif feature is not implemented")
+
+	formats = map[string]string{
+		"jpg": "jpeg",
+		"png": "png",
+		"gif": "gif",
+	}
+)
+
+type regionKind int
+
+const (
+	regionFull regionKind = iota
+	regionSquare
+	regionPixels
+	regionPercent
+)
+
+type sizeKind int
+
+const (
+	sizeMax sizeKind = iota
+	sizeWidth
+	sizeHeight
+	sizePercent
+	sizeExact
+	sizeConfined
+)
+
+// Request — разобранные параметры URI вида {region}/{size}/{rotation}/{quality}.{format}.
+type Request struct {
+	region       regionKind
+	regionValues [4]float64
+
+	size       sizeKind
+	upscale    bool
+	sizeWidth  float64
+	sizeHeight float64
+
+	Rotation int
+	Mirror   bool
+	Quality  string
+	Format   string
+}
+
+// Limits ограничивают размер результата.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+}
+
+// Info — документ info.json для изображения.
+type Info struct {
+	Context        string   `json:"@context"`
+	ID             string   `json:"id"`
+	Type           string   `json:"type"`
+	Protocol       string   `json:"protocol"`
+	Profile        string   `json:"profile"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	MaxWidth       int      `json:"maxWidth,omitempty"`
+	MaxHeight      int      `json:"maxHeight,omitempty"`
+	ExtraQualities []string `json:"extraQualities"`
+	ExtraFormats   []string `json:"extraFormats"`
+	ExtraFeatures  []string `json:"extraFeatures"`
+}
+
+func NewInfo(id string, width, height int, limits Limits) *Info {
+	return &Info{
+		Context:        Context,
+		ID:             id,
+		Type:           "ImageService3",
+		Protocol:       Protocol,
+		Profile:        Profile,
+		Width:          width,
+		Height:         height,
+		MaxWidth:       limits.MaxWidth,
+		MaxHeight:      limits.MaxHeight,
+		ExtraQualities: []string{QualityColor, QualityGray},
+		ExtraFormats:   []string{"png", "gif"},
+		ExtraFeatures: []string{
+			"baseUriRedirect", "cors", "jsonldMediaType", "mirroring",
+			"regionByPct", "regionByPx", "regionSquare", "rotationBy90s",
+			"sizeByConfinedWh", "sizeByH", "sizeByPct", "sizeByW", "sizeByWh", "sizeUpscaling",
+		},
+	}
+}
+
+// Parse разбирает параметры запроса изображения.
+func Parse(region, size, rotation, qualityFormat string) (*Request, error) {
+	req := &Request{}
+	if err := req.parseRegion(region); err != nil {
+		return nil, err
+	}
+	if err := req.parseSize(size); err != nil {
+		return nil, err
+	}
+	if err := req.parseRotation(rotation); err != nil {
+		return nil, err
+	}
+	if err := req.parseQualityFormat(qualityFormat); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (r *Request) parseRegion(region string) error {
+	switch {
+	case region == "full":
+		r.region = regionFull
+	case region == "square":
+		r.region = regionSquare
+	case strings.HasPrefix(region, "pct:"):
+		r.region = regionPercent
+		values, err := parseNumbers(strings.TrimPrefix(region, "pct:"), 4, true)
+		if err != nil {
+			return fmt.Errorf("%w: region %q", ErrBadRequest, region)
+		}
+		copy(r.regionValues[:], values)
+	default:
+		r.region = regionPixels
+		values, err := parseNumbers(region, 4, false)
+		if err != nil {
+			return fmt.Errorf("%w: region %q", ErrBadRequest, region)
+		}
+		copy(r.regionValues[:], values)
+	}
+	if r.region == regionPixels || r.region == regionPercent {
+		if r.regionValues[2] <= 0 || r.regionValues[3] <= 0 {
+			return fmt.Errorf("%w: region %q has zero width or height", ErrBadRequest, region)
+		}
+	}
+	return nil
+}
+
+func (r *Request) parseSize(size string) error {
+	if strings.HasPrefix(size, "^") {
+		r.upscale = true
+		size = size[1:]
+	}
+
+	switch {
+	case size == "max":
+		r.size = sizeMax
+		return nil
+	case strings.HasPrefix(size, "pct:"):
+		values, err := parseNumbers(strings.TrimPrefix(size, "pct:"), 1, true)
+		if err != nil || values[0] <= 0 {
+			return fmt.Errorf("%w: size %q", ErrBadRequest, size)
+		}
+		r.size = sizePercent
+		r.sizeWidth = values[0]
+		return nil
+	}
+
+	confined := strings.HasPrefix(size, "!")
+	size = strings.TrimPrefix(size, "!")
+	parts := strings.Split(size, ",")
+	if len(parts) != 2 {
+		return fmt.Errorf("%w: size %q", ErrBadRequest, size)
+	}
+	width, errW := parseDimension(parts[0])
+	height, errH := parseDimension(parts[1])
+	if errW != nil || errH != nil {
+		return fmt.Errorf("%w: size %q", ErrBadRequest, size)
+	}
+
+	switch {
+	case confined && width > 0 && height > 0:
+		r.size = sizeConfined
+	case confined:
+		return fmt.Errorf("%w: confined size requires width and height", ErrBadRequest)
+	case width > 0 && height > 0:
+		r.size = sizeExact
+	case width > 0:
+		r.size = sizeWidth
+	case height > 0:
+		r.size = sizeHeight
+	default:
+		return fmt.Errorf("%w: size %q", ErrBadRequest, size)
+	}
+	r.sizeWidth = float64(width)
+	r.sizeHeight = float64(height)
+	return nil
+}
+
+func (r *Request) parseRotation(rotation string) error {
+	if strings.HasPrefix(rotation, "!") {
+		r.Mirror = true
+		rotation = rotation[1:]
+	}
+	degrees, err := strconv.ParseFloat(rotation, 64)
+	if err != nil || degrees < 0 || degrees > 360 {
+		return fmt.Errorf("%w: rotation %q", ErrBadRequest, rotation)
+	}
+	if math.Mod(degrees, 90) != 0 {
+		return fmt.Errorf("%w: rotation by %s degrees", ErrNotImplemented, rotation)
+	}
+	r.Rotation = int(degrees) % 360
+	return nil
+}
+
+func (r *Request) parseQualityFormat(qualityFormat string) error {
+	dot := strings.LastIndex(qualityFormat, ".")
+	if dot <= 0 || dot == len(qualityFormat)-1 {
+		return fmt.Errorf("%w: quality and format %q", ErrBadRequest, qualityFormat)
+	}
+	quality, format := qualityFormat[:dot], qualityFormat[dot+1:]
+
+	switch quality {
+	case QualityDefault, QualityColor, QualityGray:
+		r.Quality = quality
+	case "bitonal":
+		return fmt.Errorf("%w: quality %q", ErrNotImplemented, quality)
+	default:
+		return fmt.Errorf("%w: quality %q", ErrBadRequest, quality)
+	}
+
+	decoderFormat, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("%w: format %q", ErrNotImplemented, format)
+	}
+	r.Format = decoderFormat
+	return nil
+}
+
+// WholeImage сообщает, что запрошено все изображение или его центральный квадрат.
+func (r *Request) WholeImage() bool {
+	return r.region == regionFull || r.region == regionSquare
+}
+
+// MaxSize сообщает, что запрошен максимальный размер без увеличения.
+func (r *Request) MaxSize() bool {
+	return r.size == sizeMax && !r.upscale
+}
+
+// Plan вычисляет итоговые область и размер для изображения width x height.
+func (r *Request) Plan(width, height int, limits Limits) (models.TransformPlan, error) {
+	crop, err := r.cropRect(width, height)
+	if err != nil {
+		return models.TransformPlan{}, err
+	}
+	outW, outH, err := r.outputSize(crop.Dx(), crop.Dy(), limits)
+	if err != nil {
+		return models.TransformPlan{}, err
+	}
+	return models.TransformPlan{
+		Crop:      crop,
+		Width:     uint(outW),
+		Height:    uint(outH),
+		Mirror:    r.Mirror,
+		Rotation:  r.Rotation,
+		Grayscale: r.Quality == QualityGray,
+		Format:    r.Format,
+	}, nil
+}
+
+func (r *Request) cropRect(width, height int) (image.Rectangle, error) {
+	bounds := image.Rect(0, 0, width, height)
+	var rect image.Rectangle
+	switch r.region {
+	case regionFull:
+		return bounds, nil
+	case regionSquare:
+		side := min(width, height)
+		x, y := (width-side)/2, (height-side)/2
+		return image.Rect(x, y, x+side, y+side), nil
+	case regionPixels:
+		v := r.regionValues
+		rect = image.Rect(int(v[0]), int(v[1]), int(v[0]+v[2]), int(v[1]+v[3]))
+	case regionPercent:
+		v := r.regionValues
+		x := int(math.Round(v[0] / 100 * float64(width)))
+		y := int(math.Round(v[1] / 100 * float64(height)))
+		w := int(math.Round(v[2] / 100 * float64(width)))
+		h := int(math.Round(v[3] / 100 * float64(height)))
+		rect = image.Rect(x, y, x+w, y+h)
+	}
+
+	// Область, выходящая за край, обрезается; полностью вне изображения — ошибка
+	rect = rect.Intersect(bounds)
+	if rect.Empty() {
+		return image.Rectangle{}, fmt.Errorf("%w: region is outside of the image", ErrBadRequest)
+	}
+	return rect, nil
+}
+
+func (r *Request) outputSize(regionW, regionH int, limits Limits) (int, int, error) {
+	w, h := float64(regionW), float64(regionH)
+	var outW, outH float64
+	switch r.size {
+	case sizeMax:
+		outW, outH = w, h
+		if r.upscale {
+			scale := math.Min(float64(limits.MaxWidth)/w, float64(limits.MaxHeight)/h)
+			outW, outH = w*scale, h*scale
+		}
+	case sizeWidth:
+		outW, outH = r.sizeWidth, h*r.sizeWidth/w
+	case sizeHeight:
+		outW, outH = w*r.sizeHeight/h, r.sizeHeight
+	case sizePercent:
+		outW, outH = w*r.sizeWidth/100, h*r.sizeWidth/100
+	case sizeExact:
+		outW, outH = r.sizeWidth, r.sizeHeight
+	case sizeConfined:
+		scale := math.Min(r.sizeWidth/w, r.sizeHeight/h)
+		outW, outH = w*scale, h*scale
+	}
+
+	width := max(int(math.Round(outW)), 1)
+	height := max(int(math.Round(outH)), 1)
+	if !r.upscale && (width > regionW || height > regionH) {
+		return 0, 0, fmt.Errorf("%w: size exceeds region without upscaling", ErrBadRequest)
+	}
+	if r.size == sizeMax && !r.upscale {
+		// max без ^ не увеличивает изображение, но ограничивается лимитами сервера
+		scale := math.Min(1, math.Min(float64(limits.MaxWidth)/float64(width), float64(limits.MaxHeight)/float64(height)))
+		width = max(int(float64(width)*scale), 1)
+		height = max(int(float64(height)*scale), 1)
+	}
+	if width > limits.MaxWidth || height > limits.MaxHeight {
+		return 0, 0, fmt.Errorf("%w: size exceeds server limits", ErrBadRequest)
+	}
+	return width, height, nil
+}
+
+// CacheKey строит ключ кэша из вычисленного плана, поэтому эквивалентные
+// запросы (например, full и 0,0,w,h) разделяют одну запись.
+func CacheKey(plan models.TransformPlan) string {
+	mirror := ""
+	if plan.Mirror {
+		mirror = "m"
+	}
+	quality := QualityDefault
+	if plan.Grayscale {
+		quality = QualityGray
+	}
+	return fmt.Sprintf("%d,%d,%d,%d_%d,%d_%s%d_%s.%s",
+		plan.Crop.Min.X, plan.Crop.Min.Y, plan.Crop.Dx(), plan.Crop.Dy(),
+		plan.Width, plan.Height, mirror, plan.Rotation, quality, models.FormatExtension(plan.Format))
+}
+
+func parseNumbers(raw string, count int, allowFloat bool) ([]float64, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != count {
+		return nil, fmt.Errorf("expected %d values", count)
+	}
+	values := make([]float64, count)
+	for i, part := range parts {
+		var value float64
+		var err error
+		if allowFloat {
+			value, err = strconv.ParseFloat(part, 64)
+		} else {
+			var n uint64
+			n, err = strconv.ParseUint(part, 10, 32)
+			value = float64(n)
+		}
+		if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, fmt.Errorf("invalid value %q", part)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func parseDimension(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil || value == 0 {
+		return 0, fmt.Errorf("invalid dimension %q", raw)
+	}
+	return int(value), nil
+}
@@ -0,0 +1,172 @@
+package imagehash
+
+import (
+	"ImageProcessor/internal/models"
+	"fmt"
+	"github.com/nfnt/resize"
+	"go.uber.org/zap"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	hashSize = 8
+	dctSize  = 32
+)
+
+type Storage interface {
+	LoadImage(path string) (image.Image, string, error)
+}
+
+type Hasher struct {
+	storage Storage
+	log     *zap.Logger
+}
+
+func NewHasher(storage Storage, log *zap.Logger) *Hasher {
+	return &Hasher{
+		storage: storage,
+		log:     log.Named("hasher"),
+	}
+}
+
+// Hash загружает изображение из хранилища и считает для него aHash, dHash и pHash.
+func (h *Hasher) Hash(path string) (*models.ImageHash, error) {
+	img, _, err := h.storage.LoadImage(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image for hashing: %w", err)
+	}
+	hash := Compute(img)
+	h.log.Debug("Computed image hashes", zap.String("path", path), zap.Uint64("phash", hash.PHash))
+	return hash, nil
+}
+
+// Compute считает все три перцептивных хэша изображения.
+func Compute(img image.Image) *models.ImageHash {
+	return &models.ImageHash{
+		AHash: AverageHash(img),
+		DHash: DifferenceHash(img),
+		PHash: PerceptionHash(img),
+	}
+}
+
+// Distance возвращает расстояние Хэмминга между двумя хэшами.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// AverageHash сравнивает каждый пиксель уменьшенного до 8x8 изображения со средней яркостью.
+func AverageHash(img image.Image) uint64 {
+	pixels := grayscale(img, hashSize, hashSize)
+	var sum float64
+	for _, p := range pixels {
+		sum += p
+	}
+	mean := sum / float64(len(pixels))
+
+	var hash uint64
+	for i, p := range pixels {
+		if p > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// DifferenceHash сравнивает соседние по горизонтали пиксели изображения 9x8.
+func DifferenceHash(img image.Image) uint64 {
+	width := hashSize + 1
+	pixels := grayscale(img, width, hashSize)
+
+	var hash uint64
+	bit := 0
+	for y := 0; y < hashSize; y++ {
+		for x := 0; x < hashSize; x++ {
+			if pixels[y*width+x] < pixels[y*width+x+1] {
+				hash |= 1 << uint(bit)
+			}
+			bit++
+		}
+	}
+	return hash
+}
+
+// PerceptionHash строит хэш по низкочастотным коэффициентам DCT изображения 32x32.
+func PerceptionHash(img image.Image) uint64 {
+	pixels := grayscale(img, dctSize, dctSize)
+	coefficients := dct2D(pixels, dctSize)
+
+	// Берем левый верхний блок 8x8 без DC-компоненты
+	lowFreq := make([]float64, 0, hashSize*hashSize)
+	for y := 0; y < hashSize; y++ {
+		for x := 0; x < hashSize; x++ {
+			lowFreq = append(lowFreq, coefficients[y*dctSize+x])
+		}
+	}
+	median := medianOf(lowFreq[1:])
+
+	var hash uint64
+	for i, c := range lowFreq {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+func grayscale(img image.Image, width, height int) []float64 {
+	small := resize.Resize(uint(width), uint(height), img, resize.Bilinear)
+	bounds := small.Bounds()
+	pixels := make([]float64, 0, width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := small.At(x, y).RGBA()
+			pixels = append(pixels, 0.299*float64(r>>8)+0.587*float64(g>>8)+0.114*float64(b>>8))
+		}
+	}
+	return pixels
+}
+
+func dct2D(pixels []float64, n int) []float64 {
+	cosTable := make([]float64, n*n)
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			cosTable[k*n+i] = math.Cos(math.Pi / float64(n) * (float64(i) + 0.5) * float64(k))
+		}
+	}
+
+	rows := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for x := 0; x < n; x++ {
+				sum += pixels[y*n+x] * cosTable[k*n+x]
+			}
+			rows[y*n+k] = sum
+		}
+	}
+
+	result := make([]float64, n*n)
+	for x := 0; x < n; x++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				sum += rows[y*n+x] * cosTable[k*n+y]
+			}
+			result[k*n+x] = sum
+		}
+	}
+	return result
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,48 @@
+package messagebroker
+
+import (
+	"context"
+	"errors"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"github.com/wb-go/wbf/kafka"
+)
+
+var ErrUnsupportedBroker = errors.New("unsupported message broker")
+
+// Writer пишет сообщения в один топик; ему соответствует *kafka.Writer.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafkaGo.Message) error
+	Close() error
+}
+
+// Reader читает один топик в составе группы; ему соответствует *kafka.Reader.
+// Неподтвержденные сообщения группа получит снова.
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafkaGo.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafkaGo.Message) error
+	Close() error
+}
+
+// Broker создает писателей и читателей топиков, на которых построены
+// продюсеры и потребители этого пакета.
+type Broker interface {
+	Writer(topic string) Writer
+	Reader(topic, groupID string) Reader
+}
+
+// KafkaBroker подключается к кластеру Kafka.
+type KafkaBroker struct {
+	brokers []string
+}
+
+func NewKafkaBroker(brokers []string) *KafkaBroker {
+	return &KafkaBroker{brokers: brokers}
+}
+
+func (b *KafkaBroker) Writer(topic string) Writer {
+	return kafka.NewProducer(b.brokers, topic).Writer
+}
+
+func (b *KafkaBroker) Reader(topic, groupID string) Reader {
+	return kafka.NewConsumer(b.brokers, topic, groupID).Reader
+}
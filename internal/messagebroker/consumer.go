@@ -5,24 +5,26 @@ import (
 	"context"
 	"fmt"
 	kafkaGo "github.com/segmentio/kafka-go"
-	"github.com/wb-go/wbf/kafka"
+	"github.com/wb-go/wbf/retry"
 	"go.uber.org/zap"
 )
 
 type Consumer struct {
-	consume *kafka.Consumer
-	log     *zap.Logger
+	reader Reader
+	log    *zap.Logger
 }
 
-func NewConsumer(brokers []string, topic string, groupID string, log *zap.Logger) *Consumer {
-	consume := kafka.NewConsumer(brokers, topic, groupID)
-
-	return &Consumer{consume: consume, log: log.Named("consumer")}
+func NewConsumer(broker Broker, topic string, groupID string, log *zap.Logger) *Consumer {
+	return &Consumer{reader: broker.Reader(topic, groupID), log: log.Named("consumer")}
 }
 
 func (c *Consumer) FetchMessage(ctx context.Context) (kafkaGo.Message, error) {
 	c.log.Debug("Fetching message")
-	msg, err := c.consume.FetchWithRetry(ctx, models.RetryStrategy)
+	var msg kafkaGo.Message
+	err := retry.Do(func() (err error) {
+		msg, err = c.reader.FetchMessage(ctx)
+		return err
+	}, models.RetryStrategy)
 	if err != nil {
 		c.log.Error("Error fetching message", zap.Error(err))
 		return kafkaGo.Message{}, fmt.Errorf("error fetching message: %w", err)
@@ -33,7 +35,7 @@ func (c *Consumer) FetchMessage(ctx context.Context) (kafkaGo.Message, error) {
 
 func (c *Consumer) CommitMessage(ctx context.Context, msg kafkaGo.Message) error {
 	c.log.Debug("Committing message", zap.ByteString("message", msg.Value))
-	err := c.consume.Commit(ctx, msg)
+	err := c.reader.CommitMessages(ctx, msg)
 	if err != nil {
 		c.log.Error("Error committing message", zap.Error(err))
 		return fmt.Errorf("error committing message: %w", err)
@@ -44,5 +46,5 @@ func (c *Consumer) CommitMessage(ctx context.Context, msg kafkaGo.Message) error
 }
 
 func (c *Consumer) Close() error {
-	return c.consume.Close()
+	return c.reader.Close()
 }
@@ -0,0 +1,53 @@
+package messagebroker
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"fmt"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"github.com/wb-go/wbf/retry"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// DeadLetterProducer отправляет необработанные сообщения в очередь недоставленных.
+type DeadLetterProducer struct {
+	writer Writer
+	log    *zap.Logger
+}
+
+func NewDeadLetterProducer(broker Broker, topic string, log *zap.Logger) *DeadLetterProducer {
+	return &DeadLetterProducer{
+		writer: broker.Writer(topic),
+		log:    log.Named("dead_letter_producer"),
+	}
+}
+
+// Send копирует ключ и тело сообщения, а исходный топик, причину и число попыток
+// передает в заголовках.
+func (p *DeadLetterProducer) Send(ctx context.Context, msg kafkaGo.Message, cause error, attempts int) error {
+	dead := kafkaGo.Message{
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: []kafkaGo.Header{
+			{Key: models.DeadLetterTopicHeader, Value: []byte(msg.Topic)},
+			{Key: models.DeadLetterErrorHeader, Value: []byte(cause.Error())},
+			{Key: models.DeadLetterAttemptsHeader, Value: []byte(strconv.Itoa(attempts))},
+			{Key: models.DeadLetterFailedAtHeader, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+		},
+	}
+	err := retry.Do(func() error {
+		return p.writer.WriteMessages(ctx, dead)
+	}, models.RetryStrategy)
+	if err != nil {
+		p.log.Error("Failed to send dead letter", zap.ByteString("key", msg.Key), zap.Error(err))
+		return fmt.Errorf("failed to send dead letter: %w", err)
+	}
+	p.log.Warn("Message sent to dead letter topic", zap.ByteString("key", msg.Key), zap.Int("attempts", attempts), zap.Error(cause))
+	return nil
+}
+
+func (p *DeadLetterProducer) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,242 @@
+package messagebroker
+
+import (
+	"bytes"
+	"context"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryBroker — брокер внутри процесса для локальной разработки и сквозных тестов.
+// Топик хранит все записанные сообщения до остановки процесса, а каждая группа
+// читает его со своего смещения, как в Kafka. Сообщение считается обработанным
+// только после подтверждения: неподтвержденные сообщения закрытого читателя, а
+// также сообщения, не подтвержденные за redeliverAfter, группа получит снова раньше
+// новых. Сообщения живут только в памяти и теряются при остановке процесса.
+type MemoryBroker struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	// redeliverAfter — через сколько выданное, но не подтвержденное сообщение
+	// выдается снова; ноль отключает повтор по времени
+	redeliverAfter time.Duration
+}
+
+type memoryTopic struct {
+	messages []kafkaGo.Message
+	groups   map[string]*memoryGroup
+	// written закрывается при каждой записи и будит ждущих читателей
+	written chan struct{}
+}
+
+type memoryGroup struct {
+	// next — смещение первого еще не выданного сообщения
+	next int64
+	// inflight хранит выданные, но не подтвержденные сообщения
+	inflight map[int64]delivery
+	// redeliver — смещения, которые нужно выдать снова, по возрастанию
+	redeliver []int64
+}
+
+type delivery struct {
+	reader *memoryReader
+	// deadline — когда сообщение будет выдано снова; нулевое значение — никогда
+	deadline time.Time
+}
+
+func NewMemoryBroker(redeliverAfter time.Duration) *MemoryBroker {
+	return &MemoryBroker{topics: make(map[string]*memoryTopic), redeliverAfter: redeliverAfter}
+}
+
+func (b *MemoryBroker) Writer(topic string) Writer {
+	return &memoryWriter{broker: b, topic: topic}
+}
+
+func (b *MemoryBroker) Reader(topic, groupID string) Reader {
+	return &memoryReader{broker: b, topic: topic, groupID: groupID}
+}
+
+// topic возвращает топик, создавая его при первом обращении. Вызывается под b.mu.
+func (b *MemoryBroker) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{groups: make(map[string]*memoryGroup), written: make(chan struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (t *memoryTopic) group(id string) *memoryGroup {
+	g, ok := t.groups[id]
+	if !ok {
+		g = &memoryGroup{inflight: make(map[int64]delivery)}
+		t.groups[id] = g
+	}
+	return g
+}
+
+// expire возвращает в очередь сообщения с истекшим сроком и сообщает, когда
+// истечет ближайший из оставшихся.
+func (g *memoryGroup) expire(now time.Time) (next time.Time) {
+	for offset, d := range g.inflight {
+		switch {
+		case d.deadline.IsZero():
+		case !d.deadline.After(now):
+			delete(g.inflight, offset)
+			g.redeliver = append(g.redeliver, offset)
+		case next.IsZero() || d.deadline.Before(next):
+			next = d.deadline
+		}
+	}
+	slices.Sort(g.redeliver)
+	return next
+}
+
+func (t *memoryTopic) wake() {
+	close(t.written)
+	t.written = make(chan struct{})
+}
+
+type memoryWriter struct {
+	broker *MemoryBroker
+	topic  string
+	closed bool
+}
+
+func (w *memoryWriter) WriteMessages(ctx context.Context, msgs ...kafkaGo.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w.broker.mu.Lock()
+	defer w.broker.mu.Unlock()
+	if w.closed {
+		return io.ErrClosedPipe
+	}
+
+	t := w.broker.topic(w.topic)
+	now := time.Now()
+	for _, msg := range msgs {
+		t.messages = append(t.messages, kafkaGo.Message{
+			Topic:   w.topic,
+			Offset:  int64(len(t.messages)),
+			Key:     bytes.Clone(msg.Key),
+			Value:   bytes.Clone(msg.Value),
+			Headers: slices.Clone(msg.Headers),
+			Time:    now,
+		})
+	}
+	t.wake()
+	return nil
+}
+
+func (w *memoryWriter) Close() error {
+	w.broker.mu.Lock()
+	defer w.broker.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+type memoryReader struct {
+	broker  *MemoryBroker
+	topic   string
+	groupID string
+	closed  bool
+}
+
+// FetchMessage ждет сообщение, которое группа еще не получила или должна получить снова.
+func (r *memoryReader) FetchMessage(ctx context.Context) (kafkaGo.Message, error) {
+	for {
+		r.broker.mu.Lock()
+		if r.closed {
+			r.broker.mu.Unlock()
+			return kafkaGo.Message{}, io.EOF
+		}
+		t := r.broker.topic(r.topic)
+		g := t.group(r.groupID)
+		now := time.Now()
+		next := g.expire(now)
+
+		offset := int64(-1)
+		if len(g.redeliver) > 0 {
+			offset, g.redeliver = g.redeliver[0], g.redeliver[1:]
+		} else if g.next < int64(len(t.messages)) {
+			offset = g.next
+			g.next++
+		}
+		if offset >= 0 {
+			d := delivery{reader: r}
+			if r.broker.redeliverAfter > 0 {
+				d.deadline = now.Add(r.broker.redeliverAfter)
+			}
+			g.inflight[offset] = d
+			msg := t.messages[offset]
+			r.broker.mu.Unlock()
+			return msg, nil
+		}
+		written := t.written
+		r.broker.mu.Unlock()
+
+		if err := wait(ctx, written, next.Sub(now)); err != nil {
+			return kafkaGo.Message{}, err
+		}
+	}
+}
+
+// wait ждет новой записи или истечения срока ближайшего неподтвержденного
+// сообщения; неположительный timeout означает, что ждать срока не нужно.
+func wait(ctx context.Context, written <-chan struct{}, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-written:
+	case <-expired:
+	}
+	return nil
+}
+
+// CommitMessages подтверждает сообщения; после этого группа их больше не получит.
+func (r *memoryReader) CommitMessages(ctx context.Context, msgs ...kafkaGo.Message) error {
+	r.broker.mu.Lock()
+	defer r.broker.mu.Unlock()
+	if r.closed {
+		return io.ErrClosedPipe
+	}
+	g := r.broker.topic(r.topic).group(r.groupID)
+	for _, msg := range msgs {
+		delete(g.inflight, msg.Offset)
+		// Сообщение могло уже вернуться в очередь по сроку
+		g.redeliver = slices.DeleteFunc(g.redeliver, func(offset int64) bool { return offset == msg.Offset })
+	}
+	return nil
+}
+
+// Close возвращает неподтвержденные сообщения читателя группе, как при
+// перебалансировке в Kafka.
+func (r *memoryReader) Close() error {
+	r.broker.mu.Lock()
+	defer r.broker.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	t := r.broker.topic(r.topic)
+	g := t.group(r.groupID)
+	for offset, d := range g.inflight {
+		if d.reader == r {
+			delete(g.inflight, offset)
+			g.redeliver = append(g.redeliver, offset)
+		}
+	}
+	slices.Sort(g.redeliver)
+	t.wake()
+	return nil
+}
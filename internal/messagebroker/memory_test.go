@@ -0,0 +1,229 @@
+package messagebroker
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"errors"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func write(t *testing.T, ctx context.Context, broker *MemoryBroker, topic string, keys ...string) {
+	t.Helper()
+	writer := broker.Writer(topic)
+	defer writer.Close()
+	for _, key := range keys {
+		if err := writer.WriteMessages(ctx, kafkaGo.Message{Key: []byte(key), Value: []byte("value-" + key)}); err != nil {
+			t.Fatalf("write %s: %v", key, err)
+		}
+	}
+}
+
+func fetch(t *testing.T, ctx context.Context, reader Reader) kafkaGo.Message {
+	t.Helper()
+	msg, err := reader.FetchMessage(ctx)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	return msg
+}
+
+// expectEmpty проверяет, что группе больше нечего выдать.
+func expectEmpty(t *testing.T, ctx context.Context, reader Reader) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if msg, err := reader.FetchMessage(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected no message, got %q (err %v)", msg.Key, err)
+	}
+}
+
+func TestMemoryBrokerDeliversInOrder(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewMemoryBroker(0)
+	write(t, ctx, broker, "tasks", "a", "b")
+
+	reader := broker.Reader("tasks", "workers")
+	for i, want := range []string{"a", "b"} {
+		msg := fetch(t, ctx, reader)
+		if string(msg.Key) != want || string(msg.Value) != "value-"+want {
+			t.Fatalf("message %d: got %s=%s, want %s", i, msg.Key, msg.Value, want)
+		}
+		if msg.Topic != "tasks" || msg.Offset != int64(i) {
+			t.Fatalf("message %d: got topic %s offset %d", i, msg.Topic, msg.Offset)
+		}
+	}
+	expectEmpty(t, ctx, reader)
+}
+
+func TestMemoryBrokerRedeliversUncommittedOnClose(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewMemoryBroker(0)
+	write(t, ctx, broker, "tasks", "a", "b", "c")
+
+	reader := broker.Reader("tasks", "workers")
+	a, b := fetch(t, ctx, reader), fetch(t, ctx, reader)
+	if err := reader.CommitMessages(ctx, a); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := reader.FetchMessage(ctx); err == nil {
+		t.Fatal("closed reader returned a message")
+	}
+
+	// Неподтвержденное b выдается раньше еще не прочитанного c, подтвержденное a — никогда
+	next := broker.Reader("tasks", "workers")
+	if got := fetch(t, ctx, next); got.Offset != b.Offset {
+		t.Fatalf("got offset %d, want redelivered %d", got.Offset, b.Offset)
+	}
+	if got := fetch(t, ctx, next); string(got.Key) != "c" {
+		t.Fatalf("got %s, want c", got.Key)
+	}
+	expectEmpty(t, ctx, next)
+}
+
+func TestMemoryBrokerRedeliversAfterTimeout(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewMemoryBroker(50 * time.Millisecond)
+	write(t, ctx, broker, "tasks", "a")
+
+	reader := broker.Reader("tasks", "workers")
+	first := fetch(t, ctx, reader)
+
+	// Читатель жив, но сообщение бросил: оно вернется, когда истечет срок
+	start := time.Now()
+	again := fetch(t, ctx, reader)
+	if again.Offset != first.Offset {
+		t.Fatalf("got offset %d, want %d", again.Offset, first.Offset)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("message redelivered after %s, before the timeout", elapsed)
+	}
+
+	if err := reader.CommitMessages(ctx, again); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	expectEmpty(t, ctx, reader)
+}
+
+func TestMemoryBrokerCommitAfterExpiryIsNotRedelivered(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewMemoryBroker(10 * time.Millisecond)
+	write(t, ctx, broker, "tasks", "a")
+
+	slow := broker.Reader("tasks", "workers")
+	msg := fetch(t, ctx, slow)
+	time.Sleep(20 * time.Millisecond)
+	// Срок истек, но до повторной выдачи сообщение успели подтвердить
+	if err := slow.CommitMessages(ctx, msg); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	expectEmpty(t, ctx, broker.Reader("tasks", "workers"))
+}
+
+func TestMemoryBrokerGroupsReadIndependently(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewMemoryBroker(0)
+	write(t, ctx, broker, "tasks", "a")
+
+	first := broker.Reader("tasks", "workers")
+	msg := fetch(t, ctx, first)
+	if err := first.CommitMessages(ctx, msg); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	// Новая группа читает топик с начала
+	other := broker.Reader("tasks", "audit")
+	if got := fetch(t, ctx, other); string(got.Key) != "a" {
+		t.Fatalf("got %s, want a", got.Key)
+	}
+}
+
+func TestMemoryBrokerFetchWaitsForWrite(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewMemoryBroker(0)
+	reader := broker.Reader("tasks", "workers")
+
+	received := make(chan kafkaGo.Message, 1)
+	go func() {
+		msg, err := reader.FetchMessage(ctx)
+		if err == nil {
+			received <- msg
+		}
+		close(received)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	write(t, ctx, broker, "tasks", "late")
+	msg, ok := <-received
+	if !ok || string(msg.Key) != "late" {
+		t.Fatalf("got %q, want late", msg.Key)
+	}
+}
+
+func TestMemoryBrokerFetchStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(testContext(t))
+	reader := NewMemoryBroker(0).Reader("tasks", "workers")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := reader.FetchMessage(ctx)
+		done <- err
+	}()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestPriorityLanesOverMemoryBroker(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewMemoryBroker(0)
+	lanes := []Lane{
+		{Priority: models.PriorityInteractive, Topic: "tasks.interactive", Weight: 6},
+		{Priority: models.PriorityNormal, Topic: "tasks", Weight: 3},
+		{Priority: models.PriorityBulk, Topic: "tasks.bulk", Weight: 1},
+	}
+	producer := NewPriorityProducer(broker, lanes, zap.NewNop())
+	consumer, err := NewPriorityConsumer(broker, "workers", lanes, zap.NewNop())
+	if err != nil {
+		t.Fatalf("consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	commands := map[string]models.Priority{"bulk": models.PriorityBulk, "normal": "", "interactive": models.PriorityInteractive}
+	for id, priority := range commands {
+		if err := producer.Publish(ctx, &models.ProcessingCommand{ID: id, Priority: priority}); err != nil {
+			t.Fatalf("publish %s: %v", id, err)
+		}
+	}
+
+	topics := map[string]string{}
+	for range commands {
+		msg, err := consumer.FetchMessage(ctx)
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		topics[string(msg.Key)] = msg.Topic
+		if err := consumer.CommitMessage(ctx, msg); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+	want := map[string]string{"bulk": "tasks.bulk", "normal": "tasks", "interactive": "tasks.interactive"}
+	for id, topic := range want {
+		if topics[id] != topic {
+			t.Errorf("%s went to %q, want %q", id, topics[id], topic)
+		}
+	}
+}
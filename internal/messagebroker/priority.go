@@ -0,0 +1,209 @@
+package messagebroker
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// fetchErrorDelay — пауза перед повторным чтением очереди после ошибки.
+const fetchErrorDelay = time.Second
+
+// Lane — очередь одного приоритета.
+type Lane struct {
+	Priority models.Priority
+	Topic    string
+	// Weight — доля сообщений очереди, когда во всех очередях есть работа.
+	Weight int
+}
+
+// PriorityProducer отправляет команду в топик ее приоритета.
+type PriorityProducer struct {
+	producers map[models.Priority]*Producer
+	byTopic   map[string]*Producer
+}
+
+func NewPriorityProducer(broker Broker, lanes []Lane, log *zap.Logger) *PriorityProducer {
+	p := &PriorityProducer{
+		producers: make(map[models.Priority]*Producer, len(lanes)),
+		byTopic:   make(map[string]*Producer, len(lanes)),
+	}
+	for _, lane := range lanes {
+		producer := NewProducer(broker, lane.Topic, log.With(zap.String("priority", string(lane.Priority))))
+		p.producers[lane.Priority] = producer
+		p.byTopic[lane.Topic] = producer
+	}
+	return p
+}
+
+func (p *PriorityProducer) Publish(ctx context.Context, task *models.ProcessingCommand) error {
+	priority := task.Priority
+	if priority == "" {
+		priority = models.PriorityNormal
+	}
+	producer, ok := p.producers[priority]
+	if !ok {
+		return fmt.Errorf("%w: no topic for %q", models.ErrInvalidPriority, priority)
+	}
+	return producer.Publish(ctx, task)
+}
+
+// Resend возвращает сообщение в топик, из которого оно было прочитано.
+func (p *PriorityProducer) Resend(ctx context.Context, topic string, key, value []byte) error {
+	producer, ok := p.byTopic[topic]
+	if !ok {
+		return fmt.Errorf("no producer for topic %q", topic)
+	}
+	return producer.Send(ctx, key, value)
+}
+
+func (p *PriorityProducer) Close() error {
+	var errs []error
+	for priority, producer := range p.producers {
+		if err := producer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close %s producer: %w", priority, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PriorityConsumer читает несколько топиков и выдает сообщения по алгоритму
+// плавного взвешенного кругового обхода: среди очередей, где есть сообщение,
+// выбирается очередь с наибольшим накопленным весом. Очередь с меньшим весом
+// получает свою долю и не голодает, а пустые очереди не тормозят остальные.
+// FetchMessage не рассчитан на одновременный вызов из нескольких горутин.
+type PriorityConsumer struct {
+	lanes   []*consumerLane
+	byTopic map[string]*consumerLane
+	// ready получает сигнал, когда в какой-либо очереди появилось сообщение
+	ready chan struct{}
+	start sync.Once
+	log   *zap.Logger
+}
+
+type consumerLane struct {
+	Lane
+	consumer *Consumer
+	// messages хранит одно прочитанное, но еще не выданное сообщение
+	messages chan kafkaGo.Message
+	current  int
+}
+
+func NewPriorityConsumer(broker Broker, groupID string, lanes []Lane, log *zap.Logger) (*PriorityConsumer, error) {
+	c := &PriorityConsumer{
+		byTopic: make(map[string]*consumerLane, len(lanes)),
+		ready:   make(chan struct{}, 1),
+		log:     log.Named("priority_consumer"),
+	}
+	for _, lane := range lanes {
+		if lane.Weight <= 0 {
+			return nil, fmt.Errorf("weight of %s lane must be positive", lane.Priority)
+		}
+		if _, ok := c.byTopic[lane.Topic]; ok {
+			return nil, fmt.Errorf("topic %s is used by several lanes", lane.Topic)
+		}
+		l := &consumerLane{
+			Lane:     lane,
+			consumer: NewConsumer(broker, lane.Topic, groupID, log.With(zap.String("priority", string(lane.Priority)))),
+			messages: make(chan kafkaGo.Message, 1),
+		}
+		c.lanes = append(c.lanes, l)
+		c.byTopic[lane.Topic] = l
+	}
+	return c, nil
+}
+
+// FetchMessage возвращает следующее сообщение с учетом весов очередей.
+// Чтение топиков начинается при первом вызове и идет до отмены ctx.
+func (c *PriorityConsumer) FetchMessage(ctx context.Context) (kafkaGo.Message, error) {
+	c.start.Do(func() {
+		for _, lane := range c.lanes {
+			go c.prefetch(ctx, lane)
+		}
+	})
+
+	for {
+		if lane := c.next(); lane != nil {
+			return <-lane.messages, nil
+		}
+		select {
+		case <-ctx.Done():
+			return kafkaGo.Message{}, ctx.Err()
+		case <-c.ready:
+		}
+	}
+}
+
+// next выбирает очередь для следующего сообщения или nil, если все очереди пусты.
+func (c *PriorityConsumer) next() *consumerLane {
+	var best *consumerLane
+	total := 0
+	for _, lane := range c.lanes {
+		if len(lane.messages) == 0 {
+			continue
+		}
+		lane.current += lane.Weight
+		total += lane.Weight
+		if best == nil || lane.current > best.current {
+			best = lane
+		}
+	}
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
+
+// prefetch держит в очереди одно прочитанное сообщение. Сообщение не подтверждается,
+// поэтому при остановке воркера оно будет прочитано снова.
+func (c *PriorityConsumer) prefetch(ctx context.Context, lane *consumerLane) {
+	for {
+		msg, err := lane.consumer.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.log.Warn("Failed to fetch message", zap.String("priority", string(lane.Priority)), zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fetchErrorDelay):
+			}
+			continue
+		}
+
+		select {
+		case lane.messages <- msg:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case c.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// CommitMessage подтверждает сообщение в топике, из которого оно прочитано.
+func (c *PriorityConsumer) CommitMessage(ctx context.Context, msg kafkaGo.Message) error {
+	lane, ok := c.byTopic[msg.Topic]
+	if !ok {
+		return fmt.Errorf("message from unknown topic %s", msg.Topic)
+	}
+	return lane.consumer.CommitMessage(ctx, msg)
+}
+
+func (c *PriorityConsumer) Close() error {
+	var errs []error
+	for _, lane := range c.lanes {
+		if err := lane.consumer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close %s consumer: %w", lane.Priority, err))
+		}
+	}
+	return errors.Join(errs...)
+}
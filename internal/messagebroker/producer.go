@@ -5,20 +5,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/wb-go/wbf/kafka"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"github.com/wb-go/wbf/retry"
 	"go.uber.org/zap"
 )
 
 type Producer struct {
-	produce *kafka.Producer
-	log     *zap.Logger
+	writer Writer
+	log    *zap.Logger
 }
 
-func NewProducer(brokers []string, topic string, log *zap.Logger) *Producer {
-	produce := kafka.NewProducer(brokers, topic)
+func NewProducer(broker Broker, topic string, log *zap.Logger) *Producer {
 	return &Producer{
-		produce: produce,
-		log:     log.Named("producer"),
+		writer: broker.Writer(topic),
+		log:    log.Named("producer"),
 	}
 }
 
@@ -29,7 +29,7 @@ func (p *Producer) Publish(ctx context.Context, task *models.ProcessingCommand)
 		p.log.Error("Failed to marshal task", zap.Any("task", task), zap.Error(err))
 		return fmt.Errorf("failed to marshal task: %w", err)
 	}
-	err = p.produce.SendWithRetry(ctx, models.RetryStrategy, []byte(task.ID), msgBytes)
+	err = p.sendWithRetry(ctx, []byte(task.ID), msgBytes)
 	if err != nil {
 		p.log.Error("Failed to send task", zap.Any("task", task), zap.Error(err))
 		return fmt.Errorf("failed to send task: %w", err)
@@ -37,3 +37,22 @@ func (p *Producer) Publish(ctx context.Context, task *models.ProcessingCommand)
 	p.log.Debug("Successfully sent task", zap.Any("task", task))
 	return nil
 }
+
+// Send отправляет готовое сообщение как есть, например при повторе из очереди недоставленных.
+func (p *Producer) Send(ctx context.Context, key, value []byte) error {
+	if err := p.sendWithRetry(ctx, key, value); err != nil {
+		p.log.Error("Failed to send message", zap.ByteString("key", key), zap.Error(err))
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+func (p *Producer) sendWithRetry(ctx context.Context, key, value []byte) error {
+	return retry.Do(func() error {
+		return p.writer.WriteMessages(ctx, kafkaGo.Message{Key: key, Value: value})
+	}, models.RetryStrategy)
+}
+
+func (p *Producer) Close() error {
+	return p.writer.Close()
+}
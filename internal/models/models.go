@@ -1,8 +1,19 @@
 package models
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/wb-go/wbf/retry"
+	"image"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -11,9 +22,21 @@ const (
 	ThumbnailToWidth  = 128
 	ThumbnailToHeight = 128
 
-	ProcessPath  = "processed/%s/%s"
-	OriginalPath = "original/%s%s"
-	BasePath     = "images/"
+	ProcessPath = "processed/%s/%s"
+	// RunProcessPath — путь результата повторной обработки с версией: файлы
+	// принадлежат одному изображению и не разделяются с другими.
+	RunProcessPath = "processed/%s/runs/%s/%s"
+	BlobPath       = blobPrefix + "%s/%s%s"
+	blobPrefix     = "blobs/"
+	TempPath       = "tmp/%s%s"
+	// WorkPath — черновик результата операции; воркер переносит его на место,
+	// только убедившись, что задачу не отменили.
+	WorkPath = "tmp/work/%s/%s/%s"
+	BasePath = "images/"
+
+	// ImagesURLPrefix — маршрут, по которому отдаются файлы локального хранилища.
+	ImagesURLPrefix = "/images"
+	OriginalURLKey  = "original"
 )
 
 var (
@@ -22,28 +45,414 @@ var (
 		".png": true,
 		".gif": true,
 	}
+	// MediaTypeExtensions сопоставляет допустимые типы содержимого с расширениями файлов.
+	MediaTypeExtensions = map[string]string{
+		"image/jpeg": ".jpg",
+		"image/png":  ".png",
+		"image/gif":  ".gif",
+	}
 	RetryStrategy = retry.Strategy{
 		Attempts: 5,
 		Delay:    time.Millisecond,
 		Backoff:  2,
 	}
 	RequestedOperations = []string{"resize", "thumbnail", "watermark"}
+
+	ErrDuplicateImage     = errors.New("near-duplicate image already exists")
+	ErrHashNotReady       = errors.New("image hash is not computed yet")
+	ErrTaskNotFound       = errors.New("task not found")
+	ErrUnknownOperation   = errors.New("unknown operation")
+	ErrDerivativeNotReady = errors.New("derivative is not ready yet")
+	ErrDerivativeMissing  = errors.New("derivative is unavailable")
+	ErrInvalidRender      = errors.New("invalid render parameters")
+	ErrRenderNotAllowed   = errors.New("render parameters are not in the allowlist")
+	ErrBatchNotFound      = errors.New("batch not found")
+	ErrInvalidFilter      = errors.New("invalid image filter")
+	ErrInvalidMetadata    = errors.New("invalid image metadata")
+
+	ErrRunNotFound      = errors.New("processing run not found")
+	ErrInvalidReprocess = errors.New("invalid reprocess request")
+	ErrTaskBusy         = errors.New("image is being processed")
+	ErrNotCancellable   = errors.New("only images that are being processed can be cancelled")
+	ErrInvalidPriority  = errors.New("priority must be one of: interactive, normal, bulk")
+
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrAlreadyReplayed    = errors.New("dead letter was already replayed")
+
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrInvalidCollection  = errors.New("invalid collection request")
+	ErrNotInCollection    = errors.New("image is not in the collection")
+
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadExpired        = errors.New("upload has expired")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadLocked         = errors.New("upload is being written by another request")
+	ErrUploadTooLarge       = errors.New("upload exceeds the allowed size")
+	ErrInvalidUpload        = errors.New("invalid upload")
+
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+	ErrIdempotencyKeyInUse   = errors.New("idempotency key is already used")
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was already used with a different request")
 )
 
+// OperationKeys строит ключи операций в путях производных файлов. Его значение
+// задается конфигурацией, поэтому API и воркер получают одинаковые пути, и API
+// не нужен сам файл водяного знака.
+type OperationKeys struct {
+	// WatermarkVersion входит в ключ операции watermark; его меняют вместе
+	// с файлом знака, чтобы старые результаты больше не отдавались.
+	WatermarkVersion string
+}
+
+// Key включает параметры операции в путь производного файла,
+// чтобы результаты с разными параметрами не перетирали друг друга.
+func (k OperationKeys) Key(operation string) string {
+	switch operation {
+	case "resize":
+		return fmt.Sprintf("resize_%dx%d", ResizeToWidth, ResizeToHeight)
+	case "thumbnail":
+		return fmt.Sprintf("thumbnail_%dx%d", ThumbnailToWidth, ThumbnailToHeight)
+	case "watermark":
+		if k.WatermarkVersion == "" {
+			return operation
+		}
+		return "watermark_" + k.WatermarkVersion
+	default:
+		return operation
+	}
+}
+
+// DerivativePath возвращает путь результата операции для оригинала.
+// Для оригиналов в content-addressed хранилище имя файла — это дайджест,
+// поэтому одинаковые файлы разделяют общие производные. Оригиналы, загруженные
+// до появления хранилища blobs, хранят результаты по старой схеме
+// processed/<операция>/<имя файла>.
+func (k OperationKeys) DerivativePath(operation, originalPath string) string {
+	if !strings.HasPrefix(originalPath, blobPrefix) {
+		return fmt.Sprintf(ProcessPath, operation, filepath.Base(originalPath))
+	}
+	return fmt.Sprintf(ProcessPath, k.Key(operation), filepath.Base(originalPath))
+}
+
+// RunDerivativePath возвращает путь результата операции, построенного запуском run.
+// Пустой run означает общий путь из DerivativePath.
+func (k OperationKeys) RunDerivativePath(operation, originalPath, run string) string {
+	if run == "" {
+		return k.DerivativePath(operation, originalPath)
+	}
+	return fmt.Sprintf(RunProcessPath, k.Key(operation), run, filepath.Base(originalPath))
+}
+
+// OperationPath возвращает путь текущего результата операции задачи.
+func (k OperationKeys) OperationPath(task *Task, operation string) string {
+	return k.RunDerivativePath(operation, task.OriginalPath, task.DerivativeRuns[operation])
+}
+
 type TaskStatus string
 
 const (
 	StatusFailed     TaskStatus = "FAILED"
 	StatusProcessing TaskStatus = "PROCESSING"
 	StatusComplete   TaskStatus = "COMPLETE"
+	// StatusCancelled — обработка остановлена пользователем; результаты не публикуются.
+	StatusCancelled TaskStatus = "CANCELLED"
 )
 
 type Task struct {
-	ID                  string     `json:"id"`
-	Status              TaskStatus `json:"status"`
-	OriginalPath        string     `json:"original_path"`
-	RequestedOperations []string   `json:"requested_operations"`
-	CreatedAt           time.Time  `json:"created_at"`
+	ID                  string            `json:"id"`
+	Status              TaskStatus        `json:"status"`
+	OriginalPath        string            `json:"original_path"`
+	Digest              string            `json:"digest"`
+	RequestedOperations []string          `json:"requested_operations"`
+	CreatedAt           time.Time         `json:"created_at"`
+	BatchID             string            `json:"batch_id,omitempty"`
+	Format              string            `json:"format,omitempty"`
+	Width               int               `json:"width,omitempty"`
+	Height              int               `json:"height,omitempty"`
+	Tags                []string          `json:"tags"`
+	URLs                map[string]string `json:"urls,omitempty"`
+	// DerivativeRuns указывает для операции запуск, чьи результаты сейчас отдаются.
+	DerivativeRuns map[string]string `json:"derivative_runs,omitempty"`
+	ImageMetadata
+}
+
+// DuplicatePolicy определяет, что делать при загрузке почти одинакового изображения.
+type DuplicatePolicy string
+
+const (
+	DuplicateAllow  DuplicatePolicy = ""
+	DuplicateWarn   DuplicatePolicy = "warn"
+	DuplicateReject DuplicatePolicy = "reject"
+)
+
+type ImageHash struct {
+	AHash uint64 `json:"ahash"`
+	DHash uint64 `json:"dhash"`
+	PHash uint64 `json:"phash"`
+}
+
+type SimilarImage struct {
+	ID           string     `json:"id"`
+	Status       TaskStatus `json:"status"`
+	OriginalPath string     `json:"original_path"`
+	Distance     int        `json:"distance"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Derivative описывает готовый к отдаче файл задачи (оригинал или результат операции).
+type Derivative struct {
+	Path string
+	// ETag привязан к содержимому: дайджесту оригинала и параметрам операции.
+	ETag string
+	// Temporary означает, что файл построен для одного ответа и после отдачи удаляется.
+	Temporary bool
+}
+
+type UploadOptions struct {
+	DuplicatePolicy DuplicatePolicy
+	// BatchID привязывает задачу к пакетной загрузке.
+	BatchID  string
+	Tags     []string
+	Metadata ImageMetadata
+	Priority Priority
+	// IdempotencyKey — ключ повтора запроса: загрузка с тем же ключом
+	// возвращает уже созданную задачу.
+	IdempotencyKey string
+}
+
+// MaxIdempotencyKeyLength ограничивает длину заголовка Idempotency-Key.
+const MaxIdempotencyKeyLength = 255
+
+// IdempotencyKey связывает ключ запроса загрузки с созданной задачей до ExpiresAt.
+type IdempotencyKey struct {
+	Key string
+	// Fingerprint — отпечаток файла и параметров запроса, занявшего ключ.
+	Fingerprint string
+	ExpiresAt   time.Time
+}
+
+// Priority — очередь, в которую попадает задача обработки. Очереди читаются
+// с весами, поэтому массовая загрузка не задерживает интерактивную.
+type Priority string
+
+const (
+	PriorityInteractive Priority = "interactive"
+	PriorityNormal      Priority = "normal"
+	PriorityBulk        Priority = "bulk"
+)
+
+// Priorities перечисляет приоритеты от высшего к низшему.
+var Priorities = []Priority{PriorityInteractive, PriorityNormal, PriorityBulk}
+
+// ParsePriority проверяет приоритет; пустая строка означает fallback.
+func ParsePriority(raw string, fallback Priority) (Priority, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+	priority := Priority(strings.ToLower(raw))
+	if !slices.Contains(Priorities, priority) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPriority, raw)
+	}
+	return priority, nil
+}
+
+const (
+	MaxTitleLength       = 200
+	MaxDescriptionLength = 2000
+	MaxAltLength         = 500
+	MaxLabels            = 50
+	MaxLabelValueLength  = 256
+	MaxTags              = 50
+)
+
+var labelKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// ImageMetadata — описание изображения, заданное пользователем. Доступно
+// в шаблонах текстового водяного знака, например {{.Title}} или {{index .Labels "author"}}.
+type ImageMetadata struct {
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Alt         string            `json:"alt"`
+	Labels      map[string]string `json:"labels"`
+}
+
+func (m ImageMetadata) Validate() error {
+	labels := make(map[string]*string, len(m.Labels))
+	for key, value := range m.Labels {
+		labels[key] = &value
+	}
+	return MetadataPatch{Title: &m.Title, Description: &m.Description, Alt: &m.Alt, Labels: labels}.Validate()
+}
+
+// MetadataPatch — частичное изменение метаданных: поля со значением nil не меняются.
+type MetadataPatch struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Alt         *string `json:"alt"`
+	// Labels объединяются с текущими метками; значение null удаляет метку.
+	Labels map[string]*string `json:"labels"`
+	Tags   *[]string          `json:"tags"`
+}
+
+func (p MetadataPatch) Validate() error {
+	if p.Title != nil && utf8.RuneCountInString(*p.Title) > MaxTitleLength {
+		return fmt.Errorf("%w: title must not exceed %d characters", ErrInvalidMetadata, MaxTitleLength)
+	}
+	if p.Description != nil && utf8.RuneCountInString(*p.Description) > MaxDescriptionLength {
+		return fmt.Errorf("%w: description must not exceed %d characters", ErrInvalidMetadata, MaxDescriptionLength)
+	}
+	if p.Alt != nil && utf8.RuneCountInString(*p.Alt) > MaxAltLength {
+		return fmt.Errorf("%w: alt must not exceed %d characters", ErrInvalidMetadata, MaxAltLength)
+	}
+	// Итоговое число меток проверяется после объединения с текущими; здесь
+	// отсекается только изменение, которое заведомо превысит лимит
+	set := 0
+	for _, value := range p.Labels {
+		if value != nil {
+			set++
+		}
+	}
+	if set > MaxLabels {
+		return fmt.Errorf("%w: at most %d labels are allowed", ErrInvalidMetadata, MaxLabels)
+	}
+	for key, value := range p.Labels {
+		if !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: label key %q must match %s", ErrInvalidMetadata, key, labelKeyPattern)
+		}
+		if value != nil && utf8.RuneCountInString(*value) > MaxLabelValueLength {
+			return fmt.Errorf("%w: label %q must not exceed %d characters", ErrInvalidMetadata, key, MaxLabelValueLength)
+		}
+	}
+	if p.Tags != nil && len(*p.Tags) > MaxTags {
+		return fmt.Errorf("%w: at most %d tags are allowed", ErrInvalidMetadata, MaxTags)
+	}
+	return nil
+}
+
+// NormalizeTags приводит теги к нижнему регистру и убирает пустые и повторяющиеся.
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+type UploadResult struct {
+	TaskID     string         `json:"taskID"`
+	Duplicates []SimilarImage `json:"duplicates,omitempty"`
+	// Replayed означает, что задача создана раньше запросом с тем же ключом идемпотентности.
+	Replayed bool `json:"replayed,omitempty"`
+}
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// ImageFilter — условия выборки списка изображений. Нулевые значения не ограничивают выборку.
+type ImageFilter struct {
+	Status      TaskStatus
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Format      string
+	MinWidth    int
+	MaxWidth    int
+	MinHeight   int
+	MaxHeight   int
+	// Tags — изображение должно содержать все перечисленные теги.
+	Tags []string
+	// Query ищет по словам в названии, описании и alt-тексте.
+	Query string
+	// Labels — изображение должно содержать все перечисленные метки с такими значениями.
+	Labels map[string]string
+	// Sort — поле сортировки, с префиксом "-" по убыванию.
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+type ImagePage struct {
+	Items      []*Task `json:"items"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Total      int     `json:"total"`
+}
+
+// Batch — пакетная загрузка и прогресс обработки ее файлов.
+type Batch struct {
+	ID string `json:"id"`
+	// Total — число файлов пакета, включая отклоненные при загрузке.
+	Total  int                `json:"total"`
+	Counts map[TaskStatus]int `json:"counts"`
+	// Rejected — файлы, для которых задача не была создана.
+	Rejected  []BatchRejection `json:"rejected"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// BatchRejection — файл пакета, отклоненный при загрузке.
+type BatchRejection struct {
+	Filename string `json:"filename"`
+	Error    string `json:"error"`
+}
+
+// BatchItem — результат загрузки одного файла пакета. Ошибка одного файла
+// не прерывает загрузку остальных.
+type BatchItem struct {
+	Filename   string         `json:"filename"`
+	TaskID     string         `json:"taskID,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Duplicates []SimilarImage `json:"duplicates,omitempty"`
+}
+
+type BatchResult struct {
+	BatchID string      `json:"batchID"`
+	Items   []BatchItem `json:"items"`
+}
+
+const (
+	MaxCollectionNameLength = 200
+	// MaxCollectionSize ограничивает галерею: коллекция отдается и переупорядочивается целиком.
+	MaxCollectionSize = 1000
+)
+
+// Collection — упорядоченная подборка изображений (галерея).
+type Collection struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Images заполняется только при чтении коллекции, в порядке позиций.
+	Images []*Task `json:"images,omitempty"`
+}
+
+const (
+	// UploadChunkPath — ключ части возобновляемой загрузки; смещение дополнено нулями,
+	// чтобы лексикографический порядок ключей совпадал с порядком частей.
+	UploadChunkPath  = "uploads/%s/%020d"
+	UploadChunksPath = "uploads/%s/"
+)
+
+// Upload — возобновляемая загрузка по протоколу tus.
+type Upload struct {
+	ID     string
+	Length int64
+	Offset int64
+	// Metadata хранится в виде заголовка Upload-Metadata и возвращается клиенту без изменений.
+	Metadata  string
+	Extension string
+	// TaskID заполняется, когда файл загружен полностью и отправлен на обработку.
+	TaskID    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Completed сообщает, что все байты загрузки получены.
+func (u *Upload) Completed() bool {
+	return u.Offset == u.Length
 }
 
 type ProcessingCommand struct {
@@ -51,4 +460,170 @@ type ProcessingCommand struct {
 	OriginalPath        string    `json:"original_path"`
 	RequestedOperations []string  `json:"requested_operations"`
 	CreatedAt           time.Time `json:"created_at"`
+	// RunID заполняется для повторной обработки; статус ведется в запуске, а не в задаче.
+	RunID string `json:"run_id,omitempty"`
+	// OutputRuns задает для операции запуск, в пути которого пишется результат.
+	OutputRuns map[string]string `json:"output_runs,omitempty"`
+	// Replace строит результаты заново, не переиспользуя готовые.
+	Replace bool `json:"replace,omitempty"`
+	// ReplacedRuns — запуски, чьи результаты удаляются после успешной замены.
+	ReplacedRuns map[string]string `json:"replaced_runs,omitempty"`
+	// Priority выбирает очередь; пустое значение равно PriorityNormal.
+	Priority Priority `json:"priority,omitempty"`
+}
+
+// CommandID отличает повторную обработку от исходной задачи того же изображения.
+func (c *ProcessingCommand) CommandID() string {
+	return cmp.Or(c.RunID, c.ID)
+}
+
+// ReclaimResult — задачи, подобранные сборщиком после истечения аренды.
+type ReclaimResult struct {
+	// Requeued снова поставлены в очередь.
+	Requeued []string
+	// Failed исчерпали попытки и получили статус FAILED.
+	Failed []string
+}
+
+// ReaperStats — счетчики сборщика с момента запуска процесса. Они не делятся
+// между репликами: сумму по кластеру нужно собирать с каждой из них.
+type ReaperStats struct {
+	Requeued  int64      `json:"requeued"`
+	Failed    int64      `json:"failed"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+}
+
+// OutboxEntry — команда обработки, ожидающая отправки в брокер.
+type OutboxEntry struct {
+	ID       int64
+	Command  *ProcessingCommand
+	Attempts int
+}
+
+const (
+	// ReprocessVersion пишет результаты по новым путям и переключает на них изображение
+	// после успешного завершения; прежние результаты хранятся до удаления изображения.
+	ReprocessVersion = "version"
+	// ReprocessReplace тоже пишет результаты по новым путям, чтобы сменился ETag
+	// неизменяемых ответов, но после переключения удаляет прежние результаты запусков.
+	ReprocessReplace = "replace"
+
+	// MaxBulkReprocess ограничивает число изображений в одном массовом запросе.
+	MaxBulkReprocess = 1000
+)
+
+// ReprocessRequest — параметры повторной обработки. Пустой список операций
+// означает все операции.
+type ReprocessRequest struct {
+	Operations []string `json:"operations"`
+	Mode       string   `json:"mode"`
+	Priority   Priority `json:"priority"`
+}
+
+// ProcessingRun — повторная обработка изображения, отслеживаемая отдельно от задачи.
+type ProcessingRun struct {
+	ID         string     `json:"id"`
+	ImageID    string     `json:"image_id"`
+	Operations []string   `json:"operations"`
+	Mode       string     `json:"mode"`
+	Status     TaskStatus `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// BulkReprocessResult — итог массовой повторной обработки.
+type BulkReprocessResult struct {
+	Runs []*ProcessingRun `json:"runs"`
+	// Skipped — изображения, которые еще обрабатываются и поэтому пропущены.
+	Skipped []string `json:"skipped"`
+}
+
+// Заголовки сообщения в очереди недоставленных.
+const (
+	DeadLetterTopicHeader    = "x-original-topic"
+	DeadLetterErrorHeader    = "x-error"
+	DeadLetterAttemptsHeader = "x-attempts"
+	DeadLetterFailedAtHeader = "x-failed-at"
+)
+
+// DeadLetter — сообщение, которое воркер не смог разобрать или обработать
+// за все попытки. Повтор возвращает его в исходный топик без изменений.
+type DeadLetter struct {
+	ID         string     `json:"id"`
+	Topic      string     `json:"topic"`
+	Key        string     `json:"key"`
+	Payload    string     `json:"payload"`
+	Error      string     `json:"error"`
+	Attempts   int        `json:"attempts"`
+	FailedAt   time.Time  `json:"failed_at"`
+	ReplayedAt *time.Time `json:"replayed_at,omitempty"`
+}
+
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitFill    = "fill"
+
+	RenditionPath = "renditions/%s/%s"
+)
+
+// RenderOptions — параметры трансформации изображения на лету.
+// Width или Height, равные нулю, вычисляются с сохранением пропорций.
+type RenderOptions struct {
+	Width  uint
+	Height uint
+	Fit    string
+	Format string
+	// Caption включает текстовый водяной знак по шаблону из метаданных изображения.
+	Caption bool
+	// CaptionText — готовый текст водяного знака, его подставляет сервис.
+	CaptionText string
+}
+
+// Key строит ключ кэша из нормализованных параметров, поэтому запросы
+// с одинаковыми параметрами в разном порядке попадают в одну запись.
+// Текст водяного знака входит в ключ хэшем: после изменения метаданных
+// получается новый вариант, а не устаревший из кэша.
+func (o RenderOptions) Key() string {
+	caption := ""
+	if o.CaptionText != "" {
+		sum := sha256.Sum256([]byte(o.CaptionText))
+		caption = "_c" + hex.EncodeToString(sum[:4])
+	}
+	return fmt.Sprintf("w%d_h%d_%s%s.%s", o.Width, o.Height, o.Fit, caption, FormatExtension(o.Format))
+}
+
+// FormatExtension возвращает расширение файла для формата из image.Decode.
+func FormatExtension(format string) string {
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return format
+}
+
+// FormatFromExtension возвращает формат image.Decode для расширения файла.
+func FormatFromExtension(extension string) string {
+	switch strings.ToLower(strings.TrimPrefix(extension, ".")) {
+	case "jpg", "jpeg":
+		return "jpeg"
+	case "png":
+		return "png"
+	case "gif":
+		return "gif"
+	default:
+		return ""
+	}
+}
+
+// TransformPlan — набор преобразований изображения в порядке применения:
+// вырезание области, масштабирование, отражение, поворот и перевод в оттенки серого.
+type TransformPlan struct {
+	Crop      image.Rectangle
+	Width     uint
+	Height    uint
+	Mirror    bool
+	Rotation  int
+	Grayscale bool
+	Format    string
 }
@@ -0,0 +1,75 @@
+package modifer
+
+import (
+	"fmt"
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+)
+
+const (
+	captionPadding = 4
+	// minCaptionSize — кегль подписи на маленьких изображениях
+	minCaptionSize = 13
+)
+
+// captionFont — Go Regular: в отличие от растровых шрифтов x/image он покрывает
+// кириллицу, греческий и расширенную латиницу.
+var captionFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+// drawCaption накладывает текст на полупрозрачную плашку в левом нижнем углу.
+// Кегль подбирается под высоту изображения; слишком длинная подпись уменьшается
+// до ширины изображения.
+func drawCaption(img image.Image, text string) (image.Image, error) {
+	bounds := img.Bounds()
+	// Плашка занимает примерно двадцатую часть высоты
+	face, err := captionFace(max(float64(bounds.Dy()/20-2*captionPadding), minCaptionSize))
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	textWidth := font.MeasureString(face, text).Ceil()
+	metrics := face.Metrics()
+	label := image.NewRGBA(image.Rect(0, 0, textWidth+2*captionPadding, metrics.Height.Ceil()+2*captionPadding))
+	draw.Draw(label, label.Bounds(), image.NewUniform(color.RGBA{A: 128}), image.Point{}, draw.Src)
+
+	drawer := &font.Drawer{
+		Dst:  label,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.P(captionPadding, captionPadding+metrics.Ascent.Ceil()),
+	}
+	drawer.DrawString(text)
+
+	var scaled image.Image = label
+	if label.Bounds().Dx() > bounds.Dx() {
+		scaled = resize.Resize(uint(bounds.Dx()), 0, label, resize.Bilinear)
+	}
+
+	result := image.NewRGBA(bounds)
+	draw.Draw(result, bounds, img, bounds.Min, draw.Src)
+	offset := image.Pt(bounds.Min.X, bounds.Max.Y-scaled.Bounds().Dy())
+	draw.Draw(result, scaled.Bounds().Add(offset), scaled, scaled.Bounds().Min, draw.Over)
+	return result, nil
+}
+
+func captionFace(size float64) (font.Face, error) {
+	parsed, err := captionFont()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse caption font: %w", err)
+	}
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create caption face: %w", err)
+	}
+	return face, nil
+}
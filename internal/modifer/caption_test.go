@@ -0,0 +1,58 @@
+package modifer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestCaptionFontCoversCyrillic(t *testing.T) {
+	face, err := captionFace(minCaptionSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer face.Close()
+	// Отсутствующий глиф рисуется пустым прямоугольником, поэтому проверяем наличие каждого
+	for _, r := range "Съешь же ещё этих мягких французских булок Ёё" {
+		if _, ok := face.GlyphAdvance(r); !ok {
+			t.Errorf("no glyph for %q", r)
+		}
+	}
+}
+
+func TestDrawCaption(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+
+	result, err := drawCaption(img, "Привет, мир")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Bounds() != img.Bounds() {
+		t.Fatalf("bounds changed: %v", result.Bounds())
+	}
+	// Белый текст должен появиться у нижнего края и не задеть верхний
+	var bottom, top int
+	for y := range 200 {
+		for x := range 400 {
+			if r, _, _, _ := result.At(x, y).RGBA(); r > 0x8000 {
+				if y >= 150 {
+					bottom++
+				} else {
+					top++
+				}
+			}
+		}
+	}
+	if bottom == 0 || top != 0 {
+		t.Fatalf("caption pixels: %d at the bottom, %d above", bottom, top)
+	}
+
+	// Длинная подпись уменьшается до ширины изображения
+	narrow := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(narrow, narrow.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	if _, err := drawCaption(narrow, "Очень длинная подпись к маленькому изображению"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,14 +1,20 @@
 package modifer
 
 import (
+	"ImageProcessor/internal/models"
+	"errors"
 	"fmt"
 	"github.com/nfnt/resize"
 	"go.uber.org/zap"
 	"image"
 	"image/draw"
+	"math"
 	"os"
 )
 
+// ErrNoWatermark возвращается из Watermark, если модификатор создан без водяного знака.
+var ErrNoWatermark = errors.New("watermark image is not loaded")
+
 type Storage interface {
 	SaveImage(path string, img image.Image, format string) error
 	LoadImage(path string) (image.Image, string, error)
@@ -45,6 +51,15 @@ func NewModifier(watermarkPath string, basePath string, storage Storage, logger
 	}, nil
 }
 
+// NewRenderer создает Modifier без водяного знака — для Render и Transform он не нужен.
+func NewRenderer(basePath string, storage Storage, logger *zap.Logger) *Modifier {
+	return &Modifier{
+		basePath: basePath,
+		storage:  storage,
+		log:      logger.Named("modifier"),
+	}
+}
+
 // Resize изменяет размер изображения и сохраняет результат.
 func (m *Modifier) Resize(sourcePath, targetPath string, width, height uint) error {
 	img, format, err := m.storage.LoadImage(sourcePath)
@@ -72,6 +87,9 @@ func (m *Modifier) Thumbnail(sourcePath, targetPath string, maxWidth, maxHeight
 }
 
 func (m *Modifier) Watermark(sourcePath, targetPath string) error {
+	if m.watermarkImage == nil {
+		return ErrNoWatermark
+	}
 	img, format, err := m.storage.LoadImage(sourcePath)
 	if err != nil {
 		return err
@@ -89,3 +107,145 @@ func (m *Modifier) Watermark(sourcePath, targetPath string) error {
 	m.log.Info("Applied watermark", zap.String("target", targetPath))
 	return m.storage.SaveImage(targetPath, newImg, format)
 }
+
+// Render применяет к изображению параметры трансформации на лету и сохраняет результат.
+// Пустой opts.Format означает формат оригинала.
+func (m *Modifier) Render(sourcePath, targetPath string, opts models.RenderOptions) error {
+	img, format, err := m.storage.LoadImage(sourcePath)
+	if err != nil {
+		return err
+	}
+	if opts.Format != "" {
+		format = opts.Format
+	}
+
+	var rendered image.Image
+	switch opts.Fit {
+	case models.FitFill:
+		rendered = resize.Resize(opts.Width, opts.Height, img, resize.Lanczos3)
+	case models.FitCover:
+		rendered = cover(img, opts.Width, opts.Height)
+	default:
+		if opts.Width == 0 || opts.Height == 0 {
+			rendered = resize.Resize(opts.Width, opts.Height, img, resize.Lanczos3)
+		} else {
+			rendered = resize.Thumbnail(opts.Width, opts.Height, img, resize.Lanczos3)
+		}
+	}
+
+	if opts.CaptionText != "" {
+		if rendered, err = drawCaption(rendered, opts.CaptionText); err != nil {
+			return err
+		}
+	}
+
+	m.log.Info("Rendered image", zap.String("target", targetPath), zap.Any("options", opts))
+	return m.storage.SaveImage(targetPath, rendered, format)
+}
+
+// cover масштабирует изображение так, чтобы оно полностью покрыло область
+// width x height, и обрезает выступающие края по центру.
+func cover(img image.Image, width, height uint) image.Image {
+	if width == 0 || height == 0 {
+		return resize.Resize(width, height, img, resize.Lanczos3)
+	}
+	bounds := img.Bounds()
+	scale := math.Max(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	scaledW := uint(math.Ceil(float64(bounds.Dx()) * scale))
+	scaledH := uint(math.Ceil(float64(bounds.Dy()) * scale))
+	scaled := resize.Resize(scaledW, scaledH, img, resize.Lanczos3)
+
+	offset := image.Pt((int(scaledW)-int(width))/2, (int(scaledH)-int(height))/2)
+	return crop(scaled, image.Rect(0, 0, int(width), int(height)).Add(scaled.Bounds().Min).Add(offset))
+}
+
+func crop(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Intersect(img.Bounds())
+	cropped := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, rect.Min, draw.Src)
+	return cropped
+}
+
+// Transform последовательно применяет план преобразования и сохраняет результат.
+func (m *Modifier) Transform(sourcePath, targetPath string, plan models.TransformPlan) error {
+	img, format, err := m.storage.LoadImage(sourcePath)
+	if err != nil {
+		return err
+	}
+	if plan.Format != "" {
+		format = plan.Format
+	}
+
+	result := img
+	if !plan.Crop.Empty() && plan.Crop != img.Bounds().Sub(img.Bounds().Min) {
+		result = crop(result, plan.Crop.Add(img.Bounds().Min))
+	}
+	if plan.Width != 0 || plan.Height != 0 {
+		if b := result.Bounds(); uint(b.Dx()) != plan.Width || uint(b.Dy()) != plan.Height {
+			result = resize.Resize(plan.Width, plan.Height, result, resize.Lanczos3)
+		}
+	}
+	if plan.Mirror {
+		result = mirror(result)
+	}
+	if plan.Rotation != 0 {
+		result = rotate(result, plan.Rotation)
+	}
+	if plan.Grayscale {
+		result = grayscale(result)
+	}
+
+	m.log.Info("Transformed image", zap.String("target", targetPath))
+	return m.storage.SaveImage(targetPath, result, format)
+}
+
+func mirror(img image.Image) image.Image {
+	bounds := img.Bounds()
+	mirrored := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			mirrored.Set(bounds.Dx()-1-x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return mirrored
+}
+
+// rotate поворачивает изображение по часовой стрелке на угол, кратный 90 градусам.
+func rotate(img image.Image, degrees int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	var rotated *image.RGBA
+	switch degrees % 360 {
+	case 90:
+		rotated = image.NewRGBA(image.Rect(0, 0, h, w))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				rotated.Set(h-1-y, x, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+			}
+		}
+	case 180:
+		rotated = image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				rotated.Set(w-1-x, h-1-y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+			}
+		}
+	case 270:
+		rotated = image.NewRGBA(image.Rect(0, 0, h, w))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				rotated.Set(y, w-1-x, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+			}
+		}
+	default:
+		return img
+	}
+	return rotated
+}
+
+func grayscale(img image.Image) image.Image {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+	return gray
+}
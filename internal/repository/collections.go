@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	collectionColumns = `c.id,c.name,
+		(SELECT count(*) FROM collection_images ci WHERE ci.collection_id = c.id),c.created_at,c.updated_at`
+	createCollectionQuery = `INSERT INTO collections (id,name,created_at,updated_at) VALUES ($1,$2,$3,$3)
+		ON CONFLICT (id) DO NOTHING`
+	getCollectionQuery    = `SELECT ` + collectionColumns + ` FROM collections c WHERE c.id = $1`
+	renameCollectionQuery = `UPDATE collections c SET name = $2, updated_at = $3 WHERE c.id = $1
+		RETURNING ` + collectionColumns
+	deleteCollectionQuery = `DELETE FROM collections WHERE id = $1`
+	// Столбцы collection_images не пересекаются с images, поэтому taskColumns можно не уточнять
+	collectionImagesQuery = `SELECT ` + taskColumns + ` FROM collection_images ci
+		JOIN images i ON i.id = ci.image_id
+		WHERE ci.collection_id = $1 ORDER BY ci.position, ci.added_at, ci.image_id`
+	// Блокировка строки коллекции упорядочивает конкурентные изменения ее состава
+	lockCollectionQuery  = `SELECT id FROM collections WHERE id = $1 FOR UPDATE`
+	touchCollectionQuery = `UPDATE collections SET updated_at = $2 WHERE id = $1`
+	// Новые изображения добавляются в конец в порядке запроса; уже добавленные пропускаются
+	addCollectionImagesQuery = `INSERT INTO collection_images (collection_id,image_id,position,added_at)
+		SELECT $1, n.image_id, (SELECT COALESCE(max(position), -1) FROM collection_images WHERE collection_id = $1) + n.ord, $3
+		FROM unnest($2::uuid[]) WITH ORDINALITY AS n(image_id, ord)
+		ON CONFLICT (collection_id, image_id) DO NOTHING`
+	countCollectionImagesQuery = `SELECT count(*) FROM collection_images WHERE collection_id = $1`
+	removeCollectionImageQuery = `DELETE FROM collection_images WHERE collection_id = $1 AND image_id = $2`
+	collectionImageIDsQuery    = `SELECT image_id FROM collection_images WHERE collection_id = $1`
+	reorderCollectionQuery     = `UPDATE collection_images ci SET position = n.ord
+		FROM unnest($2::uuid[]) WITH ORDINALITY AS n(image_id, ord)
+		WHERE ci.collection_id = $1 AND ci.image_id = n.image_id`
+
+	// foreignKeyViolation — код ошибки PostgreSQL при ссылке на несуществующую строку
+	foreignKeyViolation = "23503"
+)
+
+func (r *Repository) CreateCollection(ctx context.Context, collection *models.Collection) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, createCollectionQuery,
+		collection.ID, collection.Name, collection.CreatedAt)
+	if err != nil {
+		r.log.Error("Failed to create collection", zap.Error(err))
+		return fmt.Errorf("failed to create collection: %w", err)
+	}
+	return nil
+}
+
+// GetCollection возвращает коллекцию вместе с изображениями в порядке позиций.
+func (r *Repository) GetCollection(ctx context.Context, id string) (*models.Collection, error) {
+	row, err := r.db.QueryRowWithRetry(ctx, models.RetryStrategy, getCollectionQuery, id)
+	if err != nil {
+		r.log.Error("Failed to get collection", zap.Error(err))
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+	collection, err := scanCollection(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get collection %s: %w", id, models.ErrCollectionNotFound)
+		}
+		r.log.Error("Failed to get collection", zap.Error(err))
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+
+	rows, err := r.db.QueryWithRetry(ctx, models.RetryStrategy, collectionImagesQuery, id)
+	if err != nil {
+		r.log.Error("Failed to get collection images", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to get collection images: %w", err)
+	}
+	defer rows.Close()
+
+	collection.Images = make([]*models.Task, 0, collection.Size)
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			r.log.Error("Failed to scan collection image", zap.Error(err))
+			return nil, fmt.Errorf("failed to scan collection image: %w", err)
+		}
+		collection.Images = append(collection.Images, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate collection images: %w", err)
+	}
+	return collection, nil
+}
+
+func (r *Repository) RenameCollection(ctx context.Context, id, name string, now time.Time) (*models.Collection, error) {
+	collection, err := scanCollection(r.db.Master.QueryRowContext(ctx, renameCollectionQuery, id, name, now))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to rename collection %s: %w", id, models.ErrCollectionNotFound)
+		}
+		r.log.Error("Failed to rename collection", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to rename collection: %w", err)
+	}
+	return collection, nil
+}
+
+func (r *Repository) DeleteCollection(ctx context.Context, id string) error {
+	res, err := r.db.Master.ExecContext(ctx, deleteCollectionQuery, id)
+	if err != nil {
+		r.log.Error("Failed to delete collection", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to delete collection: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to delete collection %s: %w", id, models.ErrCollectionNotFound)
+	}
+	return nil
+}
+
+// AddToCollection добавляет изображения в конец коллекции. Изображения, которые
+// уже в ней есть, остаются на своих местах.
+func (r *Repository) AddToCollection(ctx context.Context, id string, imageIDs []string, now time.Time) error {
+	return r.updateCollection(ctx, id, now, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, addCollectionImagesQuery, id, pq.Array(imageIDs), now); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+				return fmt.Errorf("failed to add images to collection %s: %w", id, models.ErrTaskNotFound)
+			}
+			return fmt.Errorf("failed to add images to collection: %w", err)
+		}
+		var size int
+		if err := tx.QueryRowContext(ctx, countCollectionImagesQuery, id).Scan(&size); err != nil {
+			return fmt.Errorf("failed to count collection images: %w", err)
+		}
+		if size > models.MaxCollectionSize {
+			return fmt.Errorf("%w: collection can hold at most %d images", models.ErrInvalidCollection, models.MaxCollectionSize)
+		}
+		return nil
+	})
+}
+
+func (r *Repository) RemoveFromCollection(ctx context.Context, id, imageID string, now time.Time) error {
+	return r.updateCollection(ctx, id, now, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, removeCollectionImageQuery, id, imageID)
+		if err != nil {
+			return fmt.Errorf("failed to remove image from collection: %w", err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("failed to remove image %s: %w", imageID, models.ErrNotInCollection)
+		}
+		return nil
+	})
+}
+
+// ReorderCollection задает новый порядок изображений. imageIDs должен содержать
+// ровно те изображения, которые сейчас в коллекции.
+func (r *Repository) ReorderCollection(ctx context.Context, id string, imageIDs []string, now time.Time) error {
+	return r.updateCollection(ctx, id, now, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, collectionImageIDsQuery, id)
+		if err != nil {
+			return fmt.Errorf("failed to get collection images: %w", err)
+		}
+		current := make(map[string]bool)
+		for rows.Next() {
+			var imageID string
+			if err := rows.Scan(&imageID); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan collection image: %w", err)
+			}
+			current[imageID] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to iterate collection images: %w", err)
+		}
+
+		if len(imageIDs) != len(current) {
+			return fmt.Errorf("%w: order must list all %d images of the collection", models.ErrInvalidCollection, len(current))
+		}
+		for _, imageID := range imageIDs {
+			if !current[imageID] {
+				return fmt.Errorf("%w: image %s is not in the collection", models.ErrInvalidCollection, imageID)
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, reorderCollectionQuery, id, pq.Array(imageIDs)); err != nil {
+			return fmt.Errorf("failed to reorder collection: %w", err)
+		}
+		return nil
+	})
+}
+
+// updateCollection выполняет изменение состава коллекции в транзакции на мастере:
+// блокирует строку коллекции, применяет apply и обновляет время изменения.
+func (r *Repository) updateCollection(ctx context.Context, id string, now time.Time, apply func(tx *sql.Tx) error) error {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Error("Failed to begin transaction", zap.Error(err))
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var locked string
+	if err := tx.QueryRowContext(ctx, lockCollectionQuery, id).Scan(&locked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to lock collection %s: %w", id, models.ErrCollectionNotFound)
+		}
+		r.log.Error("Failed to lock collection", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to lock collection: %w", err)
+	}
+
+	if err := apply(tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, touchCollectionQuery, id, now); err != nil {
+		r.log.Error("Failed to update collection", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to update collection: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Error("Failed to commit collection update", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to commit collection update: %w", err)
+	}
+	return nil
+}
+
+func scanCollection(row rowScanner) (*models.Collection, error) {
+	var collection models.Collection
+	err := row.Scan(&collection.ID, &collection.Name, &collection.Size, &collection.CreatedAt, &collection.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	deadLetterColumns   = `id,topic,message_key,payload,error,attempts,failed_at,replayed_at`
+	saveDeadLetterQuery = `INSERT INTO dead_letters (id,topic,message_key,payload,error,attempts,failed_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7) ON CONFLICT (id) DO NOTHING`
+	getDeadLetterQuery   = `SELECT ` + deadLetterColumns + ` FROM dead_letters WHERE id = $1`
+	listDeadLettersQuery = `SELECT ` + deadLetterColumns + ` FROM dead_letters
+		WHERE NOT $1 OR replayed_at IS NULL ORDER BY failed_at DESC, id LIMIT $2 OFFSET $3`
+	claimReplayQuery      = `UPDATE dead_letters SET replayed_at = $2 WHERE id = $1 AND replayed_at IS NULL RETURNING ` + deadLetterColumns
+	releaseReplayQuery    = `UPDATE dead_letters SET replayed_at = NULL WHERE id = $1`
+	deadLetterExistsQuery = `SELECT EXISTS (SELECT 1 FROM dead_letters WHERE id = $1)`
+)
+
+// SaveDeadLetter сохраняет сообщение; уже сохраненное сообщение пропускается.
+func (r *Repository) SaveDeadLetter(ctx context.Context, letter *models.DeadLetter) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, saveDeadLetterQuery, letter.ID, letter.Topic,
+		[]byte(letter.Key), []byte(letter.Payload), letter.Error, letter.Attempts, letter.FailedAt)
+	if err != nil {
+		r.log.Error("Failed to save dead letter", zap.String("id", letter.ID), zap.Error(err))
+		return fmt.Errorf("failed to save dead letter: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error) {
+	row, err := r.db.QueryRowWithRetry(ctx, models.RetryStrategy, getDeadLetterQuery, id)
+	if err != nil {
+		r.log.Error("Failed to get dead letter", zap.Error(err))
+		return nil, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	letter, err := scanDeadLetter(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get dead letter %s: %w", id, models.ErrDeadLetterNotFound)
+		}
+		r.log.Error("Failed to get dead letter", zap.Error(err))
+		return nil, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	return letter, nil
+}
+
+// ListDeadLetters возвращает сообщения, начиная с последних; pending оставляет
+// только еще не повторенные.
+func (r *Repository) ListDeadLetters(ctx context.Context, pending bool, limit, offset int) ([]*models.DeadLetter, error) {
+	rows, err := r.db.QueryWithRetry(ctx, models.RetryStrategy, listDeadLettersQuery, pending, limit, offset)
+	if err != nil {
+		r.log.Error("Failed to list dead letters", zap.Error(err))
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	letters := make([]*models.DeadLetter, 0)
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			r.log.Error("Failed to scan dead letter", zap.Error(err))
+			return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+		}
+		letters = append(letters, letter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate dead letters: %w", err)
+	}
+	return letters, nil
+}
+
+// ClaimReplay отмечает сообщение повторенным. Отметка ставится до отправки,
+// чтобы два одновременных запроса не вернули сообщение в топик дважды.
+func (r *Repository) ClaimReplay(ctx context.Context, id string, now time.Time) (*models.DeadLetter, error) {
+	letter, err := scanDeadLetter(r.db.Master.QueryRowContext(ctx, claimReplayQuery, id, now))
+	if err == nil {
+		return letter, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		r.log.Error("Failed to claim dead letter replay", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to claim dead letter replay: %w", err)
+	}
+
+	var exists bool
+	if err := r.db.Master.QueryRowContext(ctx, deadLetterExistsQuery, id).Scan(&exists); err != nil {
+		r.log.Error("Failed to check dead letter", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to check dead letter: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("failed to replay dead letter %s: %w", id, models.ErrAlreadyReplayed)
+	}
+	return nil, fmt.Errorf("failed to replay dead letter %s: %w", id, models.ErrDeadLetterNotFound)
+}
+
+// ReleaseReplay снимает отметку, если сообщение не удалось отправить.
+func (r *Repository) ReleaseReplay(ctx context.Context, id string) error {
+	if _, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, releaseReplayQuery, id); err != nil {
+		r.log.Error("Failed to release dead letter replay", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to release dead letter replay: %w", err)
+	}
+	return nil
+}
+
+func scanDeadLetter(row rowScanner) (*models.DeadLetter, error) {
+	var letter models.DeadLetter
+	var key, payload []byte
+	var replayedAt sql.NullTime
+	err := row.Scan(&letter.ID, &letter.Topic, &key, &payload, &letter.Error, &letter.Attempts,
+		&letter.FailedAt, &replayedAt)
+	if err != nil {
+		return nil, err
+	}
+	letter.Key, letter.Payload = string(key), string(payload)
+	if replayedAt.Valid {
+		letter.ReplayedAt = &replayedAt.Time
+	}
+	return &letter, nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// Истекший ключ переходит к новой задаче, действующий остается за прежней
+	claimIdempotencyKeyQuery = `INSERT INTO idempotency_keys (key,task_id,created_at,expires_at,fingerprint)
+		VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (key) DO UPDATE SET task_id = EXCLUDED.task_id, created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at, fingerprint = EXCLUDED.fingerprint
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at`
+	getIdempotentTaskQuery            = `SELECT task_id,fingerprint FROM idempotency_keys WHERE key = $1 AND expires_at > $2`
+	deleteExpiredIdempotencyKeysQuery = `DELETE FROM idempotency_keys WHERE expires_at <= $1`
+	completedOperationsQuery          = `SELECT operation FROM completed_operations WHERE command_id = $1`
+	completeOperationQuery            = `INSERT INTO completed_operations (command_id,image_id,operation,completed_at)
+		VALUES ($1,$2,$3,$4) ON CONFLICT (command_id,operation) DO NOTHING`
+)
+
+func (r *Repository) claimIdempotencyKey(ctx context.Context, tx *sql.Tx, key *models.IdempotencyKey, taskID string, now time.Time) error {
+	res, err := tx.ExecContext(ctx, claimIdempotencyKeyQuery, key.Key, taskID, now, key.ExpiresAt, key.Fingerprint)
+	if err != nil {
+		r.log.Error("Failed to save idempotency key", zap.Error(err))
+		return fmt.Errorf("failed to save idempotency key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to save idempotency key: %w", models.ErrIdempotencyKeyInUse)
+	}
+	return nil
+}
+
+// GetIdempotentTask возвращает задачу, созданную запросом с действующим ключом,
+// и отпечаток этого запроса. Чтение идет с мастера: повтор запроса обычно
+// приходит сразу после первой попытки.
+func (r *Repository) GetIdempotentTask(ctx context.Context, key string, now time.Time) (string, string, error) {
+	var taskID, fingerprint string
+	err := r.db.Master.QueryRowContext(ctx, getIdempotentTaskQuery, key, now).Scan(&taskID, &fingerprint)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", fmt.Errorf("failed to get idempotency key: %w", models.ErrTaskNotFound)
+		}
+		r.log.Error("Failed to get idempotency key", zap.Error(err))
+		return "", "", fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	return taskID, fingerprint, nil
+}
+
+func (r *Repository) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	res, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, deleteExpiredIdempotencyKeysQuery, now)
+	if err != nil {
+		r.log.Error("Failed to delete expired idempotency keys", zap.Error(err))
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// CompletedOperations возвращает операции, уже выполненные для команды.
+func (r *Repository) CompletedOperations(ctx context.Context, commandID string) ([]string, error) {
+	rows, err := r.db.Master.QueryContext(ctx, completedOperationsQuery, commandID)
+	if err != nil {
+		r.log.Error("Failed to get completed operations", zap.String("command", commandID), zap.Error(err))
+		return nil, fmt.Errorf("failed to get completed operations: %w", err)
+	}
+	defer rows.Close()
+
+	operations := make([]string, 0)
+	for rows.Next() {
+		var operation string
+		if err := rows.Scan(&operation); err != nil {
+			return nil, fmt.Errorf("failed to scan completed operation: %w", err)
+		}
+		operations = append(operations, operation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate completed operations: %w", err)
+	}
+	return operations, nil
+}
+
+func (r *Repository) CompleteOperation(ctx context.Context, commandID, imageID, operation string, now time.Time) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, completeOperationQuery, commandID, imageID, operation, now)
+	if err != nil {
+		r.log.Error("Failed to record completed operation", zap.String("command", commandID), zap.String("operation", operation), zap.Error(err))
+		return fmt.Errorf("failed to record completed operation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	acquireLeaseQuery = `UPDATE images SET lease_expires_at = $2, processing_attempts = processing_attempts + 1
+		WHERE id = $1 AND status = $3`
+	renewLeaseQuery = `UPDATE images SET lease_expires_at = $2 WHERE id = $1 AND status = $3`
+	// SKIP LOCKED позволяет нескольким сборщикам работать параллельно. Задача без
+	// аренды подбирается, если ждет воркера с момента раньше $3: ее воркер упал до
+	// AcquireLease или команда застряла в очереди
+	expiredLeasesQuery = `SELECT id,original_path,created_at,processing_attempts,priority,lease_expires_at IS NULL FROM images
+		WHERE status = $1 AND (lease_expires_at < $2 OR (lease_expires_at IS NULL AND COALESCE(queued_at, created_at) < $3))
+		ORDER BY COALESCE(lease_expires_at, queued_at, created_at) LIMIT $4 FOR UPDATE SKIP LOCKED`
+	// Попытку задачи с аренды засчитал AcquireLease; повторная постановка задачи,
+	// которую воркер так и не взял, засчитывается здесь ($3)
+	releaseLeaseQuery = `UPDATE images SET lease_expires_at = NULL, queued_at = $2,
+		processing_attempts = processing_attempts + $3 WHERE id = $1`
+	failLeaseQuery = `UPDATE images SET status = $2, lease_expires_at = NULL WHERE id = $1`
+)
+
+// AcquireLease берет аренду обрабатываемой задачи и засчитывает попытку обработки.
+func (r *Repository) AcquireLease(ctx context.Context, id string, until time.Time) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, acquireLeaseQuery, id, until, models.StatusProcessing)
+	if err != nil {
+		r.log.Error("Failed to acquire lease", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) RenewLease(ctx context.Context, id string, until time.Time) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, renewLeaseQuery, id, until, models.StatusProcessing)
+	if err != nil {
+		r.log.Error("Failed to renew lease", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to renew lease: %w", err)
+	}
+	return nil
+}
+
+// ReclaimExpiredLeases забирает до limit задач с истекшей арендой и задач, которые
+// дольше unleasedTimeout остаются без аренды. Повторная постановка задачи без
+// аренды считается попыткой. Задача, исчерпавшая maxAttempts попыток, получает
+// статус FAILED; остальные снова ставятся в очередь со своим приоритетом через
+// outbox в той же транзакции.
+func (r *Repository) ReclaimExpiredLeases(ctx context.Context, now time.Time, unleasedTimeout time.Duration, limit, maxAttempts int) (*models.ReclaimResult, error) {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Error("Failed to begin transaction", zap.Error(err))
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, expiredLeasesQuery, models.StatusProcessing, now, now.Add(-unleasedTimeout), limit)
+	if err != nil {
+		r.log.Error("Failed to find expired leases", zap.Error(err))
+		return nil, fmt.Errorf("failed to find expired leases: %w", err)
+	}
+	var tasks []expiredTask
+	for rows.Next() {
+		task := expiredTask{command: &models.ProcessingCommand{RequestedOperations: models.RequestedOperations}}
+		if err := rows.Scan(&task.command.ID, &task.command.OriginalPath, &task.command.CreatedAt, &task.attempts, &task.command.Priority, &task.unleased); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan expired lease: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate expired leases: %w", err)
+	}
+
+	result := &models.ReclaimResult{}
+	for _, task := range tasks {
+		attempt, exhausted := task.reclaim(maxAttempts)
+		if exhausted {
+			if _, err := tx.ExecContext(ctx, failLeaseQuery, task.command.ID, models.StatusFailed); err != nil {
+				r.log.Error("Failed to fail task with expired lease", zap.String("id", task.command.ID), zap.Error(err))
+				return nil, fmt.Errorf("failed to fail task with expired lease: %w", err)
+			}
+			result.Failed = append(result.Failed, task.command.ID)
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, releaseLeaseQuery, task.command.ID, now, attempt); err != nil {
+			r.log.Error("Failed to release expired lease", zap.String("id", task.command.ID), zap.Error(err))
+			return nil, fmt.Errorf("failed to release expired lease: %w", err)
+		}
+		if err := insertOutbox(ctx, tx, task.command, now); err != nil {
+			r.log.Error("Failed to requeue task", zap.String("id", task.command.ID), zap.Error(err))
+			return nil, err
+		}
+		result.Requeued = append(result.Requeued, task.command.ID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.log.Error("Failed to commit reclaimed leases", zap.Error(err))
+		return nil, fmt.Errorf("failed to commit reclaimed leases: %w", err)
+	}
+	return result, nil
+}
+
+// expiredTask — задача, подобранная сборщиком.
+type expiredTask struct {
+	command  *models.ProcessingCommand
+	attempts int
+	// unleased — воркер не взял аренду с последней постановки в очередь
+	unleased bool
+}
+
+// reclaim возвращает, сколько попыток добавить при повторной постановке, и
+// исчерпаны ли они. Без учета постановок задачи, ждущие в очереди дольше
+// unleasedTimeout, ставились бы заново бесконечно, умножая дубликаты команд.
+func (t expiredTask) reclaim(maxAttempts int) (attempt int, exhausted bool) {
+	if t.unleased {
+		attempt = 1
+	}
+	return attempt, t.attempts >= maxAttempts
+}
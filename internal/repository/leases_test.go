@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"ImageProcessor/internal/models"
+	"testing"
+)
+
+// Очередь, которую воркеры не успевают разобрать дольше unleasedTimeout: задача
+// подбирается на каждом проходе, но число повторных постановок ограничено.
+func TestReclaimBacklogOlderThanUnleasedTimeout(t *testing.T) {
+	const maxAttempts = 3
+	task := expiredTask{command: &models.ProcessingCommand{ID: "queued"}, unleased: true}
+
+	requeued := 0
+	for range 100 {
+		attempt, exhausted := task.reclaim(maxAttempts)
+		if exhausted {
+			break
+		}
+		requeued++
+		task.attempts += attempt
+	}
+	if requeued != maxAttempts {
+		t.Fatalf("requeued %d times, want %d", requeued, maxAttempts)
+	}
+}
+
+func TestReclaimExpiredLeaseCountedOnAcquire(t *testing.T) {
+	task := expiredTask{command: &models.ProcessingCommand{ID: "leased"}, attempts: 1}
+	if attempt, exhausted := task.reclaim(3); attempt != 0 || exhausted {
+		t.Fatalf("reclaim() = %d, %v, want 0, false", attempt, exhausted)
+	}
+	task.attempts = 3
+	if _, exhausted := task.reclaim(3); !exhausted {
+		t.Fatal("task with exhausted attempts was requeued")
+	}
+}
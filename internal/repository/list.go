@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	listQuery  = `SELECT ` + taskColumns + ` FROM images WHERE %s ORDER BY %s %s, id %s LIMIT %d`
+	countQuery = `SELECT count(*) FROM images WHERE %s`
+
+	// cursorTimeLayout совпадает с точностью TIMESTAMP в PostgreSQL
+	cursorTimeLayout = "2006-01-02 15:04:05.999999"
+	defaultSort      = "-created_at"
+)
+
+// sortColumn описывает поле, по которому разрешена сортировка.
+type sortColumn struct {
+	column string
+	// sqlType используется для приведения значения из курсора
+	sqlType string
+	value   func(task *models.Task) string
+	// parse проверяет значение из курсора до того, как оно попадет в запрос
+	parse func(value string) error
+}
+
+var sortColumns = map[string]sortColumn{
+	"created_at": {column: "created_at", sqlType: "timestamp", value: func(t *models.Task) string {
+		return t.CreatedAt.Format(cursorTimeLayout)
+	}, parse: func(v string) error {
+		_, err := time.Parse(cursorTimeLayout, v)
+		return err
+	}},
+	"width": {column: "width", sqlType: "integer", value: func(t *models.Task) string {
+		return strconv.Itoa(t.Width)
+	}, parse: parseInt},
+	"height": {column: "height", sqlType: "integer", value: func(t *models.Task) string {
+		return strconv.Itoa(t.Height)
+	}, parse: parseInt},
+}
+
+// ListTasks возвращает страницу изображений по фильтру. Пагинация курсорная:
+// курсор хранит значение поля сортировки и id последней записи страницы,
+// поэтому вставки и удаления не сдвигают следующие страницы.
+// Запросы выполняются на репликах.
+func (r *Repository) ListTasks(ctx context.Context, filter models.ImageFilter) (*models.ImagePage, error) {
+	sortField := filter.Sort
+	if sortField == "" {
+		sortField = defaultSort
+	}
+	desc := strings.HasPrefix(sortField, "-")
+	sortName := strings.TrimPrefix(sortField, "-")
+	sort, ok := sortColumns[sortName]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort field %q", models.ErrInvalidFilter, sortField)
+	}
+
+	where, args := filterConditions(filter)
+	total, err := r.countTasks(ctx, where, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.Cursor != "" {
+		value, id, err := decodeCursor(filter.Cursor, sortName)
+		if err != nil {
+			return nil, err
+		}
+		if err := sort.parse(value); err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", models.ErrInvalidFilter)
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		args = append(args, value, id)
+		where = append(where, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d::uuid)", sort.column, op, len(args)-1, sort.sqlType, len(args)))
+	}
+
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	// Лишняя запись показывает, есть ли следующая страница
+	query := fmt.Sprintf(listQuery, strings.Join(where, " AND "), sort.column, direction, direction, filter.Limit+1)
+	rows, err := r.db.QueryWithRetry(ctx, models.RetryStrategy, query, args...)
+	if err != nil {
+		r.log.Error("Failed to list tasks", zap.Error(err))
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.ImagePage{Items: make([]*models.Task, 0, filter.Limit), Total: total}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			r.log.Error("Failed to scan task", zap.Error(err))
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		page.Items = append(page.Items, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate tasks: %w", err)
+	}
+
+	if len(page.Items) > filter.Limit {
+		page.Items = page.Items[:filter.Limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = encodeCursor(sortName, sort.value(last), last.ID)
+	}
+	return page, nil
+}
+
+func (r *Repository) countTasks(ctx context.Context, where []string, args []any) (int, error) {
+	row, err := r.db.QueryRowWithRetry(ctx, models.RetryStrategy, fmt.Sprintf(countQuery, strings.Join(where, " AND ")), args...)
+	if err != nil {
+		r.log.Error("Failed to count tasks", zap.Error(err))
+		return 0, fmt.Errorf("failed to count tasks: %w", err)
+	}
+	var total int
+	if err := row.Scan(&total); err != nil {
+		r.log.Error("Failed to count tasks", zap.Error(err))
+		return 0, fmt.Errorf("failed to count tasks: %w", err)
+	}
+	return total, nil
+}
+
+// filterConditions строит условия WHERE и их аргументы. Значения передаются
+// только через плейсхолдеры.
+func filterConditions(filter models.ImageFilter) ([]string, []any) {
+	where := []string{"TRUE"}
+	args := make([]any, 0)
+	add := func(condition string, value any) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Status != "" {
+		add("status = $%d", filter.Status)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		add("created_at >= $%d", filter.CreatedFrom.Local().Format(cursorTimeLayout))
+	}
+	if !filter.CreatedTo.IsZero() {
+		add("created_at < $%d", filter.CreatedTo.Local().Format(cursorTimeLayout))
+	}
+	if filter.Format != "" {
+		add("format = $%d", filter.Format)
+	}
+	if filter.MinWidth > 0 {
+		add("width >= $%d", filter.MinWidth)
+	}
+	if filter.MaxWidth > 0 {
+		add("width <= $%d", filter.MaxWidth)
+	}
+	if filter.MinHeight > 0 {
+		add("height >= $%d", filter.MinHeight)
+	}
+	if filter.MaxHeight > 0 {
+		add("height <= $%d", filter.MaxHeight)
+	}
+	if len(filter.Tags) > 0 {
+		add("tags @> $%d", pq.Array(filter.Tags))
+	}
+	if filter.Query != "" {
+		add("search @@ plainto_tsquery('simple', $%d)", filter.Query)
+	}
+	if len(filter.Labels) > 0 {
+		add("labels @> $%d::jsonb", stringMapJSON(filter.Labels))
+	}
+	return where, args
+}
+
+// Курсор привязан к полю сортировки: с другой сортировкой он не имеет смысла.
+func encodeCursor(sortName, value, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortName + "|" + value + "|" + id))
+}
+
+func decodeCursor(cursor, sortName string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: malformed cursor", models.ErrInvalidFilter)
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("%w: malformed cursor", models.ErrInvalidFilter)
+	}
+	if parts[0] != sortName {
+		return "", "", fmt.Errorf("%w: cursor was issued for sort by %s", models.ErrInvalidFilter, parts[0])
+	}
+	if err := uuid.Validate(parts[2]); err != nil {
+		return "", "", fmt.Errorf("%w: malformed cursor", models.ErrInvalidFilter)
+	}
+	return parts[1], parts[2], nil
+}
+
+func parseInt(value string) error {
+	_, err := strconv.Atoi(value)
+	return err
+}
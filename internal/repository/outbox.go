@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"ImageProcessor/internal/models"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"slices"
+	"time"
+)
+
+const (
+	insertOutboxQuery = `INSERT INTO outbox (payload,created_at) VALUES ($1,$2)`
+	// SKIP LOCKED позволяет нескольким экземплярам забирать разные записи параллельно;
+	// строки блокируются только на время этого запроса
+	claimOutboxQuery = `UPDATE outbox SET claimed_until = $3 WHERE id IN (
+			SELECT id FROM outbox
+			WHERE sent_at IS NULL AND parked_at IS NULL AND (claimed_until IS NULL OR claimed_until < $2)
+			ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING id,payload,attempts`
+	markOutboxSentQuery = `UPDATE outbox SET sent_at = $2, claimed_until = NULL WHERE id = ANY($1)`
+	// Запись без оставшихся попыток откладывается, остальные ждут retryAt
+	failOutboxQuery = `UPDATE outbox SET attempts = attempts + 1, last_error = $2, claimed_until = $4,
+			parked_at = CASE WHEN attempts + 1 >= $5 THEN $3::timestamp END
+		WHERE id = $1 RETURNING parked_at IS NOT NULL`
+	parkOutboxQuery       = `UPDATE outbox SET last_error = $2, parked_at = $3, claimed_until = NULL WHERE id = $1`
+	deleteSentOutboxQuery = `DELETE FROM outbox WHERE sent_at < $1`
+)
+
+// withOutbox выполняет apply и добавляет команду в outbox в одной транзакции.
+func (r *Repository) withOutbox(ctx context.Context, command *models.ProcessingCommand, now time.Time, apply func(tx *sql.Tx) error) error {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Error("Failed to begin transaction", zap.Error(err))
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := apply(tx); err != nil {
+		return err
+	}
+	if err := insertOutbox(ctx, tx, command, now); err != nil {
+		r.log.Error("Failed to add command to outbox", zap.String("id", command.ID), zap.Error(err))
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Error("Failed to commit transaction", zap.String("id", command.ID), zap.Error(err))
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func insertOutbox(ctx context.Context, tx *sql.Tx, command *models.ProcessingCommand, now time.Time) error {
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return fmt.Errorf("failed to marshal command: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, insertOutboxQuery, payload, now); err != nil {
+		return fmt.Errorf("failed to add command to outbox: %w", err)
+	}
+	return nil
+}
+
+// ClaimOutbox забирает до limit неотправленных записей до момента until. Пока
+// аренда не истекла, другие экземпляры эти записи не видят; если отправивший
+// их процесс упадет, записи снова станут доступны после until. Записи, которые
+// не удалось разобрать, сразу откладываются.
+func (r *Repository) ClaimOutbox(ctx context.Context, limit int, now, until time.Time) ([]*models.OutboxEntry, error) {
+	rows, err := r.db.Master.QueryContext(ctx, claimOutboxQuery, limit, now, until)
+	if err != nil {
+		r.log.Error("Failed to claim outbox entries", zap.Error(err))
+		return nil, fmt.Errorf("failed to claim outbox entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]*models.OutboxEntry, 0, limit)
+	broken := make(map[int64]error)
+	for rows.Next() {
+		var entry models.OutboxEntry
+		var payload []byte
+		if err := rows.Scan(&entry.ID, &payload, &entry.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox entry: %w", err)
+		}
+		if err := json.Unmarshal(payload, &entry.Command); err != nil {
+			broken[entry.ID] = err
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate outbox entries: %w", err)
+	}
+	rows.Close()
+
+	for id, decodeErr := range broken {
+		r.log.Error("Parking undecodable outbox entry", zap.Int64("id", id), zap.Error(decodeErr))
+		if _, err := r.db.Master.ExecContext(ctx, parkOutboxQuery, id, decodeErr.Error(), now); err != nil {
+			r.log.Error("Failed to park outbox entry", zap.Int64("id", id), zap.Error(err))
+		}
+	}
+	// RETURNING не сохраняет порядок подзапроса
+	slices.SortFunc(entries, func(a, b *models.OutboxEntry) int { return cmp.Compare(a.ID, b.ID) })
+	return entries, nil
+}
+
+// MarkOutboxSent отмечает записи отправленными.
+func (r *Repository) MarkOutboxSent(ctx context.Context, ids []int64, now time.Time) error {
+	if _, err := r.db.Master.ExecContext(ctx, markOutboxSentQuery, pq.Array(ids), now); err != nil {
+		r.log.Error("Failed to mark outbox entries sent", zap.Error(err))
+		return fmt.Errorf("failed to mark outbox entries sent: %w", err)
+	}
+	return nil
+}
+
+// FailOutbox записывает неудачную попытку отправки. Запись повторяется не раньше
+// retryAt, а после maxAttempts попыток откладывается; возвращает true, если
+// запись отложена.
+func (r *Repository) FailOutbox(ctx context.Context, id int64, reason string, now, retryAt time.Time, maxAttempts int) (bool, error) {
+	var parked bool
+	err := r.db.Master.QueryRowContext(ctx, failOutboxQuery, id, reason, now, retryAt, maxAttempts).Scan(&parked)
+	if err != nil {
+		r.log.Error("Failed to record outbox error", zap.Int64("id", id), zap.Error(err))
+		return false, fmt.Errorf("failed to record outbox error: %w", err)
+	}
+	return parked, nil
+}
+
+// DeleteSentOutbox удаляет записи, отправленные раньше before.
+func (r *Repository) DeleteSentOutbox(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, deleteSentOutboxQuery, before)
+	if err != nil {
+		r.log.Error("Failed to delete sent outbox entries", zap.Error(err))
+		return 0, fmt.Errorf("failed to delete sent outbox entries: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
@@ -2,16 +2,22 @@ package repository
 
 import (
 	"ImageProcessor/internal/models"
+	"cmp"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/lib/pq"
 	"github.com/wb-go/wbf/dbpg"
 	"go.uber.org/zap"
+	"math/bits"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type Repository struct {
@@ -20,10 +26,78 @@ type Repository struct {
 }
 
 const (
-	createQuery       = `INSERT INTO images (id,status,original_path,created_at) VALUES ($1,$2,$3,$4)`
-	updateStatusQuery = `UPDATE images SET status = $1 WHERE id = $2`
+	createQuery = `INSERT INTO images (id,status,original_path,digest,created_at,batch_id,format,tags,
+		title,description,alt_text,labels,priority)
+		VALUES ($1,$2,$3,NULLIF($4,''),$5,NULLIF($6,'')::uuid,$7,COALESCE($8::text[],'{}'),$9,$10,$11,$12::jsonb,$13)`
+	// Отмена окончательна: воркер, закончивший работу позже, не перезаписывает ее
+	updateStatusQuery = `UPDATE images SET status = $1, lease_expires_at = NULL WHERE id = $2 AND status <> 'CANCELLED'`
+	cancelQuery       = `UPDATE images SET status = $1 WHERE id = $2 AND status = $3`
+	getStatusQuery    = `SELECT status FROM images WHERE id = $1`
 	deleteQuery       = `DELETE FROM images WHERE id = $1`
-	getQuery          = `SELECT id,status,original_path,created_at FROM images WHERE id = $1`
+	taskColumns       = `id,status,original_path,COALESCE(digest,''),created_at,COALESCE(batch_id::text,''),
+		format,width,height,tags,title,description,alt_text,labels,derivative_runs`
+	getQuery              = `SELECT ` + taskColumns + ` FROM images WHERE id = $1`
+	updateDimensionsQuery = `UPDATE images SET width = $1, height = $2 WHERE id = $3`
+	// Метки объединяются с текущими, а ключи из $6 удаляются
+	updateMetadataQuery = `UPDATE images SET
+		title = COALESCE($2, title),
+		description = COALESCE($3, description),
+		alt_text = COALESCE($4, alt_text),
+		labels = (labels || $5::jsonb) - $6::text[],
+		tags = COALESCE($7::text[], tags)
+		WHERE id = $1 RETURNING ` + taskColumns
+	// Блокировка строки не дает параллельным изменениям вместе превысить лимит меток
+	mergedLabelsCountQuery = `SELECT (SELECT count(*) FROM jsonb_object_keys((labels || $2::jsonb) - $3::text[]))
+		FROM images WHERE id = $1 FOR UPDATE`
+	updateHashesQuery = `UPDATE images SET ahash = $1, dhash = $2, phash = $3,
+		phash_b0 = $4, phash_b1 = $5, phash_b2 = $6, phash_b3 = $7 WHERE id = $8`
+	getHashQuery = `SELECT ahash,dhash,phash FROM images WHERE id = $1`
+	// Расстояние Хэмминга считается через XOR и подсчет единичных битов
+	similarQuery = `SELECT id,status,original_path,created_at,distance FROM (
+		SELECT id,status,original_path,created_at,
+			length(replace(((phash # $1)::bit(64))::text, '0', '')) AS distance
+		FROM images WHERE phash IS NOT NULL AND id::text <> $2 %s
+	) AS candidates WHERE distance <= $3 ORDER BY distance, created_at LIMIT $4`
+	similarBandsFilter = `AND (phash_b0 = ANY($5::integer[]) OR phash_b1 = ANY($6::integer[])
+		OR phash_b2 = ANY($7::integer[]) OR phash_b3 = ANY($8::integer[]))`
+
+	hashBands = 4
+	// maxBandRadius ограничивает перебор соседей части: при радиусе 2 это 137
+	// значений на часть, что покрывает maxDistance до 11 включительно
+	maxBandRadius = 2
+
+	acquireBlobQuery = `INSERT INTO blobs (digest,path,size,refcount) VALUES ($1,$2,$3,1)
+		ON CONFLICT (digest) DO UPDATE SET refcount = blobs.refcount + 1
+		RETURNING path, (xmax = 0) AS created`
+	decrementBlobQuery = `UPDATE blobs SET refcount = refcount - 1 WHERE digest = $1 RETURNING refcount, path`
+	deleteBlobQuery    = `DELETE FROM blobs WHERE digest = $1`
+	// Между снятием последней ссылки и удалением файлов blob мог снова понадобиться
+	purgeBlobQuery = `DELETE FROM blobs WHERE digest = $1 AND refcount <= 0 RETURNING path`
+
+	createBatchQuery = `INSERT INTO batches (id,created_at) VALUES ($1,$2) ON CONFLICT (id) DO NOTHING`
+	// LEFT JOIN оставляет строку с NULL-статусом для пакета без задач
+	batchCountsQuery = `SELECT b.created_at, i.status, count(i.id) FROM batches b
+		LEFT JOIN images i ON i.batch_id = b.id
+		WHERE b.id = $1 GROUP BY b.created_at, i.status`
+	addBatchRejectionsQuery = `INSERT INTO batch_rejections (batch_id,filename,error,created_at)
+		SELECT $1, filename, error, $4 FROM unnest($2::text[], $3::text[]) AS r(filename, error)`
+	batchRejectionsQuery = `SELECT filename,error FROM batch_rejections WHERE batch_id = $1 ORDER BY id`
+
+	uploadColumns     = `id,length,upload_offset,metadata,extension,COALESCE(task_id::text,''),created_at,expires_at`
+	createUploadQuery = `INSERT INTO uploads (id,length,metadata,extension,created_at,expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6) ON CONFLICT (id) DO NOTHING`
+	getUploadQuery = `SELECT ` + uploadColumns + ` FROM uploads WHERE id = $1`
+	// Блокировка — это аренда с ограниченным сроком: если запрос оборвется вместе
+	// с процессом, загрузку можно будет продолжить после истечения locked_until
+	lockUploadQuery = `UPDATE uploads SET locked_until = $4
+		WHERE id = $1 AND upload_offset = $2 AND (locked_until IS NULL OR locked_until < $3)
+		RETURNING ` + uploadColumns
+	commitUploadQuery = `UPDATE uploads SET upload_offset = $3, task_id = NULLIF($4,'')::uuid, locked_until = NULL
+		WHERE id = $1 AND upload_offset = $2`
+	unlockUploadQuery         = `UPDATE uploads SET locked_until = NULL WHERE id = $1`
+	deleteUploadQuery         = `DELETE FROM uploads WHERE id = $1`
+	deleteExpiredUploadsQuery = `DELETE FROM uploads
+		WHERE expires_at < $1 AND (locked_until IS NULL OR locked_until < $1) RETURNING id`
 )
 
 func NewRepository(masterDSN string, slaveDSNs []string, log *zap.Logger) (*Repository, error) {
@@ -48,13 +122,31 @@ func NewRepository(masterDSN string, slaveDSNs []string, log *zap.Logger) (*Repo
 	return &Repository{db: db, log: log.Named("repository")}, nil
 }
 
-func (r *Repository) CreateTask(ctx context.Context, task *models.Task) error {
-	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, createQuery, task.ID, task.Status, task.OriginalPath, task.CreatedAt)
-	if err != nil {
-		r.log.Error("Failed to create task", zap.Error(err))
-		return fmt.Errorf("failed to create task: %w", err)
+// Close закрывает соединения с мастером и репликами.
+func (r *Repository) Close() error {
+	errs := []error{r.db.Master.Close()}
+	for _, slave := range r.db.Slaves {
+		errs = append(errs, slave.Close())
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+// CreateTask сохраняет задачу вместе с командой ее обработки в outbox,
+// поэтому задача не может остаться без команды. Если передан key, он занимается
+// в той же транзакции; занятый действующий ключ дает ErrIdempotencyKeyInUse.
+func (r *Repository) CreateTask(ctx context.Context, task *models.Task, command *models.ProcessingCommand, key *models.IdempotencyKey) error {
+	return r.withOutbox(ctx, command, task.CreatedAt, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, createQuery, task.ID, task.Status, task.OriginalPath, task.Digest, task.CreatedAt, task.BatchID, task.Format, pq.Array(task.Tags),
+			task.Title, task.Description, task.Alt, stringMapJSON(task.Labels), cmp.Or(command.Priority, models.PriorityNormal))
+		if err != nil {
+			r.log.Error("Failed to create task", zap.Error(err))
+			return fmt.Errorf("failed to create task: %w", err)
+		}
+		if key == nil {
+			return nil
+		}
+		return r.claimIdempotencyKey(ctx, tx, key, task.ID, task.CreatedAt)
+	})
 }
 
 func (r *Repository) UpdateStatus(ctx context.Context, id string, status models.TaskStatus) error {
@@ -67,30 +159,553 @@ func (r *Repository) UpdateStatus(ctx context.Context, id string, status models.
 	r.log.Debug("Successfully updated status", zap.String("id", id), zap.Any("status", status))
 	return nil
 }
+
+// CancelTask переводит задачу в статус CANCELLED, если она еще обрабатывается.
+func (r *Repository) CancelTask(ctx context.Context, id string) error {
+	res, err := r.db.Master.ExecContext(ctx, cancelQuery, models.StatusCancelled, id, models.StatusProcessing)
+	if err != nil {
+		r.log.Error("Failed to cancel task", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to cancel task: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+
+	// Строка не обновилась: выясняем, есть ли задача вообще
+	status, err := r.GetStatus(ctx, id)
+	if err != nil {
+		return err
+	}
+	if status == models.StatusCancelled {
+		return nil
+	}
+	return fmt.Errorf("%w: task %s is %s", models.ErrNotCancellable, id, status)
+}
+
+// GetStatus читает статус задачи с мастера: воркер должен увидеть отмену сразу,
+// без задержки репликации.
+func (r *Repository) GetStatus(ctx context.Context, id string) (models.TaskStatus, error) {
+	var status models.TaskStatus
+	if err := r.db.Master.QueryRowContext(ctx, getStatusQuery, id).Scan(&status); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("failed to get status of %s: %w", id, models.ErrTaskNotFound)
+		}
+		r.log.Error("Failed to get task status", zap.String("id", id), zap.Error(err))
+		return "", fmt.Errorf("failed to get task status: %w", err)
+	}
+	return status, nil
+}
+
 func (r *Repository) GetTask(ctx context.Context, id string) (*models.Task, error) {
-	var task models.Task
 	row, err := r.db.QueryRowWithRetry(ctx, models.RetryStrategy, getQuery, id)
 	if err != nil {
 		r.log.Error("Failed to get task", zap.Error(err))
 		return nil, fmt.Errorf("failed to get task: %w", err)
 	}
-	err = row.Scan(&task.ID, &task.Status, &task.OriginalPath, &task.CreatedAt)
+	task, err := scanTask(row)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get task %s: %w", id, models.ErrTaskNotFound)
+		}
 		r.log.Error("Failed to get task", zap.Error(err))
 		return nil, fmt.Errorf("failed to get task: %w", err)
 	}
-	return &task, nil
+	return task, nil
+}
+
+// UpdateMetadata применяет частичное изменение метаданных и возвращает обновленную задачу.
+// Если после объединения меток их больше MaxLabels, возвращается ErrInvalidMetadata.
+func (r *Repository) UpdateMetadata(ctx context.Context, id string, patch models.MetadataPatch) (*models.Task, error) {
+	set := make(map[string]string)
+	remove := make([]string, 0)
+	for key, value := range patch.Labels {
+		if value == nil {
+			remove = append(remove, key)
+			continue
+		}
+		set[key] = *value
+	}
+	var tags any
+	if patch.Tags != nil {
+		tags = pq.Array(*patch.Tags)
+	}
+
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Error("Failed to begin transaction", zap.Error(err))
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Лимит относится к итоговому набору меток, а не к изменению
+	var labels int
+	err = tx.QueryRowContext(ctx, mergedLabelsCountQuery, id, stringMapJSON(set), pq.Array(remove)).Scan(&labels)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to update metadata of %s: %w", id, models.ErrTaskNotFound)
+		}
+		r.log.Error("Failed to count labels", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to count labels: %w", err)
+	}
+	if labels > models.MaxLabels {
+		return nil, fmt.Errorf("%w: at most %d labels are allowed", models.ErrInvalidMetadata, models.MaxLabels)
+	}
+
+	task, err := scanTask(tx.QueryRowContext(ctx, updateMetadataQuery, id,
+		patch.Title, patch.Description, patch.Alt, stringMapJSON(set), pq.Array(remove), tags))
+	if err != nil {
+		r.log.Error("Failed to update metadata", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Error("Failed to commit metadata", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to commit metadata: %w", err)
+	}
+	return task, nil
+}
+
+// UpdateDimensions сохраняет размеры оригинала, вычисленные воркером.
+func (r *Repository) UpdateDimensions(ctx context.Context, id string, width, height int) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, updateDimensionsQuery, width, height, id)
+	if err != nil {
+		r.log.Error("Failed to update dimensions", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to update dimensions: %w", err)
+	}
+	return nil
 }
 
-func (r *Repository) DeleteTask(ctx context.Context, id string) error {
-	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, deleteQuery, id)
+func (r *Repository) UpdateHashes(ctx context.Context, id string, hash *models.ImageHash) error {
+	bands := phashBands(hash.PHash)
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, updateHashesQuery,
+		int64(hash.AHash), int64(hash.DHash), int64(hash.PHash),
+		bands[0], bands[1], bands[2], bands[3], id)
+	if err != nil {
+		r.log.Error("Failed to update hashes", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to update hashes: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetHash(ctx context.Context, id string) (*models.ImageHash, error) {
+	row, err := r.db.QueryRowWithRetry(ctx, models.RetryStrategy, getHashQuery, id)
+	if err != nil {
+		r.log.Error("Failed to get hash", zap.Error(err))
+		return nil, fmt.Errorf("failed to get hash: %w", err)
+	}
+	var ahash, dhash, phash sql.NullInt64
+	if err := row.Scan(&ahash, &dhash, &phash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get hash %s: %w", id, models.ErrTaskNotFound)
+		}
+		r.log.Error("Failed to get hash", zap.Error(err))
+		return nil, fmt.Errorf("failed to get hash: %w", err)
+	}
+	if !phash.Valid {
+		return nil, models.ErrHashNotReady
+	}
+	return &models.ImageHash{
+		AHash: uint64(ahash.Int64),
+		DHash: uint64(dhash.Int64),
+		PHash: uint64(phash.Int64),
+	}, nil
+}
+
+// FindSimilar ищет изображения, pHash которых отличается от заданного не более чем на maxDistance бит.
+// Для маленьких расстояний поиск сужается по индексам частей хэша: если хэши отличаются
+// меньше чем на hashBands бит, хотя бы одна из частей обязана совпасть.
+func (r *Repository) FindSimilar(ctx context.Context, excludeID string, phash uint64, maxDistance, limit int) ([]models.SimilarImage, error) {
+	args := []any{int64(phash), excludeID, maxDistance, limit}
+	query := fmt.Sprintf(similarQuery, "")
+	// По принципу Дирихле у хешей на расстоянии не больше maxDistance хотя бы одна
+	// из hashBands частей отличается не больше чем на maxDistance/hashBands бит,
+	// поэтому кандидатов достаточно искать по индексам частей среди их соседей.
+	// При большем расстоянии соседей слишком много и просматривается вся таблица.
+	if radius := maxDistance / hashBands; radius <= maxBandRadius {
+		for _, band := range phashBands(phash) {
+			args = append(args, pq.Array(bandNeighbors(band, radius)))
+		}
+		query = fmt.Sprintf(similarQuery, similarBandsFilter)
+	}
+
+	rows, err := r.db.QueryWithRetry(ctx, models.RetryStrategy, query, args...)
+	if err != nil {
+		r.log.Error("Failed to find similar images", zap.Error(err))
+		return nil, fmt.Errorf("failed to find similar images: %w", err)
+	}
+	defer rows.Close()
+
+	similar := make([]models.SimilarImage, 0)
+	for rows.Next() {
+		var img models.SimilarImage
+		if err := rows.Scan(&img.ID, &img.Status, &img.OriginalPath, &img.CreatedAt, &img.Distance); err != nil {
+			r.log.Error("Failed to scan similar image", zap.Error(err))
+			return nil, fmt.Errorf("failed to scan similar image: %w", err)
+		}
+		similar = append(similar, img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate similar images: %w", err)
+	}
+	return similar, nil
+}
+
+func phashBands(phash uint64) [hashBands]int32 {
+	var bands [hashBands]int32
+	for i := range bands {
+		bands[i] = int32((phash >> (16 * uint(i))) & 0xFFFF)
+	}
+	return bands
+}
+
+// bandNeighbors возвращает все 16-битные значения, отличающиеся от band не больше чем на radius бит.
+func bandNeighbors(band int32, radius int) []int32 {
+	neighbors := make([]int32, 0)
+	for value := range int32(1 << 16) {
+		if bits.OnesCount16(uint16(value^band)) <= radius {
+			neighbors = append(neighbors, value)
+		}
+	}
+	return neighbors
+}
+
+// DeleteTask удаляет задачу и в той же транзакции снимает ее ссылку на blob с
+// дайджестом digest. Возвращает true, если ссылка была последней: запись blob с
+// нулевым счетчиком остается до PurgeBlob. Пустой digest у задач, созданных до
+// content-addressed хранилища.
+func (r *Repository) DeleteTask(ctx context.Context, id, digest string) (bool, error) {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Error("Failed to begin transaction", zap.Error(err))
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, deleteQuery, id)
 	if err != nil {
 		r.log.Error("Failed to delete task", zap.Error(err))
-		return fmt.Errorf("failed to delete task: %w", err)
+		return false, fmt.Errorf("failed to delete task: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get deleted rows: %w", err)
+	}
+	var refcount int
+	var path string
+	// Если задачу уже удалил параллельный запрос, ссылку снял он
+	if n > 0 && digest != "" {
+		if err := tx.QueryRowContext(ctx, decrementBlobQuery, digest).Scan(&refcount, &path); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				r.log.Error("Failed to release blob", zap.String("digest", digest), zap.Error(err))
+				return false, fmt.Errorf("failed to release blob: %w", err)
+			}
+			r.log.Warn("Attempted to release unknown blob", zap.String("digest", digest))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Error("Failed to commit task deletion", zap.String("id", id), zap.Error(err))
+		return false, fmt.Errorf("failed to commit task deletion: %w", err)
+	}
+	return path != "" && refcount <= 0, nil
+}
+
+// PurgeBlob удаляет запись blob без ссылок, а deleteFiles удаляет его файлы до
+// фиксации транзакции, как в ReleaseBlob. Если blob снова взят, ничего не удаляется.
+func (r *Repository) PurgeBlob(ctx context.Context, digest string, deleteFiles func(path string)) (bool, error) {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Error("Failed to begin transaction", zap.Error(err))
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var path string
+	if err := tx.QueryRowContext(ctx, purgeBlobQuery, digest).Scan(&path); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		r.log.Error("Failed to purge blob", zap.String("digest", digest), zap.Error(err))
+		return false, fmt.Errorf("failed to purge blob: %w", err)
+	}
+	deleteFiles(path)
+	if err := tx.Commit(); err != nil {
+		r.log.Error("Failed to commit blob purge", zap.String("digest", digest), zap.Error(err))
+		return false, fmt.Errorf("failed to commit blob purge: %w", err)
+	}
+	return true, nil
+}
+
+// AcquireBlob добавляет ссылку на blob с указанным дайджестом.
+// Если blob уже есть, возвращается его путь и created = false. Файла по этому пути
+// может еще не быть, если первый загрузивший его пока не перенес.
+func (r *Repository) AcquireBlob(ctx context.Context, digest, path string, size int64) (string, bool, error) {
+	var storedPath string
+	var created bool
+	// Запрос не идемпотентен, поэтому выполняется на мастере без повторов
+	err := r.db.Master.QueryRowContext(ctx, acquireBlobQuery, digest, path, size).Scan(&storedPath, &created)
+	if err != nil {
+		r.log.Error("Failed to acquire blob", zap.String("digest", digest), zap.Error(err))
+		return "", false, fmt.Errorf("failed to acquire blob: %w", err)
+	}
+	return storedPath, created, nil
+}
+
+// ReleaseBlob снимает ссылку на blob. Если ссылка была последней, запись удаляется,
+// а deleteFiles удаляет файлы blob до фиксации транзакции: пока строка заблокирована,
+// AcquireBlob с тем же дайджестом ждет и не может положить новый файл на тот же путь.
+func (r *Repository) ReleaseBlob(ctx context.Context, digest string, deleteFiles func(path string)) (bool, error) {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Error("Failed to begin transaction", zap.Error(err))
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var refcount int
+	var path string
+	if err := tx.QueryRowContext(ctx, decrementBlobQuery, digest).Scan(&refcount, &path); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.log.Warn("Attempted to release unknown blob", zap.String("digest", digest))
+			return false, nil
+		}
+		r.log.Error("Failed to release blob", zap.String("digest", digest), zap.Error(err))
+		return false, fmt.Errorf("failed to release blob: %w", err)
+	}
+
+	last := refcount <= 0
+	if last {
+		if _, err := tx.ExecContext(ctx, deleteBlobQuery, digest); err != nil {
+			r.log.Error("Failed to delete blob", zap.String("digest", digest), zap.Error(err))
+			return false, fmt.Errorf("failed to delete blob: %w", err)
+		}
+		deleteFiles(path)
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Error("Failed to commit blob release", zap.String("digest", digest), zap.Error(err))
+		return false, fmt.Errorf("failed to commit blob release: %w", err)
+	}
+	return last, nil
+}
+
+func (r *Repository) CreateBatch(ctx context.Context, batch *models.Batch) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, createBatchQuery, batch.ID, batch.CreatedAt)
+	if err != nil {
+		r.log.Error("Failed to create batch", zap.Error(err))
+		return fmt.Errorf("failed to create batch: %w", err)
+	}
+	return nil
+}
+
+// GetBatch возвращает пакет с количеством задач в каждом статусе.
+func (r *Repository) GetBatch(ctx context.Context, id string) (*models.Batch, error) {
+	rows, err := r.db.QueryWithRetry(ctx, models.RetryStrategy, batchCountsQuery, id)
+	if err != nil {
+		r.log.Error("Failed to get batch", zap.Error(err))
+		return nil, fmt.Errorf("failed to get batch: %w", err)
+	}
+	defer rows.Close()
+
+	batch := &models.Batch{ID: id, Counts: make(map[models.TaskStatus]int)}
+	found := false
+	for rows.Next() {
+		var status sql.NullString
+		var count int
+		if err := rows.Scan(&batch.CreatedAt, &status, &count); err != nil {
+			r.log.Error("Failed to scan batch counts", zap.Error(err))
+			return nil, fmt.Errorf("failed to scan batch counts: %w", err)
+		}
+		found = true
+		if status.Valid {
+			batch.Counts[models.TaskStatus(status.String)] = count
+			batch.Total += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate batch counts: %w", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("failed to get batch %s: %w", id, models.ErrBatchNotFound)
+	}
+
+	rejected, err := r.db.QueryWithRetry(ctx, models.RetryStrategy, batchRejectionsQuery, id)
+	if err != nil {
+		r.log.Error("Failed to get batch rejections", zap.Error(err))
+		return nil, fmt.Errorf("failed to get batch rejections: %w", err)
+	}
+	defer rejected.Close()
+	batch.Rejected = make([]models.BatchRejection, 0)
+	for rejected.Next() {
+		var rejection models.BatchRejection
+		if err := rejected.Scan(&rejection.Filename, &rejection.Error); err != nil {
+			r.log.Error("Failed to scan batch rejection", zap.Error(err))
+			return nil, fmt.Errorf("failed to scan batch rejection: %w", err)
+		}
+		batch.Rejected = append(batch.Rejected, rejection)
+	}
+	if err := rejected.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate batch rejections: %w", err)
+	}
+	batch.Total += len(batch.Rejected)
+	return batch, nil
+}
+
+// AddBatchRejections сохраняет файлы пакета, отклоненные при загрузке.
+func (r *Repository) AddBatchRejections(ctx context.Context, batchID string, rejections []models.BatchRejection, now time.Time) error {
+	filenames := make([]string, len(rejections))
+	errs := make([]string, len(rejections))
+	for i, rejection := range rejections {
+		filenames[i], errs[i] = rejection.Filename, rejection.Error
+	}
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, addBatchRejectionsQuery, batchID, pq.Array(filenames), pq.Array(errs), now)
+	if err != nil {
+		r.log.Error("Failed to add batch rejections", zap.String("batch", batchID), zap.Error(err))
+		return fmt.Errorf("failed to add batch rejections: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) CreateUpload(ctx context.Context, upload *models.Upload) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, createUploadQuery,
+		upload.ID, upload.Length, upload.Metadata, upload.Extension, upload.CreatedAt, upload.ExpiresAt)
+	if err != nil {
+		r.log.Error("Failed to create upload", zap.Error(err))
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+	return nil
+}
+
+// GetUpload читает загрузку с мастера: смещение на реплике может отставать,
+// а клиент продолжает загрузку именно с него.
+func (r *Repository) GetUpload(ctx context.Context, id string) (*models.Upload, error) {
+	upload, err := scanUpload(r.db.Master.QueryRowContext(ctx, getUploadQuery, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get upload %s: %w", id, models.ErrUploadNotFound)
+		}
+		r.log.Error("Failed to get upload", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to get upload: %w", err)
+	}
+	return upload, nil
+}
+
+// LockUpload захватывает загрузку для записи части с указанного смещения до момента until.
+func (r *Repository) LockUpload(ctx context.Context, id string, offset int64, now, until time.Time) (*models.Upload, error) {
+	upload, err := scanUpload(r.db.Master.QueryRowContext(ctx, lockUploadQuery, id, offset, now, until))
+	if err == nil {
+		return upload, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		r.log.Error("Failed to lock upload", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to lock upload: %w", err)
+	}
+
+	// Строка не обновилась: выясняем причину, чтобы вернуть клиенту правильный статус
+	upload, err = r.GetUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Offset != offset {
+		return nil, fmt.Errorf("%w: expected %d, got %d", models.ErrUploadOffsetMismatch, upload.Offset, offset)
+	}
+	return nil, models.ErrUploadLocked
+}
+
+// CommitUpload сдвигает смещение после записи части и снимает блокировку.
+func (r *Repository) CommitUpload(ctx context.Context, id string, from, to int64, taskID string) error {
+	res, err := r.db.Master.ExecContext(ctx, commitUploadQuery, id, from, to, taskID)
+	if err != nil {
+		r.log.Error("Failed to commit upload", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to commit upload: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: upload %s moved from offset %d", models.ErrUploadOffsetMismatch, id, from)
+	}
+	return nil
+}
+
+func (r *Repository) UnlockUpload(ctx context.Context, id string) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, unlockUploadQuery, id)
+	if err != nil {
+		r.log.Error("Failed to unlock upload", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to unlock upload: %w", err)
 	}
 	return nil
 }
 
+func (r *Repository) DeleteUpload(ctx context.Context, id string) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, deleteUploadQuery, id)
+	if err != nil {
+		r.log.Error("Failed to delete upload", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredUploads удаляет просроченные загрузки и возвращает их идентификаторы.
+func (r *Repository) DeleteExpiredUploads(ctx context.Context, now time.Time) ([]string, error) {
+	rows, err := r.db.Master.QueryContext(ctx, deleteExpiredUploadsQuery, now)
+	if err != nil {
+		r.log.Error("Failed to delete expired uploads", zap.Error(err))
+		return nil, fmt.Errorf("failed to delete expired uploads: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan expired upload: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate expired uploads: %w", err)
+	}
+	return ids, nil
+}
+
+// rowScanner — общий интерфейс *sql.Row и *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTask(row rowScanner) (*models.Task, error) {
+	var task models.Task
+	var labels, runs []byte
+	err := row.Scan(&task.ID, &task.Status, &task.OriginalPath, &task.Digest, &task.CreatedAt, &task.BatchID,
+		&task.Format, &task.Width, &task.Height, pq.Array(&task.Tags),
+		&task.Title, &task.Description, &task.Alt, &labels, &runs)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(labels, &task.Labels); err != nil {
+		return nil, fmt.Errorf("failed to decode labels: %w", err)
+	}
+	if err := json.Unmarshal(runs, &task.DerivativeRuns); err != nil {
+		return nil, fmt.Errorf("failed to decode derivative runs: %w", err)
+	}
+	return &task, nil
+}
+
+// stringMapJSON кодирует словарь строк в объект JSONB; пустой словарь дает "{}", а не NULL.
+func stringMapJSON(values map[string]string) string {
+	if len(values) == 0 {
+		return "{}"
+	}
+	// Ключи и значения — строки, поэтому ошибки кодирования быть не может
+	encoded, _ := json.Marshal(values)
+	return string(encoded)
+}
+
+func scanUpload(row *sql.Row) (*models.Upload, error) {
+	var upload models.Upload
+	err := row.Scan(&upload.ID, &upload.Length, &upload.Offset, &upload.Metadata, &upload.Extension,
+		&upload.TaskID, &upload.CreatedAt, &upload.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
 func runMigrations(connStr string) error {
 	migratePath := os.Getenv("MIGRATE_PATH")
 	if migratePath == "" {
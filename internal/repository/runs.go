@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	runColumns     = `id,image_id,operations,mode,status,error,created_at,finished_at`
+	createRunQuery = `INSERT INTO processing_runs (id,image_id,operations,mode,status,created_at)
+		VALUES ($1,$2,$3,$4,$5,$6) ON CONFLICT (id) DO NOTHING`
+	getRunQuery               = `SELECT ` + runColumns + ` FROM processing_runs WHERE id = $1`
+	listRunsQuery             = `SELECT ` + runColumns + ` FROM processing_runs WHERE image_id = $1 ORDER BY created_at DESC`
+	finishRunQuery            = `UPDATE processing_runs SET status = $2, error = $3, finished_at = $4 WHERE id = $1`
+	switchDerivativeRunsQuery = `UPDATE images SET derivative_runs = derivative_runs || $2::jsonb, status = $3
+		WHERE id = $1`
+)
+
+// CreateRun сохраняет запуск вместе с командой его обработки в outbox.
+func (r *Repository) CreateRun(ctx context.Context, run *models.ProcessingRun, command *models.ProcessingCommand) error {
+	return r.withOutbox(ctx, command, run.CreatedAt, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, createRunQuery,
+			run.ID, run.ImageID, pq.Array(run.Operations), run.Mode, run.Status, run.CreatedAt)
+		if err != nil {
+			r.log.Error("Failed to create processing run", zap.Error(err))
+			return fmt.Errorf("failed to create processing run: %w", err)
+		}
+		return nil
+	})
+}
+
+func (r *Repository) GetRun(ctx context.Context, id string) (*models.ProcessingRun, error) {
+	row, err := r.db.QueryRowWithRetry(ctx, models.RetryStrategy, getRunQuery, id)
+	if err != nil {
+		r.log.Error("Failed to get processing run", zap.Error(err))
+		return nil, fmt.Errorf("failed to get processing run: %w", err)
+	}
+	run, err := scanRun(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get processing run %s: %w", id, models.ErrRunNotFound)
+		}
+		r.log.Error("Failed to get processing run", zap.Error(err))
+		return nil, fmt.Errorf("failed to get processing run: %w", err)
+	}
+	return run, nil
+}
+
+// ListRuns возвращает запуски повторной обработки изображения, начиная с последнего.
+func (r *Repository) ListRuns(ctx context.Context, imageID string) ([]*models.ProcessingRun, error) {
+	rows, err := r.db.QueryWithRetry(ctx, models.RetryStrategy, listRunsQuery, imageID)
+	if err != nil {
+		r.log.Error("Failed to list processing runs", zap.Error(err))
+		return nil, fmt.Errorf("failed to list processing runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := make([]*models.ProcessingRun, 0)
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			r.log.Error("Failed to scan processing run", zap.Error(err))
+			return nil, fmt.Errorf("failed to scan processing run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate processing runs: %w", err)
+	}
+	return runs, nil
+}
+
+// FailRun отмечает запуск неудачным; пути изображения не меняются.
+func (r *Repository) FailRun(ctx context.Context, id, reason string, now time.Time) error {
+	_, err := r.db.ExecWithRetry(ctx, models.RetryStrategy, finishRunQuery, id, models.StatusFailed, reason, now)
+	if err != nil {
+		r.log.Error("Failed to fail processing run", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to fail processing run: %w", err)
+	}
+	return nil
+}
+
+// CompleteRun завершает запуск и переключает изображение на его результаты.
+func (r *Repository) CompleteRun(ctx context.Context, id, imageID string, outputs map[string]string, now time.Time) error {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Error("Failed to begin transaction", zap.Error(err))
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, switchDerivativeRunsQuery, imageID, stringMapJSON(outputs), models.StatusComplete)
+	if err != nil {
+		r.log.Error("Failed to switch derivative runs", zap.String("id", imageID), zap.Error(err))
+		return fmt.Errorf("failed to switch derivative runs: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to complete run %s: %w", id, models.ErrTaskNotFound)
+	}
+	if _, err := tx.ExecContext(ctx, finishRunQuery, id, models.StatusComplete, "", now); err != nil {
+		r.log.Error("Failed to complete processing run", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to complete processing run: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Error("Failed to commit processing run", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to commit processing run: %w", err)
+	}
+	return nil
+}
+
+func scanRun(row rowScanner) (*models.ProcessingRun, error) {
+	var run models.ProcessingRun
+	var finishedAt sql.NullTime
+	err := row.Scan(&run.ID, &run.ImageID, pq.Array(&run.Operations), &run.Mode, &run.Status, &run.Error,
+		&run.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return &run, nil
+}
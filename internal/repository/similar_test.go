@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestBandNeighbors(t *testing.T) {
+	for radius, want := range []int{1, 17, 137} {
+		neighbors := bandNeighbors(0xBEEF, radius)
+		if len(neighbors) != want {
+			t.Errorf("radius %d: %d neighbors, want %d", radius, len(neighbors), want)
+		}
+		if !slices.Contains(neighbors, 0xBEEF) {
+			t.Errorf("radius %d: band itself is missing", radius)
+		}
+	}
+}
+
+// TestBandFilterFindsAllWithinDistance проверяет, что предварительный отбор
+// по частям pHash не теряет ни одного хеша в пределах maxDistance.
+func TestBandFilterFindsAllWithinDistance(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for maxDistance := range (maxBandRadius + 1) * hashBands {
+		radius := maxDistance / hashBands
+		for range 200 {
+			phash := rng.Uint64()
+			other := phash
+			for _, bit := range rng.Perm(64)[:maxDistance] {
+				other ^= 1 << bit
+			}
+			if bits.OnesCount64(phash^other) != maxDistance {
+				t.Fatal("wrong number of flipped bits")
+			}
+
+			query, candidate := phashBands(phash), phashBands(other)
+			matched := false
+			for i := range hashBands {
+				matched = matched || slices.Contains(bandNeighbors(query[i], radius), candidate[i])
+			}
+			if !matched {
+				t.Fatalf("distance %d: %016x is not matched by %016x", maxDistance, other, phash)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package deadletter_service
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// retryDelay — пауза перед повтором после ошибки брокера или базы.
+const retryDelay = time.Second
+
+type Repo interface {
+	SaveDeadLetter(ctx context.Context, letter *models.DeadLetter) error
+	GetDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error)
+	ListDeadLetters(ctx context.Context, pending bool, limit, offset int) ([]*models.DeadLetter, error)
+	ClaimReplay(ctx context.Context, id string, now time.Time) (*models.DeadLetter, error)
+	ReleaseReplay(ctx context.Context, id string) error
+}
+
+type Consume interface {
+	FetchMessage(ctx context.Context) (kafkaGo.Message, error)
+	CommitMessage(ctx context.Context, msg kafkaGo.Message) error
+}
+
+// Producer возвращает сообщение в исходный топик.
+type Producer interface {
+	Resend(ctx context.Context, topic string, key, value []byte) error
+}
+
+// DeadLetterService переносит очередь недоставленных в базу, чтобы ее можно было
+// просматривать, и возвращает выбранные сообщения на обработку.
+type DeadLetterService struct {
+	repo    Repo
+	consume Consume
+	produce Producer
+	log     *zap.Logger
+}
+
+func NewDeadLetterService(repo Repo, consume Consume, produce Producer, log *zap.Logger) *DeadLetterService {
+	return &DeadLetterService{
+		repo:    repo,
+		consume: consume,
+		produce: produce,
+		log:     log.Named("dead_letter_service"),
+	}
+}
+
+// Run читает очередь недоставленных до отмены ctx. Сообщение подтверждается
+// только после сохранения; пока база недоступна, сохранение повторяется.
+func (s *DeadLetterService) Run(ctx context.Context) {
+	for {
+		msg, err := s.consume.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.log.Warn("Failed to fetch dead letter", zap.Error(err))
+			if !sleep(ctx, retryDelay) {
+				return
+			}
+			continue
+		}
+		for {
+			err := s.repo.SaveDeadLetter(ctx, deadLetter(msg))
+			if err == nil {
+				break
+			}
+			s.log.Error("Failed to store dead letter", zap.Int64("offset", msg.Offset), zap.Error(err))
+			if !sleep(ctx, retryDelay) {
+				return
+			}
+		}
+		if err := s.consume.CommitMessage(ctx, msg); err != nil {
+			s.log.Warn("Failed to commit dead letter", zap.Int64("offset", msg.Offset), zap.Error(err))
+		}
+	}
+}
+
+func (s *DeadLetterService) GetDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error) {
+	if err := uuid.Validate(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", models.ErrDeadLetterNotFound, id)
+	}
+	letter, err := s.repo.GetDeadLetter(ctx, id)
+	if err != nil {
+		s.log.Error("failed to get dead letter", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	return letter, nil
+}
+
+// ListDeadLetters возвращает сообщения, начиная с последних. Нулевой limit
+// означает размер страницы по умолчанию.
+func (s *DeadLetterService) ListDeadLetters(ctx context.Context, pending bool, limit, offset int) ([]*models.DeadLetter, error) {
+	if limit == 0 {
+		limit = models.DefaultPageLimit
+	}
+	limit = min(limit, models.MaxPageLimit)
+	letters, err := s.repo.ListDeadLetters(ctx, pending, limit, offset)
+	if err != nil {
+		s.log.Error("failed to list dead letters", zap.Error(err))
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetter возвращает сообщение в исходный топик без изменений.
+// Каждое сообщение повторяется один раз; если оно снова не обработается,
+// воркер пришлет его новой записью.
+func (s *DeadLetterService) ReplayDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error) {
+	if err := uuid.Validate(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", models.ErrDeadLetterNotFound, id)
+	}
+	letter, err := s.repo.ClaimReplay(ctx, id, time.Now())
+	if err != nil {
+		s.log.Error("failed to claim dead letter replay", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to replay dead letter: %w", err)
+	}
+
+	if err := s.produce.Resend(ctx, letter.Topic, []byte(letter.Key), []byte(letter.Payload)); err != nil {
+		s.log.Error("failed to resend dead letter, releasing replay", zap.String("id", id), zap.Error(err))
+		if releaseErr := s.repo.ReleaseReplay(context.WithoutCancel(ctx), id); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+		return nil, fmt.Errorf("failed to replay dead letter: %w", err)
+	}
+	s.log.Info("Dead letter replayed", zap.String("id", id), zap.String("topic", letter.Topic))
+	return letter, nil
+}
+
+// sleep ждет d и сообщает false, если ctx отменили раньше.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// deadLetter собирает запись из сообщения и его заголовков. Идентификатор выводится
+// из позиции сообщения, поэтому повторное чтение не создает вторую запись.
+func deadLetter(msg kafkaGo.Message) *models.DeadLetter {
+	letter := &models.DeadLetter{
+		ID:       uuid.NewSHA1(uuid.NameSpaceURL, fmt.Appendf(nil, "kafka:%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)).String(),
+		Key:      string(msg.Key),
+		Payload:  string(msg.Value),
+		FailedAt: msg.Time,
+	}
+	for _, header := range msg.Headers {
+		value := string(header.Value)
+		switch header.Key {
+		case models.DeadLetterTopicHeader:
+			letter.Topic = value
+		case models.DeadLetterErrorHeader:
+			letter.Error = value
+		case models.DeadLetterAttemptsHeader:
+			letter.Attempts, _ = strconv.Atoi(value)
+		case models.DeadLetterFailedAtHeader:
+			if failedAt, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				letter.FailedAt = failedAt
+			}
+		}
+	}
+	return letter
+}
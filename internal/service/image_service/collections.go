@@ -0,0 +1,164 @@
+package image_service
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CreateCollection создает пустую коллекцию.
+func (s *ImageService) CreateCollection(ctx context.Context, name string) (*models.Collection, error) {
+	name, err := collectionName(name)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	collection := &models.Collection{ID: uuid.New().String(), Name: name, CreatedAt: now, UpdatedAt: now}
+	if err := s.repo.CreateCollection(ctx, collection); err != nil {
+		s.log.Error("failed to create collection", zap.Error(err))
+		return nil, fmt.Errorf("failed to create collection: %w", err)
+	}
+	return collection, nil
+}
+
+// GetCollection возвращает коллекцию с изображениями и подписанными ссылками на них.
+func (s *ImageService) GetCollection(ctx context.Context, id string) (*models.Collection, error) {
+	if err := checkCollectionID(id); err != nil {
+		return nil, err
+	}
+	collection, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		s.log.Error("failed to get collection", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+	for _, task := range collection.Images {
+		task.URLs = s.signedURLs(task)
+	}
+	return collection, nil
+}
+
+func (s *ImageService) RenameCollection(ctx context.Context, id, name string) (*models.Collection, error) {
+	if err := checkCollectionID(id); err != nil {
+		return nil, err
+	}
+	name, err := collectionName(name)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := s.repo.RenameCollection(ctx, id, name, time.Now())
+	if err != nil {
+		s.log.Error("failed to rename collection", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to rename collection: %w", err)
+	}
+	return collection, nil
+}
+
+// DeleteCollection удаляет коллекцию; сами изображения остаются.
+func (s *ImageService) DeleteCollection(ctx context.Context, id string) error {
+	if err := checkCollectionID(id); err != nil {
+		return err
+	}
+	if err := s.repo.DeleteCollection(ctx, id); err != nil {
+		s.log.Error("failed to delete collection", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to delete collection: %w", err)
+	}
+	return nil
+}
+
+// AddToCollection добавляет изображения в конец коллекции в порядке imageIDs.
+func (s *ImageService) AddToCollection(ctx context.Context, id string, imageIDs []string) error {
+	if err := checkCollectionID(id); err != nil {
+		return err
+	}
+	ids, err := collectionImageIDs(imageIDs)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.AddToCollection(ctx, id, ids, time.Now()); err != nil {
+		s.log.Error("failed to add images to collection", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to add images to collection: %w", err)
+	}
+	return nil
+}
+
+func (s *ImageService) RemoveFromCollection(ctx context.Context, id, imageID string) error {
+	if err := checkCollectionID(id); err != nil {
+		return err
+	}
+	if err := uuid.Validate(imageID); err != nil {
+		return fmt.Errorf("%w: %s", models.ErrNotInCollection, imageID)
+	}
+	if err := s.repo.RemoveFromCollection(ctx, id, imageID, time.Now()); err != nil {
+		s.log.Error("failed to remove image from collection", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to remove image from collection: %w", err)
+	}
+	return nil
+}
+
+// ReorderCollection задает новый порядок: imageIDs перечисляет все изображения коллекции.
+func (s *ImageService) ReorderCollection(ctx context.Context, id string, imageIDs []string) error {
+	if err := checkCollectionID(id); err != nil {
+		return err
+	}
+	ids, err := collectionImageIDs(imageIDs)
+	if err != nil {
+		return err
+	}
+	if len(ids) != len(imageIDs) {
+		return fmt.Errorf("%w: order contains duplicate images", models.ErrInvalidCollection)
+	}
+	if err := s.repo.ReorderCollection(ctx, id, ids, time.Now()); err != nil {
+		s.log.Error("failed to reorder collection", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to reorder collection: %w", err)
+	}
+	return nil
+}
+
+// checkCollectionID отсекает идентификаторы, которые не могут существовать,
+// до обращения к базе.
+func checkCollectionID(id string) error {
+	if err := uuid.Validate(id); err != nil {
+		return fmt.Errorf("%w: %s", models.ErrCollectionNotFound, id)
+	}
+	return nil
+}
+
+func collectionName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: name is required", models.ErrInvalidCollection)
+	}
+	if utf8.RuneCountInString(name) > models.MaxCollectionNameLength {
+		return "", fmt.Errorf("%w: name is longer than %d characters", models.ErrInvalidCollection, models.MaxCollectionNameLength)
+	}
+	return name, nil
+}
+
+// collectionImageIDs проверяет идентификаторы, приводит их к каноническому виду
+// и убирает повторы с сохранением порядка.
+func collectionImageIDs(imageIDs []string) ([]string, error) {
+	if len(imageIDs) == 0 {
+		return nil, fmt.Errorf("%w: images must not be empty", models.ErrInvalidCollection)
+	}
+	if len(imageIDs) > models.MaxCollectionSize {
+		return nil, fmt.Errorf("%w: at most %d images per request", models.ErrInvalidCollection, models.MaxCollectionSize)
+	}
+	seen := make(map[string]bool, len(imageIDs))
+	ids := make([]string, 0, len(imageIDs))
+	for _, raw := range imageIDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid image id %q", models.ErrInvalidCollection, raw)
+		}
+		if !seen[id.String()] {
+			seen[id.String()] = true
+			ids = append(ids, id.String())
+		}
+	}
+	return ids, nil
+}
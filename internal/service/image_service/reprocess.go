@@ -0,0 +1,174 @@
+package image_service
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"slices"
+	"time"
+)
+
+// Reprocess повторно отправляет сохраненный оригинал на обработку. Запуск
+// отслеживается отдельно: пока он идет, изображение отдает прежние результаты.
+func (s *ImageService) Reprocess(ctx context.Context, id string, req models.ReprocessRequest) (*models.ProcessingRun, error) {
+	req, err := normalizeReprocess(req, models.PriorityNormal)
+	if err != nil {
+		return nil, err
+	}
+	task, err := s.repo.GetTask(ctx, id)
+	if err != nil {
+		s.log.Error("failed to get task", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+	if task.Status == models.StatusProcessing {
+		return nil, fmt.Errorf("%w: %s", models.ErrTaskBusy, id)
+	}
+	return s.startRun(ctx, task, req)
+}
+
+// BulkReprocess запускает повторную обработку всех изображений, подходящих под фильтр.
+// Изображения, которые еще обрабатываются, пропускаются. По умолчанию задачи
+// идут в очередь массовой обработки.
+func (s *ImageService) BulkReprocess(ctx context.Context, filter models.ImageFilter, req models.ReprocessRequest) (*models.BulkReprocessResult, error) {
+	req, err := normalizeReprocess(req, models.PriorityBulk)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.Cursor = ""
+	filter.Limit = models.MaxPageLimit
+	result := &models.BulkReprocessResult{Runs: make([]*models.ProcessingRun, 0), Skipped: make([]string, 0)}
+	for {
+		page, err := s.repo.ListTasks(ctx, filter)
+		if err != nil {
+			s.log.Error("failed to list tasks for reprocessing", zap.Error(err))
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+		if page.Total > models.MaxBulkReprocess {
+			return nil, fmt.Errorf("%w: filter matches %d images, at most %d are allowed",
+				models.ErrInvalidReprocess, page.Total, models.MaxBulkReprocess)
+		}
+
+		for _, task := range page.Items {
+			if task.Status == models.StatusProcessing {
+				result.Skipped = append(result.Skipped, task.ID)
+				continue
+			}
+			run, err := s.startRun(ctx, task, req)
+			if err != nil {
+				return nil, err
+			}
+			result.Runs = append(result.Runs, run)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+	s.log.Info("Bulk reprocessing started", zap.Int("runs", len(result.Runs)), zap.Int("skipped", len(result.Skipped)))
+	return result, nil
+}
+
+func (s *ImageService) GetRun(ctx context.Context, id string) (*models.ProcessingRun, error) {
+	if err := uuid.Validate(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", models.ErrRunNotFound, id)
+	}
+	run, err := s.repo.GetRun(ctx, id)
+	if err != nil {
+		s.log.Error("failed to get processing run", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to get processing run: %w", err)
+	}
+	return run, nil
+}
+
+func (s *ImageService) ListRuns(ctx context.Context, imageID string) ([]*models.ProcessingRun, error) {
+	if _, err := s.repo.GetTask(ctx, imageID); err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+	runs, err := s.repo.ListRuns(ctx, imageID)
+	if err != nil {
+		s.log.Error("failed to list processing runs", zap.String("id", imageID), zap.Error(err))
+		return nil, fmt.Errorf("failed to list processing runs: %w", err)
+	}
+	return runs, nil
+}
+
+// startRun создает запуск вместе с командой обработки для него.
+func (s *ImageService) startRun(ctx context.Context, task *models.Task, req models.ReprocessRequest) (*models.ProcessingRun, error) {
+	run := &models.ProcessingRun{
+		ID:         uuid.New().String(),
+		ImageID:    task.ID,
+		Operations: req.Operations,
+		Mode:       req.Mode,
+		Status:     models.StatusProcessing,
+		CreatedAt:  time.Now(),
+	}
+
+	// Результаты всегда пишутся в пути нового запуска: перезапись на месте оставила бы
+	// старые байты в кэшах под прежним ETag и задела бы общие производные того же
+	// содержимого. При замене удаляются только собственные результаты прежних запусков.
+	outputs := make(map[string]string, len(run.Operations))
+	var replaced map[string]string
+	for _, operation := range run.Operations {
+		outputs[operation] = run.ID
+		if previous := task.DerivativeRuns[operation]; run.Mode == models.ReprocessReplace && previous != "" {
+			if replaced == nil {
+				replaced = make(map[string]string)
+			}
+			replaced[operation] = previous
+		}
+	}
+
+	command := &models.ProcessingCommand{
+		ID:                  task.ID,
+		OriginalPath:        task.OriginalPath,
+		RequestedOperations: run.Operations,
+		CreatedAt:           run.CreatedAt,
+		RunID:               run.ID,
+		OutputRuns:          outputs,
+		Replace:             run.Mode == models.ReprocessReplace,
+		ReplacedRuns:        replaced,
+		Priority:            req.Priority,
+	}
+	if err := s.repo.CreateRun(ctx, run, command); err != nil {
+		s.log.Error("failed to create processing run", zap.String("id", task.ID), zap.Error(err))
+		return nil, fmt.Errorf("failed to create processing run: %w", err)
+	}
+	s.outbox.Notify()
+	s.log.Info("Reprocessing started", zap.String("id", task.ID), zap.String("run", run.ID), zap.String("mode", run.Mode))
+	return run, nil
+}
+
+// normalizeReprocess подставляет значения по умолчанию и проверяет операции.
+func normalizeReprocess(req models.ReprocessRequest, priority models.Priority) (models.ReprocessRequest, error) {
+	var err error
+	if req.Priority, err = models.ParsePriority(string(req.Priority), priority); err != nil {
+		return req, fmt.Errorf("%w: %v", models.ErrInvalidReprocess, err)
+	}
+	switch req.Mode {
+	case "":
+		req.Mode = models.ReprocessVersion
+	case models.ReprocessVersion, models.ReprocessReplace:
+	default:
+		return req, fmt.Errorf("%w: mode must be %s or %s", models.ErrInvalidReprocess, models.ReprocessVersion, models.ReprocessReplace)
+	}
+
+	if len(req.Operations) == 0 {
+		req.Operations = models.RequestedOperations
+		return req, nil
+	}
+	operations := make([]string, 0, len(req.Operations))
+	for _, operation := range req.Operations {
+		if !slices.Contains(models.RequestedOperations, operation) {
+			return req, fmt.Errorf("%w: %s", models.ErrUnknownOperation, operation)
+		}
+		if !slices.Contains(operations, operation) {
+			operations = append(operations, operation)
+		}
+	}
+	req.Operations = operations
+	return req, nil
+}
@@ -3,91 +3,416 @@ package image_service
 import (
 	"ImageProcessor/internal/models"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"io"
+	"maps"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
+const similarLimit = 50
+
 type Repo interface {
-	CreateTask(ctx context.Context, task *models.Task) error
+	CreateTask(ctx context.Context, task *models.Task, command *models.ProcessingCommand, key *models.IdempotencyKey) error
+	GetIdempotentTask(ctx context.Context, key string, now time.Time) (string, string, error)
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
 	UpdateStatus(ctx context.Context, id string, status models.TaskStatus) error
 	GetTask(ctx context.Context, id string) (*models.Task, error)
-	DeleteTask(ctx context.Context, id string) error
+	DeleteTask(ctx context.Context, id, digest string) (bool, error)
+	CancelTask(ctx context.Context, id string) error
+	AcquireBlob(ctx context.Context, digest, path string, size int64) (string, bool, error)
+	ReleaseBlob(ctx context.Context, digest string, deleteFiles func(path string)) (bool, error)
+	PurgeBlob(ctx context.Context, digest string, deleteFiles func(path string)) (bool, error)
+	GetHash(ctx context.Context, id string) (*models.ImageHash, error)
+	FindSimilar(ctx context.Context, excludeID string, phash uint64, maxDistance, limit int) ([]models.SimilarImage, error)
+	CreateBatch(ctx context.Context, batch *models.Batch) error
+	GetBatch(ctx context.Context, id string) (*models.Batch, error)
+	AddBatchRejections(ctx context.Context, batchID string, rejections []models.BatchRejection, now time.Time) error
+	ListTasks(ctx context.Context, filter models.ImageFilter) (*models.ImagePage, error)
+	UpdateMetadata(ctx context.Context, id string, patch models.MetadataPatch) (*models.Task, error)
+	CreateCollection(ctx context.Context, collection *models.Collection) error
+	GetCollection(ctx context.Context, id string) (*models.Collection, error)
+	RenameCollection(ctx context.Context, id, name string, now time.Time) (*models.Collection, error)
+	DeleteCollection(ctx context.Context, id string) error
+	AddToCollection(ctx context.Context, id string, imageIDs []string, now time.Time) error
+	RemoveFromCollection(ctx context.Context, id, imageID string, now time.Time) error
+	ReorderCollection(ctx context.Context, id string, imageIDs []string, now time.Time) error
+	CreateRun(ctx context.Context, run *models.ProcessingRun, command *models.ProcessingCommand) error
+	GetRun(ctx context.Context, id string) (*models.ProcessingRun, error)
+	ListRuns(ctx context.Context, imageID string) ([]*models.ProcessingRun, error)
 }
 
 type FileStorage interface {
 	Save(path string, image io.Reader) error
 	Delete(path string) error
+	Rename(from, to string) error
+	Exists(path string) (bool, error)
+	DeletePrefix(prefix string) error
+	Presign(path string, ttl time.Duration) (string, error)
+}
+
+type Hasher interface {
+	Hash(path string) (*models.ImageHash, error)
 }
 
-type Produce interface {
-	Publish(ctx context.Context, task *models.ProcessingCommand) error
+// Outbox отправляет сохраненные команды обработки в брокер.
+type Outbox interface {
+	// Notify сообщает о новой команде, чтобы она ушла без задержки.
+	Notify()
+}
+
+type Config struct {
+	// DuplicateDistance — максимальное расстояние Хэмминга pHash, при котором загрузка считается дубликатом.
+	DuplicateDistance int
+	// URLTTL — срок действия подписанных ссылок на файлы.
+	URLTTL time.Duration
+	// IdempotencyTTL — сколько повтор загрузки с тем же ключом возвращает прежнюю задачу.
+	IdempotencyTTL time.Duration
+	// Keys строит пути результатов так же, как воркер.
+	Keys models.OperationKeys
 }
 
 type ImageService struct {
 	repo    Repo
 	storage FileStorage
-	produce Produce
+	outbox  Outbox
+	hasher  Hasher
+	cfg     Config
 	log     *zap.Logger
 }
 
-func NewImageService(repo Repo, storage FileStorage, produce Produce, log *zap.Logger) *ImageService {
+func NewImageService(repo Repo, storage FileStorage, outbox Outbox, hasher Hasher, cfg Config, log *zap.Logger) *ImageService {
 	return &ImageService{
 		repo:    repo,
 		storage: storage,
-		produce: produce,
+		outbox:  outbox,
+		hasher:  hasher,
+		cfg:     cfg,
 		log:     log.Named("service"),
 	}
 }
 
-func (s *ImageService) UploadImage(ctx context.Context, image io.Reader, extension string) (string, error) {
+func (s *ImageService) UploadImage(ctx context.Context, image io.Reader, extension string, opts models.UploadOptions) (*models.UploadResult, error) {
+	// Метаданные проверяются до сохранения файла, чтобы не загружать его зря
+	if err := opts.Metadata.Validate(); err != nil {
+		return nil, err
+	}
+	if len(opts.Tags) > models.MaxTags {
+		return nil, fmt.Errorf("%w: at most %d tags are allowed", models.ErrInvalidMetadata, models.MaxTags)
+	}
+
+	// Повтор запроса с тем же ключом не сохраняет файл и не создает вторую задачу;
+	// файл только хешируется, чтобы сверить запрос с первым
+	var key *models.IdempotencyKey
+	if opts.IdempotencyKey != "" {
+		if err := checkIdempotencyKey(opts.IdempotencyKey); err != nil {
+			return nil, err
+		}
+		fingerprint := func() (string, error) {
+			hasher := sha256.New()
+			if _, err := io.Copy(hasher, image); err != nil {
+				return "", fmt.Errorf("failed to read image: %w", err)
+			}
+			return uploadFingerprint(hex.EncodeToString(hasher.Sum(nil)), extension, opts), nil
+		}
+		if result, err := s.idempotentResult(ctx, opts.IdempotencyKey, fingerprint); result != nil || err != nil {
+			return result, err
+		}
+		key = &models.IdempotencyKey{Key: opts.IdempotencyKey, ExpiresAt: time.Now().Add(s.cfg.IdempotencyTTL)}
+	}
+
 	id := uuid.New().String()
-	imagePath := fmt.Sprintf(models.OriginalPath, id, extension)
 
-	err := s.storage.Save(imagePath, image)
+	digest, imagePath, err := s.saveBlob(ctx, image, extension)
 	if err != nil {
-		s.log.Error("failed to save image", zap.String("imagePath", imagePath), zap.Error(err))
-		return "", fmt.Errorf("failed to save image: %w", err)
+		return nil, err
+	}
+	if key != nil {
+		key.Fingerprint = uploadFingerprint(digest, extension, opts)
+	}
+
+	result := &models.UploadResult{TaskID: id}
+	if opts.DuplicatePolicy != models.DuplicateAllow {
+		duplicates, err := s.findDuplicates(ctx, imagePath)
+		if err != nil {
+			s.log.Warn("failed to check for duplicates, continuing upload", zap.String("imagePath", imagePath), zap.Error(err))
+		}
+		if len(duplicates) > 0 && opts.DuplicatePolicy == models.DuplicateReject {
+			s.log.Info("rejecting near-duplicate upload", zap.String("imagePath", imagePath), zap.String("duplicateOf", duplicates[0].ID))
+			s.releaseBlob(ctx, digest)
+			return &models.UploadResult{Duplicates: duplicates}, models.ErrDuplicateImage
+		}
+		result.Duplicates = duplicates
 	}
 
 	task := &models.Task{
 		ID:                  id,
 		Status:              models.StatusProcessing,
 		OriginalPath:        imagePath,
+		Digest:              digest,
 		RequestedOperations: models.RequestedOperations,
 		CreatedAt:           time.Now(),
+		BatchID:             opts.BatchID,
+		Format:              models.FormatFromExtension(extension),
+		Tags:                opts.Tags,
+		ImageMetadata:       opts.Metadata,
 	}
 
-	err = s.repo.CreateTask(ctx, task)
-	if err != nil {
-		s.log.Error("failed to create task, compensating by deleting file", zap.String("imagePath", imagePath), zap.Error(err))
-		if cleanupErr := s.storage.Delete(imagePath); cleanupErr != nil {
-			s.log.Error("failed to cleanup (delete) file after db error", zap.String("imagePath", imagePath), zap.NamedError("cleanup_error", cleanupErr))
-		}
-		return "", fmt.Errorf("failed to create task: %w", err)
-	}
 	processingMessage := &models.ProcessingCommand{
 		ID:                  task.ID,
 		OriginalPath:        task.OriginalPath,
 		RequestedOperations: task.RequestedOperations,
 		CreatedAt:           task.CreatedAt,
+		Priority:            opts.Priority,
+	}
+	// Команда сохраняется в outbox вместе с задачей и уходит в брокер отдельно,
+	// поэтому сбой брокера не оставляет задачу без обработки
+	err = s.repo.CreateTask(ctx, task, processingMessage, key)
+	if errors.Is(err, models.ErrIdempotencyKeyInUse) {
+		// Параллельный запрос с тем же ключом успел создать задачу первым
+		s.releaseBlob(ctx, digest)
+		fingerprint := func() (string, error) { return key.Fingerprint, nil }
+		if result, lookupErr := s.idempotentResult(ctx, opts.IdempotencyKey, fingerprint); result != nil || lookupErr != nil {
+			return result, lookupErr
+		}
+		return nil, err
+	}
+	if err != nil {
+		s.log.Error("failed to create task, compensating by releasing blob", zap.String("imagePath", imagePath), zap.Error(err))
+		s.releaseBlob(ctx, digest)
+		return nil, fmt.Errorf("failed to create task: %w", err)
+	}
+	s.outbox.Notify()
+	return result, nil
+}
+
+// idempotentResult возвращает задачу, уже созданную запросом с ключом key,
+// или nil, если такой задачи нет. fingerprint вызывается, только если ключ занят:
+// запрос с другим отпечатком получает ErrIdempotencyKeyReused.
+func (s *ImageService) idempotentResult(ctx context.Context, key string, fingerprint func() (string, error)) (*models.UploadResult, error) {
+	taskID, stored, err := s.repo.GetIdempotentTask(ctx, key, time.Now())
+	if err != nil {
+		if errors.Is(err, models.ErrTaskNotFound) {
+			return nil, nil
+		}
+		s.log.Error("failed to check idempotency key", zap.Error(err))
+		return nil, fmt.Errorf("failed to check idempotency key: %w", err)
+	}
+	// Ключи, сохраненные до появления отпечатков, не сверяются
+	if stored != "" {
+		current, err := fingerprint()
+		if err != nil {
+			return nil, err
+		}
+		if current != stored {
+			s.log.Info("Idempotency key reused with a different request", zap.String("id", taskID))
+			return nil, models.ErrIdempotencyKeyReused
+		}
+	}
+	s.log.Info("Repeated upload, returning existing task", zap.String("id", taskID))
+	return &models.UploadResult{TaskID: taskID, Replayed: true}, nil
+}
+
+// uploadFingerprint — отпечаток запроса загрузки: содержимое файла и все
+// параметры, влияющие на созданную задачу.
+func uploadFingerprint(digest, extension string, opts models.UploadOptions) string {
+	tags := slices.Sorted(slices.Values(opts.Tags))
+	labels := slices.Sorted(maps.Keys(opts.Metadata.Labels))
+	hasher := sha256.New()
+	fields := []string{digest, extension, string(opts.DuplicatePolicy), opts.BatchID, string(opts.Priority),
+		opts.Metadata.Title, opts.Metadata.Description, opts.Metadata.Alt, strconv.Itoa(len(tags))}
+	fields = append(fields, tags...)
+	for _, name := range labels {
+		fields = append(fields, name, opts.Metadata.Labels[name])
+	}
+	for _, field := range fields {
+		// Длина перед значением не дает разным наборам полей склеиться в одну строку
+		fmt.Fprintf(hasher, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func checkIdempotencyKey(key string) error {
+	if len(key) > models.MaxIdempotencyKeyLength {
+		return fmt.Errorf("%w: key must not exceed %d bytes", models.ErrInvalidIdempotencyKey, models.MaxIdempotencyKeyLength)
+	}
+	if strings.ContainsFunc(key, unicode.IsControl) {
+		return fmt.Errorf("%w: key must not contain control characters", models.ErrInvalidIdempotencyKey)
 	}
-	err = s.produce.Publish(ctx, processingMessage)
+	return nil
+}
+
+// RunCleanup периодически удаляет истекшие ключи идемпотентности, пока не отменен ctx.
+func (s *ImageService) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.repo.DeleteExpiredIdempotencyKeys(ctx, time.Now())
+			if err != nil {
+				s.log.Error("failed to delete expired idempotency keys", zap.Error(err))
+			} else if n > 0 {
+				s.log.Info("Deleted expired idempotency keys", zap.Int64("count", n))
+			}
+		}
+	}
+}
+
+// saveBlob сохраняет поток во временный файл, одновременно считая SHA-256,
+// и переносит его в content-addressed хранилище. Если такой blob уже есть,
+// временный файл удаляется, а на существующий blob добавляется ссылка.
+func (s *ImageService) saveBlob(ctx context.Context, image io.Reader, extension string) (string, string, error) {
+	tempPath := fmt.Sprintf(models.TempPath, uuid.New().String(), extension)
+	hasher := sha256.New()
+	counter := &countingReader{r: io.TeeReader(image, hasher)}
+
+	if err := s.storage.Save(tempPath, counter); err != nil {
+		s.log.Error("failed to save image", zap.String("tempPath", tempPath), zap.Error(err))
+		return "", "", fmt.Errorf("failed to save image: %w", err)
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	blobPath := fmt.Sprintf(models.BlobPath, digest[:2], digest, extension)
+
+	storedPath, created, err := s.repo.AcquireBlob(ctx, digest, blobPath, counter.n)
 	if err != nil {
-		s.log.Error("failed to publish task, compensating by deleting file and db record", zap.String("imagePath", imagePath), zap.Error(err))
+		s.log.Error("failed to acquire blob", zap.String("digest", digest), zap.Error(err))
+		if cleanupErr := s.storage.Delete(tempPath); cleanupErr != nil {
+			s.log.Error("failed to cleanup (delete) temp file", zap.String("tempPath", tempPath), zap.NamedError("cleanup_error", cleanupErr))
+		}
+		return "", "", fmt.Errorf("failed to acquire blob: %w", err)
+	}
 
-		if cleanupErr := s.storage.Delete(imagePath); cleanupErr != nil {
-			s.log.Error("failed to cleanup (delete) file after publish error", zap.String("imagePath", imagePath), zap.NamedError("cleanup_error", cleanupErr))
+	if !created {
+		s.log.Info("identical image already stored, reusing blob", zap.String("digest", digest), zap.String("path", storedPath))
+		// Первый загрузивший мог еще не перенести файл на место. Содержимое у нас
+		// то же самое, поэтому в этом случае переносим свою копию.
+		exists, err := s.storage.Exists(storedPath)
+		if err != nil {
+			s.log.Warn("failed to check blob file", zap.String("path", storedPath), zap.Error(err))
+		}
+		if !exists {
+			if err := s.storage.Rename(tempPath, storedPath); err != nil {
+				s.log.Error("failed to move image into blob storage", zap.String("tempPath", tempPath), zap.Error(err))
+				s.releaseBlob(ctx, digest)
+				return "", "", fmt.Errorf("failed to store blob: %w", err)
+			}
+			return digest, storedPath, nil
 		}
-		if cleanupErr := s.repo.DeleteTask(ctx, id); cleanupErr != nil {
-			s.log.Error("failed to cleanup (delete) db task after publish error", zap.String("id", id), zap.NamedError("cleanup_error", cleanupErr))
+		if err := s.storage.Delete(tempPath); err != nil {
+			s.log.Error("failed to delete temp file of duplicate upload", zap.String("tempPath", tempPath), zap.Error(err))
+		}
+		return digest, storedPath, nil
+	}
+
+	if err := s.storage.Rename(tempPath, storedPath); err != nil {
+		s.log.Error("failed to move image into blob storage", zap.String("tempPath", tempPath), zap.Error(err))
+		s.releaseBlob(ctx, digest)
+		if cleanupErr := s.storage.Delete(tempPath); cleanupErr != nil {
+			s.log.Error("failed to cleanup (delete) temp file", zap.String("tempPath", tempPath), zap.NamedError("cleanup_error", cleanupErr))
 		}
-		return "", fmt.Errorf("failed to publish task: %w", err)
+		return "", "", fmt.Errorf("failed to store blob: %w", err)
 	}
-	return id, nil
+	return digest, storedPath, nil
+}
+
+// releaseBlob снимает ссылку на blob и, если она была последней, удаляет
+// оригинал и все производные файлы для него.
+func (s *ImageService) releaseBlob(ctx context.Context, digest string) {
+	last, err := s.repo.ReleaseBlob(ctx, digest, s.deleteFiles)
+	if err != nil {
+		s.log.Error("failed to release blob", zap.String("digest", digest), zap.Error(err))
+		return
+	}
+	if !last {
+		s.log.Debug("blob is still referenced, keeping files", zap.String("digest", digest))
+	}
+}
+
+func (s *ImageService) deleteFiles(originalPath string) {
+	s.log.Info("Deleting original file", zap.String("path", originalPath))
+	if err := s.storage.Delete(originalPath); err != nil {
+		s.log.Error("failed to delete original file, continuing cleanup", zap.String("path", originalPath), zap.Error(err))
+	}
+
+	for _, operation := range models.RequestedOperations {
+		processedPath := s.cfg.Keys.DerivativePath(operation, originalPath)
+		s.log.Info("Deleting processed file", zap.String("path", processedPath))
+		if err := s.storage.Delete(processedPath); err != nil {
+			s.log.Error("failed to delete processed file", zap.String("path", processedPath), zap.Error(err))
+		}
+	}
+
+	// Результат водяного знака, построенный до того, как в ключ вошла версия знака
+	base := filepath.Base(originalPath)
+	if unversioned := fmt.Sprintf(models.ProcessPath, "watermark", base); unversioned != s.cfg.Keys.DerivativePath("watermark", originalPath) {
+		if err := s.storage.Delete(unversioned); err != nil {
+			s.log.Error("failed to delete processed file", zap.String("path", unversioned), zap.Error(err))
+		}
+	}
+
+	// Варианты, построенные на лету, лежат в каталоге по дайджесту (или ID для старых задач)
+	renditions := fmt.Sprintf(models.RenditionPath, strings.TrimSuffix(base, filepath.Ext(base)), "")
+	if err := s.storage.DeletePrefix(renditions); err != nil {
+		s.log.Error("failed to delete renditions", zap.String("prefix", renditions), zap.Error(err))
+	}
+}
+
+// deleteRunFiles удаляет результаты запусков с версиями: они принадлежат
+// только одному изображению, в отличие от общих производных.
+func (s *ImageService) deleteRunFiles(originalPath string, runs []*models.ProcessingRun) {
+	for _, run := range runs {
+		if run.Mode != models.ReprocessVersion {
+			continue
+		}
+		for _, operation := range run.Operations {
+			path := s.cfg.Keys.RunDerivativePath(operation, originalPath, run.ID)
+			if err := s.storage.Delete(path); err != nil {
+				s.log.Error("failed to delete run file", zap.String("path", path), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (s *ImageService) findDuplicates(ctx context.Context, imagePath string) ([]models.SimilarImage, error) {
+	hash, err := s.hasher.Hash(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash image: %w", err)
+	}
+	return s.repo.FindSimilar(ctx, "", hash.PHash, s.cfg.DuplicateDistance, similarLimit)
+}
+
+// FindSimilar возвращает задачи, pHash оригинала которых отличается не более чем на maxDistance бит.
+func (s *ImageService) FindSimilar(ctx context.Context, id string, maxDistance int) ([]models.SimilarImage, error) {
+	if err := uuid.Validate(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", models.ErrTaskNotFound, id)
+	}
+	hash, err := s.repo.GetHash(ctx, id)
+	if err != nil {
+		if errors.Is(err, models.ErrTaskNotFound) || errors.Is(err, models.ErrHashNotReady) {
+			return nil, err
+		}
+		s.log.Error("failed to get image hash", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to get image hash: %w", err)
+	}
+	similar, err := s.repo.FindSimilar(ctx, id, hash.PHash, maxDistance, similarLimit)
+	if err != nil {
+		s.log.Error("failed to find similar images", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to find similar images: %w", err)
+	}
+	return similar, nil
 }
 
 func (s *ImageService) GetImage(ctx context.Context, id string) (*models.Task, error) {
@@ -96,9 +421,124 @@ func (s *ImageService) GetImage(ctx context.Context, id string) (*models.Task, e
 		s.log.Error("failed to get task", zap.String("id", id), zap.Error(err))
 		return nil, fmt.Errorf("failed to get task: %w", err)
 	}
+	task.URLs = s.signedURLs(task)
 	return task, nil
 }
 
+// ListImages возвращает страницу изображений по фильтру вместе с подписанными ссылками.
+func (s *ImageService) ListImages(ctx context.Context, filter models.ImageFilter) (*models.ImagePage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = models.DefaultPageLimit
+	}
+	filter.Limit = min(filter.Limit, models.MaxPageLimit)
+
+	page, err := s.repo.ListTasks(ctx, filter)
+	if err != nil {
+		s.log.Error("failed to list tasks", zap.Error(err))
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	for _, task := range page.Items {
+		task.URLs = s.signedURLs(task)
+	}
+	return page, nil
+}
+
+// UpdateMetadata изменяет название, описание, alt-текст, метки и теги изображения.
+func (s *ImageService) UpdateMetadata(ctx context.Context, id string, patch models.MetadataPatch) (*models.Task, error) {
+	if err := patch.Validate(); err != nil {
+		return nil, err
+	}
+	if patch.Tags != nil {
+		tags := models.NormalizeTags(*patch.Tags)
+		patch.Tags = &tags
+	}
+
+	task, err := s.repo.UpdateMetadata(ctx, id, patch)
+	if err != nil {
+		s.log.Error("failed to update metadata", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	task.URLs = s.signedURLs(task)
+	return task, nil
+}
+
+// GetDerivative возвращает путь к файлу операции и ETag для его отдачи.
+// Пока задача обрабатывается, возвращается models.ErrDerivativeNotReady.
+func (s *ImageService) GetDerivative(ctx context.Context, id, operation string) (*models.Derivative, error) {
+	task, err := s.repo.GetTask(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	contentID := task.Digest
+	if contentID == "" {
+		contentID = task.ID
+	}
+	if operation == models.OriginalURLKey {
+		return &models.Derivative{
+			Path: task.OriginalPath,
+			ETag: fmt.Sprintf(`"%s"`, contentID),
+		}, nil
+	}
+	if !slices.Contains(models.RequestedOperations, operation) {
+		return nil, fmt.Errorf("%w: %s", models.ErrUnknownOperation, operation)
+	}
+
+	path := s.cfg.Keys.OperationPath(task, operation)
+	if task.Status != models.StatusComplete {
+		// Результат мог остаться от другой задачи с тем же содержимым
+		exists, err := s.storage.Exists(path)
+		if err != nil || !exists {
+			if task.Status == models.StatusProcessing {
+				return nil, models.ErrDerivativeNotReady
+			}
+			return nil, fmt.Errorf("%w: %s of task %s", models.ErrDerivativeMissing, operation, id)
+		}
+	}
+	etag := fmt.Sprintf(`"%s-%s"`, contentID, s.cfg.Keys.Key(operation))
+	if run := task.DerivativeRuns[operation]; run != "" {
+		etag = fmt.Sprintf(`"%s-%s-%s"`, contentID, s.cfg.Keys.Key(operation), run)
+	}
+	return &models.Derivative{Path: path, ETag: etag}, nil
+}
+
+// signedURLs выдает ссылки с ограниченным сроком действия на оригинал и,
+// если обработка завершена, на все производные файлы.
+func (s *ImageService) signedURLs(task *models.Task) map[string]string {
+	paths := map[string]string{models.OriginalURLKey: task.OriginalPath}
+	if task.Status == models.StatusComplete {
+		for _, operation := range models.RequestedOperations {
+			paths[operation] = s.cfg.Keys.OperationPath(task, operation)
+		}
+	}
+
+	urls := make(map[string]string, len(paths))
+	for name, path := range paths {
+		url, err := s.storage.Presign(path, s.cfg.URLTTL)
+		if err != nil {
+			s.log.Warn("failed to sign url", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		urls[name] = url
+	}
+	return urls
+}
+
+// CancelImage останавливает обработку изображения. Воркер проверяет статус между
+// операциями и перед публикацией результатов, поэтому уже начатая операция
+// доделывается, но ее результат отбрасывается.
+func (s *ImageService) CancelImage(ctx context.Context, id string) error {
+	if err := uuid.Validate(id); err != nil {
+		return fmt.Errorf("%w: %s", models.ErrTaskNotFound, id)
+	}
+	if err := s.repo.CancelTask(ctx, id); err != nil {
+		s.log.Warn("failed to cancel task", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to cancel task: %w", err)
+	}
+	s.log.Info("Task cancelled", zap.String("id", id))
+	return nil
+}
+
 func (s *ImageService) DeleteImage(ctx context.Context, id string) error {
 
 	task, err := s.repo.GetTask(ctx, id)
@@ -107,26 +547,85 @@ func (s *ImageService) DeleteImage(ctx context.Context, id string) error {
 		return nil
 	}
 
-	s.log.Info("Deleting original file", zap.String("path", task.OriginalPath))
-	if err := s.storage.Delete(task.OriginalPath); err != nil {
-		s.log.Error("failed to delete original file, continuing cleanup", zap.String("path", task.OriginalPath), zap.Error(err))
+	// Запуски удаляются вместе с задачей, поэтому их список нужен заранее
+	runs, err := s.repo.ListRuns(ctx, id)
+	if err != nil {
+		s.log.Warn("failed to list processing runs, their files will be kept", zap.String("id", id), zap.Error(err))
 	}
 
-	baseFilename := filepath.Base(task.OriginalPath)
-	for _, operation := range models.RequestedOperations {
-		processedPath := fmt.Sprintf(models.ProcessPath, operation, baseFilename)
-		s.log.Info("Deleting processed file", zap.String("path", processedPath))
-		if err := s.storage.Delete(processedPath); err != nil {
-			s.log.Error("failed to delete processed file", zap.String("path", processedPath), zap.Error(err))
+	// Ссылка на blob снимается в одной транзакции с удалением строки, а файлы
+	// удаляются только после ее фиксации
+	last, err := s.repo.DeleteTask(ctx, id, task.Digest)
+	if err != nil {
+		s.log.Error("failed to delete task from db", zap.String("id", id), zap.Error(err))
+		return fmt.Errorf("failed to delete task record: %w", err)
+	}
+	s.deleteRunFiles(task.OriginalPath, runs)
+
+	switch {
+	// Задачи, созданные до content-addressed хранилища, владеют своими файлами единолично
+	case task.Digest == "":
+		s.deleteFiles(task.OriginalPath)
+	case last:
+		if _, err := s.repo.PurgeBlob(ctx, task.Digest, s.deleteFiles); err != nil {
+			s.log.Error("failed to purge blob", zap.String("digest", task.Digest), zap.Error(err))
 		}
+	default:
+		s.log.Debug("blob is still referenced, keeping files", zap.String("digest", task.Digest))
+	}
+
+	s.log.Info("Successfully deleted task and released associated files", zap.String("id", id))
+	return nil
+}
+
+// CreateBatch создает пакет, к которому затем привязываются загружаемые файлы.
+func (s *ImageService) CreateBatch(ctx context.Context) (string, error) {
+	batch := &models.Batch{ID: uuid.New().String(), CreatedAt: time.Now()}
+	if err := s.repo.CreateBatch(ctx, batch); err != nil {
+		s.log.Error("failed to create batch", zap.Error(err))
+		return "", fmt.Errorf("failed to create batch: %w", err)
 	}
+	return batch.ID, nil
+}
 
-	err = s.repo.DeleteTask(ctx, id)
+func (s *ImageService) GetBatch(ctx context.Context, id string) (*models.Batch, error) {
+	if err := uuid.Validate(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", models.ErrBatchNotFound, id)
+	}
+	batch, err := s.repo.GetBatch(ctx, id)
 	if err != nil {
-		s.log.Error("failed to delete task from db after cleaning files", zap.String("id", id), zap.Error(err))
-		return fmt.Errorf("failed to delete task record: %w", err)
+		s.log.Error("failed to get batch", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to get batch: %w", err)
 	}
+	return batch, nil
+}
 
-	s.log.Info("Successfully deleted task and associated files", zap.String("id", id))
+// RecordBatchRejections сохраняет файлы пакета, для которых не удалось создать
+// задачу, чтобы прогресс пакета учитывал все его файлы.
+func (s *ImageService) RecordBatchRejections(ctx context.Context, batchID string, items []models.BatchItem) error {
+	rejections := make([]models.BatchRejection, 0)
+	for _, item := range items {
+		if item.Error != "" {
+			rejections = append(rejections, models.BatchRejection{Filename: item.Filename, Error: item.Error})
+		}
+	}
+	if len(rejections) == 0 {
+		return nil
+	}
+	if err := s.repo.AddBatchRejections(ctx, batchID, rejections, time.Now()); err != nil {
+		s.log.Error("failed to record batch rejections", zap.String("batch", batchID), zap.Error(err))
+		return fmt.Errorf("failed to record batch rejections: %w", err)
+	}
 	return nil
 }
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
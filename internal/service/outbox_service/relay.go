@@ -0,0 +1,159 @@
+package outbox_service
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// cleanupInterval — как часто удаляются давно отправленные записи.
+	cleanupInterval = time.Hour
+
+	defaultInterval     = time.Second
+	defaultBatchSize    = 100
+	defaultMaxAttempts  = 10
+	defaultClaimTimeout = time.Minute
+)
+
+type Repo interface {
+	ClaimOutbox(ctx context.Context, limit int, now, until time.Time) ([]*models.OutboxEntry, error)
+	MarkOutboxSent(ctx context.Context, ids []int64, now time.Time) error
+	FailOutbox(ctx context.Context, id int64, reason string, now, retryAt time.Time, maxAttempts int) (bool, error)
+	DeleteSentOutbox(ctx context.Context, before time.Time) (int64, error)
+}
+
+type Produce interface {
+	Publish(ctx context.Context, task *models.ProcessingCommand) error
+}
+
+type Config struct {
+	// Interval — как часто проверять outbox, если сигналов о новых записях не было.
+	Interval time.Duration
+	// BatchSize ограничивает число записей, блокируемых за один проход.
+	BatchSize int
+	// Retention — сколько хранить отправленные записи.
+	Retention time.Duration
+	// MaxAttempts — после стольких неудачных отправок запись откладывается и
+	// больше не отправляется.
+	MaxAttempts int
+	// ClaimTimeout — через сколько забранные, но не отмеченные записи снова
+	// становятся доступны, например если процесс упал во время отправки.
+	ClaimTimeout time.Duration
+}
+
+// Relay отправляет команды из outbox в брокер. Запись отмечается отправленной
+// только после успешной отправки, поэтому команда доставляется хотя бы один раз,
+// а повторную доставку воркер обрабатывает без вреда.
+type Relay struct {
+	repo    Repo
+	produce Produce
+	cfg     Config
+	// wake будит цикл сразу после записи новой команды
+	wake chan struct{}
+	log  *zap.Logger
+}
+
+func NewRelay(repo Repo, produce Produce, cfg Config, log *zap.Logger) *Relay {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.ClaimTimeout <= 0 {
+		cfg.ClaimTimeout = defaultClaimTimeout
+	}
+	return &Relay{
+		repo:    repo,
+		produce: produce,
+		cfg:     cfg,
+		wake:    make(chan struct{}, 1),
+		log:     log.Named("outbox_relay"),
+	}
+}
+
+// Notify сообщает о новой записи, чтобы не ждать следующего интервала.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run отправляет записи до отмены ctx.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(cleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		r.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		case <-cleanup.C:
+			if n, err := r.repo.DeleteSentOutbox(ctx, time.Now().Add(-r.cfg.Retention)); err != nil {
+				r.log.Error("Failed to clean up outbox", zap.Error(err))
+			} else if n > 0 {
+				r.log.Info("Cleaned up outbox", zap.Int64("deleted", n))
+			}
+		}
+	}
+}
+
+// drain отправляет записи пачками, пока outbox не опустеет. Запись, которую не
+// удалось отправить, пропускается до следующего интервала, чтобы не задерживать остальные.
+func (r *Relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		entries, err := r.repo.ClaimOutbox(ctx, r.cfg.BatchSize, now, now.Add(r.cfg.ClaimTimeout))
+		if err != nil {
+			r.log.Warn("Failed to claim outbox entries", zap.Error(err))
+			return
+		}
+
+		sent := make([]int64, 0, len(entries))
+		for _, entry := range entries {
+			if err := r.produce.Publish(ctx, entry.Command); err != nil {
+				r.fail(ctx, entry, err)
+				continue
+			}
+			sent = append(sent, entry.ID)
+		}
+		if len(sent) > 0 {
+			// Если отметка не удалась, записи отправятся повторно после ClaimTimeout;
+			// воркер обрабатывает повторную доставку без вреда
+			if err := r.repo.MarkOutboxSent(ctx, sent, time.Now()); err != nil {
+				r.log.Warn("Failed to mark outbox entries sent", zap.Int("sent", len(sent)), zap.Error(err))
+				return
+			}
+			r.log.Debug("Relayed outbox entries", zap.Int("sent", len(sent)))
+		}
+		if len(entries) < r.cfg.BatchSize {
+			return
+		}
+	}
+}
+
+func (r *Relay) fail(ctx context.Context, entry *models.OutboxEntry, publishErr error) {
+	now := time.Now()
+	parked, err := r.repo.FailOutbox(ctx, entry.ID, publishErr.Error(), now, now.Add(r.cfg.Interval), r.cfg.MaxAttempts)
+	switch {
+	case err != nil:
+		r.log.Warn("Failed to relay outbox entry", zap.Int64("id", entry.ID), zap.Error(publishErr))
+	case parked:
+		r.log.Error("Outbox entry parked after too many attempts", zap.Int64("id", entry.ID),
+			zap.String("task", entry.Command.ID), zap.Int("attempts", entry.Attempts+1), zap.Error(publishErr))
+	default:
+		r.log.Warn("Failed to relay outbox entry, will retry", zap.Int64("id", entry.ID),
+			zap.Int("attempts", entry.Attempts+1), zap.Error(publishErr))
+	}
+}
@@ -0,0 +1,123 @@
+package reaper_service
+
+import (
+	"ImageProcessor/internal/models"
+	"context"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval        = time.Minute
+	defaultBatchSize       = 100
+	defaultUnleasedTimeout = 10 * time.Minute
+)
+
+type Repo interface {
+	ReclaimExpiredLeases(ctx context.Context, now time.Time, unleasedTimeout time.Duration, limit, maxAttempts int) (*models.ReclaimResult, error)
+}
+
+// Outbox отправляет поставленные заново команды в брокер.
+type Outbox interface {
+	Notify()
+}
+
+type Config struct {
+	// Interval — как часто искать задачи с истекшей арендой.
+	Interval time.Duration
+	// MaxAttempts — после стольких попыток задача считается неудачной. Попыткой
+	// считается взятая аренда и повторная постановка задачи, которую не взял воркер.
+	MaxAttempts int
+	// BatchSize ограничивает число задач, забираемых за один проход.
+	BatchSize int
+	// UnleasedTimeout — сколько задача может ждать в очереди, пока воркер не
+	// возьмет аренду; после этого она ставится в очередь заново.
+	UnleasedTimeout time.Duration
+}
+
+// Reaper подбирает задачи, чей воркер перестал продлевать аренду, и ставит их
+// в очередь заново или отмечает неудачными.
+type Reaper struct {
+	repo   Repo
+	outbox Outbox
+	cfg    Config
+	log    *zap.Logger
+
+	mu    sync.Mutex
+	stats models.ReaperStats
+}
+
+func NewReaper(repo Repo, outbox Outbox, cfg Config, log *zap.Logger) *Reaper {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.UnleasedTimeout <= 0 {
+		cfg.UnleasedTimeout = defaultUnleasedTimeout
+	}
+	return &Reaper{
+		repo:   repo,
+		outbox: outbox,
+		cfg:    cfg,
+		log:    log.Named("reaper"),
+	}
+}
+
+// Run проверяет аренды до отмены ctx.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reap(ctx)
+		}
+	}
+}
+
+// Stats возвращает число задач, подобранных этим процессом с момента запуска.
+// Счетчики хранятся в памяти, поэтому каждая реплика отдает свои.
+func (r *Reaper) Stats() models.ReaperStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	if stats.LastRunAt != nil {
+		lastRunAt := *stats.LastRunAt
+		stats.LastRunAt = &lastRunAt
+	}
+	return stats
+}
+
+// reap забирает задачи пачками, пока они не кончатся.
+func (r *Reaper) reap(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		result, err := r.repo.ReclaimExpiredLeases(ctx, now, r.cfg.UnleasedTimeout, r.cfg.BatchSize, r.cfg.MaxAttempts)
+		if err != nil {
+			r.log.Error("Failed to reclaim expired leases", zap.Error(err))
+			return
+		}
+
+		r.mu.Lock()
+		r.stats.Requeued += int64(len(result.Requeued))
+		r.stats.Failed += int64(len(result.Failed))
+		r.stats.LastRunAt = &now
+		r.mu.Unlock()
+
+		if len(result.Requeued) > 0 {
+			r.log.Warn("Requeued tasks with expired leases", zap.Strings("ids", result.Requeued))
+			r.outbox.Notify()
+		}
+		if len(result.Failed) > 0 {
+			r.log.Warn("Tasks exhausted processing attempts", zap.Strings("ids", result.Failed))
+		}
+		if len(result.Requeued)+len(result.Failed) < r.cfg.BatchSize {
+			return
+		}
+	}
+}
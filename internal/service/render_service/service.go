@@ -0,0 +1,256 @@
+package render_service
+
+import (
+	"ImageProcessor/internal/iiif"
+	"ImageProcessor/internal/models"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+type Repo interface {
+	GetTask(ctx context.Context, id string) (*models.Task, error)
+}
+
+type FileStorage interface {
+	Exists(path string) (bool, error)
+	Delete(path string) error
+	ImageSize(path string) (int, int, error)
+}
+
+type Modifier interface {
+	Render(sourcePath, targetPath string, opts models.RenderOptions) error
+	Transform(sourcePath, targetPath string, plan models.TransformPlan) error
+}
+
+type Config struct {
+	// MaxDimension ограничивает размеры даже для подписанных запросов.
+	MaxDimension   uint
+	AllowedWidths  []uint
+	AllowedHeights []uint
+	// CaptionTemplate строит текст водяного знака из задачи; nil отключает водяные знаки.
+	CaptionTemplate *template.Template
+}
+
+const maxCaptionLength = 200
+
+// RenderService строит варианты изображения по запросу и кэширует их в хранилище.
+type RenderService struct {
+	repo     Repo
+	storage  FileStorage
+	modifier Modifier
+	cfg      Config
+	// group объединяет одновременные запросы одного и того же варианта
+	group singleflight.Group
+	log   *zap.Logger
+}
+
+func NewRenderService(repo Repo, storage FileStorage, modifier Modifier, cfg Config, log *zap.Logger) *RenderService {
+	return &RenderService{
+		repo:     repo,
+		storage:  storage,
+		modifier: modifier,
+		cfg:      cfg,
+		log:      log.Named("render"),
+	}
+}
+
+// Render возвращает закэшированный вариант изображения, при необходимости создавая его.
+// Непроверенные (неподписанные) запросы ограничены списком разрешенных размеров.
+func (s *RenderService) Render(ctx context.Context, id string, opts models.RenderOptions, trusted bool) (*models.Derivative, error) {
+	task, err := s.repo.GetTask(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	opts, err = s.normalize(opts, models.FormatFromExtension(filepath.Ext(task.OriginalPath)), trusted)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Caption {
+		if opts.CaptionText, err = s.captionText(task); err != nil {
+			return nil, err
+		}
+	}
+
+	path := fmt.Sprintf(models.RenditionPath, contentID(task), opts.Key())
+	err = s.renderOnce(path, func() error {
+		return s.modifier.Render(task.OriginalPath, path, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Derivative{
+		Path: path,
+		ETag: fmt.Sprintf(`"%s-%s"`, contentID(task), opts.Key()),
+	}, nil
+}
+
+// ImageInfo возвращает размеры оригинала и ограничения на размер результата для info.json.
+func (s *RenderService) ImageInfo(ctx context.Context, id string) (int, int, iiif.Limits, error) {
+	task, err := s.repo.GetTask(ctx, id)
+	if err != nil {
+		return 0, 0, iiif.Limits{}, fmt.Errorf("failed to get task: %w", err)
+	}
+	width, height, err := s.storage.ImageSize(task.OriginalPath)
+	if err != nil {
+		s.log.Error("Failed to read image size", zap.String("id", id), zap.Error(err))
+		return 0, 0, iiif.Limits{}, fmt.Errorf("failed to read image size: %w", err)
+	}
+	return width, height, s.iiifLimits(), nil
+}
+
+// RenderIIIF строит изображение по запросу IIIF Image API. В кэше вместе с остальными
+// вариантами сохраняются только подписанные запросы и запросы всего изображения
+// максимального или разрешенного размера: иначе любой клиент мог бы заполнить
+// хранилище произвольными областями и размерами. Остальные результаты временные.
+func (s *RenderService) RenderIIIF(ctx context.Context, id string, req *iiif.Request, trusted bool) (*models.Derivative, error) {
+	task, err := s.repo.GetTask(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+	width, height, err := s.storage.ImageSize(task.OriginalPath)
+	if err != nil {
+		s.log.Error("Failed to read image size", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to read image size: %w", err)
+	}
+
+	plan, err := req.Plan(width, height, s.iiifLimits())
+	if err != nil {
+		return nil, err
+	}
+
+	key := iiif.CacheKey(plan)
+	etag := fmt.Sprintf(`"%s-iiif-%s"`, contentID(task), key)
+	if !trusted && !s.persistable(req, plan) {
+		path := fmt.Sprintf(models.TempPath, uuid.New().String(), "."+models.FormatExtension(plan.Format))
+		if err := s.modifier.Transform(task.OriginalPath, path, plan); err != nil {
+			s.log.Error("Failed to render image", zap.String("path", path), zap.Error(err))
+			return nil, fmt.Errorf("failed to render image: %w", err)
+		}
+		return &models.Derivative{Path: path, ETag: etag, Temporary: true}, nil
+	}
+
+	path := fmt.Sprintf(models.RenditionPath, contentID(task), "iiif/"+key)
+	err = s.renderOnce(path, func() error {
+		return s.modifier.Transform(task.OriginalPath, path, plan)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.Derivative{Path: path, ETag: etag}, nil
+}
+
+// persistable ограничивает число сохраняемых вариантов одного изображения.
+func (s *RenderService) persistable(req *iiif.Request, plan models.TransformPlan) bool {
+	if !req.WholeImage() {
+		return false
+	}
+	return req.MaxSize() ||
+		slices.Contains(s.cfg.AllowedWidths, plan.Width) ||
+		slices.Contains(s.cfg.AllowedHeights, plan.Height)
+}
+
+// Discard удаляет временный результат после отдачи.
+func (s *RenderService) Discard(derivative *models.Derivative) {
+	if !derivative.Temporary {
+		return
+	}
+	if err := s.storage.Delete(derivative.Path); err != nil {
+		s.log.Warn("Failed to delete temporary rendition", zap.String("path", derivative.Path), zap.Error(err))
+	}
+}
+
+// renderOnce создает вариант, если его еще нет в кэше. Одновременные запросы
+// одного и того же варианта объединяются, и он вычисляется только один раз.
+func (s *RenderService) renderOnce(path string, render func() error) error {
+	_, err, shared := s.group.Do(path, func() (any, error) {
+		exists, err := s.storage.Exists(path)
+		if err != nil {
+			s.log.Warn("Failed to check rendition cache", zap.String("path", path), zap.Error(err))
+		}
+		if exists {
+			return nil, nil
+		}
+		s.log.Debug("Rendering image", zap.String("path", path))
+		if err := render(); err != nil {
+			s.log.Error("Failed to render image", zap.String("path", path), zap.Error(err))
+			return nil, fmt.Errorf("failed to render image: %w", err)
+		}
+		return nil, nil
+	})
+	if shared {
+		s.log.Debug("Rendition request coalesced", zap.String("path", path))
+	}
+	return err
+}
+
+// captionText подставляет метаданные задачи в шаблон водяного знака.
+func (s *RenderService) captionText(task *models.Task) (string, error) {
+	if s.cfg.CaptionTemplate == nil {
+		return "", fmt.Errorf("%w: captions are not configured", models.ErrInvalidRender)
+	}
+	var buf bytes.Buffer
+	if err := s.cfg.CaptionTemplate.Execute(&buf, task); err != nil {
+		s.log.Error("Failed to execute caption template", zap.String("id", task.ID), zap.Error(err))
+		return "", fmt.Errorf("failed to execute caption template: %w", err)
+	}
+	text := []rune(strings.Join(strings.Fields(buf.String()), " "))
+	if len(text) > maxCaptionLength {
+		text = append(text[:maxCaptionLength-1], '…')
+	}
+	return string(text), nil
+}
+
+func (s *RenderService) iiifLimits() iiif.Limits {
+	return iiif.Limits{MaxWidth: int(s.cfg.MaxDimension), MaxHeight: int(s.cfg.MaxDimension)}
+}
+
+func contentID(task *models.Task) string {
+	if task.Digest != "" {
+		return task.Digest
+	}
+	return task.ID
+}
+
+func (s *RenderService) normalize(opts models.RenderOptions, originalFormat string, trusted bool) (models.RenderOptions, error) {
+	if opts.Width == 0 && opts.Height == 0 {
+		return opts, fmt.Errorf("%w: width or height is required", models.ErrInvalidRender)
+	}
+	if opts.Width > s.cfg.MaxDimension || opts.Height > s.cfg.MaxDimension {
+		return opts, fmt.Errorf("%w: dimensions must not exceed %d", models.ErrInvalidRender, s.cfg.MaxDimension)
+	}
+
+	switch opts.Fit {
+	case "":
+		opts.Fit = models.FitContain
+	case models.FitContain, models.FitCover, models.FitFill:
+	default:
+		return opts, fmt.Errorf("%w: unknown fit %q", models.ErrInvalidRender, opts.Fit)
+	}
+
+	if opts.Format == "" {
+		opts.Format = originalFormat
+	}
+	if opts.Format == "" {
+		return opts, fmt.Errorf("%w: unknown output format", models.ErrInvalidRender)
+	}
+
+	if !trusted {
+		if opts.Width != 0 && !slices.Contains(s.cfg.AllowedWidths, opts.Width) {
+			return opts, fmt.Errorf("%w: width %d", models.ErrRenderNotAllowed, opts.Width)
+		}
+		if opts.Height != 0 && !slices.Contains(s.cfg.AllowedHeights, opts.Height) {
+			return opts, fmt.Errorf("%w: height %d", models.ErrRenderNotAllowed, opts.Height)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,354 @@
+package upload_service
+
+import (
+	storage "ImageProcessor/internal/file_storage"
+	"ImageProcessor/internal/models"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"io"
+	"path"
+	"strconv"
+	"time"
+)
+
+type Repo interface {
+	CreateUpload(ctx context.Context, upload *models.Upload) error
+	GetUpload(ctx context.Context, id string) (*models.Upload, error)
+	LockUpload(ctx context.Context, id string, offset int64, now, until time.Time) (*models.Upload, error)
+	CommitUpload(ctx context.Context, id string, from, to int64, taskID string) error
+	UnlockUpload(ctx context.Context, id string) error
+	DeleteUpload(ctx context.Context, id string) error
+	DeleteExpiredUploads(ctx context.Context, now time.Time) ([]string, error)
+}
+
+type FileStorage interface {
+	Save(path string, image io.Reader) error
+	Delete(path string) error
+	Open(path string) (io.ReadCloser, error)
+	List(prefix string) ([]storage.ObjectInfo, error)
+	DeletePrefix(prefix string) error
+}
+
+// ImageUploader запускает обработку полностью загруженного файла.
+type ImageUploader interface {
+	UploadImage(ctx context.Context, image io.Reader, extension string, opts models.UploadOptions) (*models.UploadResult, error)
+}
+
+type Config struct {
+	MaxSize int64
+	// Expiration — сколько живет незавершенная загрузка.
+	Expiration time.Duration
+	// LockTimeout ограничивает время записи одной части; после него загрузку
+	// может продолжить другой запрос.
+	LockTimeout time.Duration
+}
+
+// UploadService реализует возобновляемые загрузки: части файла пишутся в хранилище
+// отдельными объектами, а после получения последнего байта файл склеивается
+// и отправляется на обработку.
+type UploadService struct {
+	repo    Repo
+	storage FileStorage
+	images  ImageUploader
+	cfg     Config
+	log     *zap.Logger
+}
+
+func NewUploadService(repo Repo, storage FileStorage, images ImageUploader, cfg Config, log *zap.Logger) *UploadService {
+	return &UploadService{
+		repo:    repo,
+		storage: storage,
+		images:  images,
+		cfg:     cfg,
+		log:     log.Named("upload"),
+	}
+}
+
+func (s *UploadService) MaxSize() int64 {
+	return s.cfg.MaxSize
+}
+
+// Create регистрирует новую загрузку размером length байт.
+func (s *UploadService) Create(ctx context.Context, length int64, extension, metadata string) (*models.Upload, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("%w: length must be positive", models.ErrInvalidUpload)
+	}
+	if length > s.cfg.MaxSize {
+		return nil, fmt.Errorf("%w: %d bytes, max %d", models.ErrUploadTooLarge, length, s.cfg.MaxSize)
+	}
+	if !models.AllowedExtensions[extension] {
+		return nil, fmt.Errorf("%w: extension %q is not allowed", models.ErrInvalidUpload, extension)
+	}
+
+	now := time.Now()
+	upload := &models.Upload{
+		ID:        uuid.New().String(),
+		Length:    length,
+		Metadata:  metadata,
+		Extension: extension,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.cfg.Expiration),
+	}
+	if err := s.repo.CreateUpload(ctx, upload); err != nil {
+		s.log.Error("failed to create upload", zap.Error(err))
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	return upload, nil
+}
+
+func (s *UploadService) Get(ctx context.Context, id string) (*models.Upload, error) {
+	upload, err := s.repo.GetUpload(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload: %w", err)
+	}
+	if !upload.Completed() && time.Now().After(upload.ExpiresAt) {
+		return nil, models.ErrUploadExpired
+	}
+	return upload, nil
+}
+
+// Append дописывает часть файла, начиная со смещения offset. Когда получен
+// последний байт, файл отправляется на обработку и в загрузке появляется TaskID.
+// Повторный вызов для завершенной, но не отправленной загрузки повторяет отправку.
+func (s *UploadService) Append(ctx context.Context, id string, offset int64, body io.Reader) (*models.Upload, error) {
+	now := time.Now()
+	upload, err := s.repo.LockUpload(ctx, id, offset, now, now.Add(s.cfg.LockTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock upload: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			// Контекст запроса мог уже закончиться, а блокировку нужно снять в любом случае
+			if err := s.repo.UnlockUpload(context.Background(), id); err != nil {
+				s.log.Error("failed to unlock upload", zap.String("id", id), zap.Error(err))
+			}
+		}
+	}()
+
+	if !upload.Completed() && now.After(upload.ExpiresAt) {
+		return nil, models.ErrUploadExpired
+	}
+
+	// Если запрос оборвался, полученные байты все равно фиксируются, чтобы клиент
+	// продолжил с нового смещения, а не отправлял файл заново
+	written, chunkErr := s.writeChunk(upload, body)
+	if chunkErr != nil && written == 0 {
+		return nil, chunkErr
+	}
+	upload.Offset += written
+
+	var finishErr error
+	if upload.Completed() && upload.TaskID == "" {
+		upload.TaskID, finishErr = s.finish(ctx, upload)
+	}
+
+	// Полученные байты фиксируются даже при ошибке отправки: ее можно повторить пустым PATCH
+	if err := s.repo.CommitUpload(ctx, id, offset, upload.Offset, upload.TaskID); err != nil {
+		s.log.Error("failed to commit upload", zap.String("id", id), zap.Error(err))
+		return nil, fmt.Errorf("failed to commit upload: %w", err)
+	}
+	committed = true
+
+	if chunkErr != nil {
+		return nil, chunkErr
+	}
+	if finishErr != nil {
+		return nil, finishErr
+	}
+	return upload, nil
+}
+
+// writeChunk сохраняет часть отдельным объектом и возвращает число записанных байт.
+// При обрыве тела сохраняется то, что успело прийти, и вместе с ошибкой
+// возвращается число сохраненных байт.
+func (s *UploadService) writeChunk(upload *models.Upload, body io.Reader) (int64, error) {
+	remaining := upload.Length - upload.Offset
+	if remaining == 0 {
+		return 0, nil
+	}
+
+	key := fmt.Sprintf(models.UploadChunkPath, upload.ID, upload.Offset)
+	counter := &countingReader{r: io.LimitReader(body, remaining)}
+	if err := s.storage.Save(key, counter); err != nil {
+		s.log.Error("failed to save upload chunk", zap.String("key", key), zap.Error(err))
+		return 0, fmt.Errorf("failed to save upload chunk: %w", err)
+	}
+	if counter.err != nil {
+		s.log.Warn("Upload chunk interrupted", zap.String("key", key), zap.Int64("received", counter.n), zap.Error(counter.err))
+		if counter.n == 0 {
+			if err := s.storage.Delete(key); err != nil {
+				s.log.Error("failed to delete empty chunk", zap.String("key", key), zap.Error(err))
+			}
+		}
+		return counter.n, fmt.Errorf("failed to read upload chunk: %w", counter.err)
+	}
+
+	// Клиент прислал больше, чем объявил в Upload-Length
+	var extra [1]byte
+	if n, _ := io.ReadFull(body, extra[:]); n > 0 {
+		if err := s.storage.Delete(key); err != nil {
+			s.log.Error("failed to delete oversized chunk", zap.String("key", key), zap.Error(err))
+		}
+		return 0, fmt.Errorf("%w: more than %d bytes", models.ErrUploadTooLarge, upload.Length)
+	}
+	return counter.n, nil
+}
+
+// finish склеивает части в один поток, отправляет его на обработку и удаляет части.
+func (s *UploadService) finish(ctx context.Context, upload *models.Upload) (string, error) {
+	prefix := fmt.Sprintf(models.UploadChunksPath, upload.ID)
+	keys, err := s.chunkKeys(prefix, upload.Length)
+	if err != nil {
+		s.log.Error("failed to assemble upload", zap.String("id", upload.ID), zap.Error(err))
+		return "", err
+	}
+
+	reader := &chunkReader{storage: s.storage, keys: keys}
+	defer reader.Close()
+
+	result, err := s.images.UploadImage(ctx, reader, upload.Extension, models.UploadOptions{})
+	if err != nil {
+		s.log.Error("failed to start processing of upload", zap.String("id", upload.ID), zap.Error(err))
+		return "", fmt.Errorf("failed to upload image: %w", err)
+	}
+
+	if err := s.storage.DeletePrefix(prefix); err != nil {
+		s.log.Error("failed to delete upload chunks", zap.String("id", upload.ID), zap.Error(err))
+	}
+	s.log.Info("Upload completed", zap.String("id", upload.ID), zap.String("taskID", result.TaskID))
+	return result.TaskID, nil
+}
+
+// chunkKeys возвращает ключи частей по порядку и проверяет, что они покрывают файл без пропусков.
+func (s *UploadService) chunkKeys(prefix string, length int64) ([]string, error) {
+	objects, err := s.storage.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list upload chunks: %w", err)
+	}
+
+	chunks := make(map[int64]storage.ObjectInfo, len(objects))
+	for _, object := range objects {
+		offset, err := strconv.ParseInt(path.Base(object.Key), 10, 64)
+		if err != nil {
+			continue
+		}
+		chunks[offset] = object
+	}
+
+	keys := make([]string, 0, len(chunks))
+	for offset := int64(0); offset < length; {
+		chunk, ok := chunks[offset]
+		if !ok || chunk.Size == 0 {
+			return nil, fmt.Errorf("upload chunk at offset %d is missing", offset)
+		}
+		keys = append(keys, chunk.Key)
+		offset += chunk.Size
+	}
+	return keys, nil
+}
+
+// Terminate удаляет загрузку и все ее части.
+func (s *UploadService) Terminate(ctx context.Context, id string) error {
+	if _, err := s.repo.GetUpload(ctx, id); err != nil {
+		return fmt.Errorf("failed to get upload: %w", err)
+	}
+	if err := s.repo.DeleteUpload(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	if err := s.storage.DeletePrefix(fmt.Sprintf(models.UploadChunksPath, id)); err != nil {
+		s.log.Error("failed to delete upload chunks", zap.String("id", id), zap.Error(err))
+	}
+	return nil
+}
+
+// RunCleanup периодически удаляет просроченные загрузки, пока не отменен ctx.
+func (s *UploadService) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.cleanup(ctx)
+		}
+	}
+}
+
+func (s *UploadService) cleanup(ctx context.Context) {
+	ids, err := s.repo.DeleteExpiredUploads(ctx, time.Now())
+	if err != nil {
+		s.log.Error("failed to delete expired uploads", zap.Error(err))
+		return
+	}
+	for _, id := range ids {
+		if err := s.storage.DeletePrefix(fmt.Sprintf(models.UploadChunksPath, id)); err != nil {
+			s.log.Error("failed to delete expired upload chunks", zap.String("id", id), zap.Error(err))
+		}
+	}
+	if len(ids) > 0 {
+		s.log.Info("Deleted expired uploads", zap.Int("count", len(ids)))
+	}
+}
+
+// chunkReader последовательно читает части загрузки, открывая их по мере надобности.
+type chunkReader struct {
+	storage FileStorage
+	keys    []string
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			file, err := r.storage.Open(r.keys[0])
+			if err != nil {
+				return 0, fmt.Errorf("failed to open upload chunk %s: %w", r.keys[0], err)
+			}
+			r.current = file
+			r.keys = r.keys[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+// countingReader считает прочитанные байты. Ошибку чтения он запоминает и
+// отдает вместо нее io.EOF, чтобы хранилище сохранило уже полученную часть.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+		return n, io.EOF
+	}
+	return n, err
+}
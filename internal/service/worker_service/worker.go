@@ -1,14 +1,17 @@
 package worker_service
 
 import (
+	storage "ImageProcessor/internal/file_storage"
 	"ImageProcessor/internal/models"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	kafkaGo "github.com/segmentio/kafka-go"
+	"github.com/wb-go/wbf/retry"
 	"go.uber.org/zap"
 	"path/filepath"
+	"time"
 )
 
 type Consume interface {
@@ -23,33 +26,87 @@ type Modifier interface {
 	Watermark(sourcePath, targetPath string) error
 }
 
+type Storage interface {
+	Exists(path string) (bool, error)
+	Delete(path string) error
+	Rename(from, to string) error
+	ImageSize(path string) (int, int, error)
+}
+
+type Hasher interface {
+	Hash(path string) (*models.ImageHash, error)
+}
+
 type Repo interface {
 	UpdateStatus(ctx context.Context, id string, status models.TaskStatus) error
+	GetStatus(ctx context.Context, id string) (models.TaskStatus, error)
+	AcquireLease(ctx context.Context, id string, until time.Time) error
+	RenewLease(ctx context.Context, id string, until time.Time) error
+	CompletedOperations(ctx context.Context, commandID string) ([]string, error)
+	CompleteOperation(ctx context.Context, commandID, imageID, operation string, now time.Time) error
+	UpdateHashes(ctx context.Context, id string, hash *models.ImageHash) error
+	UpdateDimensions(ctx context.Context, id string, width, height int) error
+	CompleteRun(ctx context.Context, id, imageID string, outputs map[string]string, now time.Time) error
+	FailRun(ctx context.Context, id, reason string, now time.Time) error
+}
+
+// DeadLetters принимает сообщения, которые не удалось разобрать или обработать.
+type DeadLetters interface {
+	Send(ctx context.Context, msg kafkaGo.Message, cause error, attempts int) error
+}
+
+type Config struct {
+	// Retry задает повторы операции при временной ошибке.
+	Retry retry.Strategy
+	// LeaseDuration — срок аренды задачи; пока задача обрабатывается, аренда
+	// продлевается. Ноль отключает аренду.
+	LeaseDuration time.Duration
+	// ShutdownGrace — сколько после остановки воркера дорабатывается текущая задача.
+	ShutdownGrace time.Duration
+	// Keys строит пути результатов так же, как API.
+	Keys models.OperationKeys
 }
 
+// leaseRenewals — сколько раз аренда продлевается за свой срок, чтобы одна
+// неудачная попытка продления не отдала задачу сборщику.
+const leaseRenewals = 3
+
+// errCancelled означает, что задачу отменили или удалили во время обработки.
+var errCancelled = errors.New("task was cancelled")
+
 type Worker struct {
-	consume  Consume
-	modifier Modifier
-	repo     Repo
-	log      *zap.Logger
+	consume     Consume
+	modifier    Modifier
+	storage     Storage
+	hasher      Hasher
+	repo        Repo
+	deadLetters DeadLetters
+	cfg         Config
+	log         *zap.Logger
 }
 
-func NewWorker(consume Consume, modifier Modifier, repo Repo, log *zap.Logger) *Worker {
+func NewWorker(consume Consume, modifier Modifier, storage Storage, hasher Hasher, repo Repo, deadLetters DeadLetters, cfg Config, log *zap.Logger) *Worker {
 	return &Worker{
-		consume:  consume,
-		modifier: modifier,
-		repo:     repo,
-		log:      log.Named("worker"),
+		consume:     consume,
+		modifier:    modifier,
+		storage:     storage,
+		hasher:      hasher,
+		repo:        repo,
+		deadLetters: deadLetters,
+		cfg:         cfg,
+		log:         log.Named("worker"),
 	}
 }
 
+// Starting обрабатывает сообщения, пока не отменен ctx, и возвращается после
+// завершения или брошенной текущей задачи.
 func (w *Worker) Starting(ctx context.Context) error {
 	w.log.Info("Starting worker loop")
 	for {
 		select {
 		case <-ctx.Done():
 			w.log.Info("Worker shutting down due to context cancellation")
-			return ctx.Err()
+			return nil
 		default:
 			msg, err := w.consume.FetchMessage(ctx)
 			if err != nil {
@@ -60,46 +117,314 @@ func (w *Worker) Starting(ctx context.Context) error {
 				}
 				continue
 			}
+			w.handle(ctx, msg)
+		}
+	}
+}
+
+// handle обрабатывает одно сообщение и подтверждает его. После остановки воркера
+// задача дорабатывается не дольше ShutdownGrace, а затем бросается без
+// подтверждения: брокер доставит ее снова, и выполненные операции будут пропущены.
+func (w *Worker) handle(stop context.Context, msg kafkaGo.Message) {
+	ctx, abandon := context.WithCancel(context.WithoutCancel(stop))
+	defer abandon()
+	defer context.AfterFunc(stop, func() {
+		time.AfterFunc(w.cfg.ShutdownGrace, abandon)
+	})()
 
-			task, err := w.GetTask(msg)
+	task, err := w.GetTask(msg)
+	if err != nil {
+		// Повтор не исправит испорченное сообщение, поэтому оно сразу уходит в очередь недоставленных
+		w.log.Warn("Error reading message", zap.Error(err))
+		w.deadLetter(ctx, msg, err, 1)
+		_ = w.consume.CommitMessage(ctx, msg)
+		return
+	}
+	defer w.holdLease(ctx, task)()
+
+	attempts, processErr := w.process(ctx, task)
+	if ctx.Err() != nil {
+		w.log.Warn("Abandoning task on shutdown, it will be redelivered", zap.String("id", task.ID), zap.String("run", task.RunID))
+		return
+	}
+	if errors.Is(processErr, errCancelled) {
+		// Статус уже выставлен отменой, а у удаленной задачи его просто нет
+		w.log.Info("Task was cancelled, results discarded", zap.String("id", task.ID), zap.String("run", task.RunID))
+		w.discardRunOutputs(task)
+		_ = w.consume.CommitMessage(ctx, msg)
+		return
+	}
+
+	if processErr != nil {
+		w.deadLetter(ctx, msg, processErr, attempts)
+	}
+
+	if task.RunID != "" {
+		_ = w.consume.CommitMessage(ctx, msg)
+		w.finishRun(ctx, task, processErr)
+		return
+	}
+
+	w.storeHashes(ctx, task)
+	w.storeDimensions(ctx, task)
+
+	_ = w.consume.CommitMessage(ctx, msg)
+	status := models.StatusComplete
+	if processErr != nil {
+		status = models.StatusFailed
+	}
+	if err := w.repo.UpdateStatus(ctx, task.ID, status); err != nil {
+		w.log.Error("Error updating status", zap.Error(err))
+	}
+}
+
+// holdLease берет аренду задачи и продлевает ее, пока не вызвана возвращенная
+// функция. Если воркер упадет, аренда истечет и задачу подберет сборщик.
+// Повторная обработка аренду не берет: ее статус ведется в запуске, а не в задаче.
+func (w *Worker) holdLease(ctx context.Context, task *models.ProcessingCommand) func() {
+	if task.RunID != "" || w.cfg.LeaseDuration <= 0 {
+		return func() {}
+	}
+	if err := w.repo.AcquireLease(ctx, task.ID, time.Now().Add(w.cfg.LeaseDuration)); err != nil {
+		w.log.Warn("Failed to acquire task lease", zap.String("id", task.ID), zap.Error(err))
+	}
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(w.cfg.LeaseDuration / leaseRenewals)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+				if err := w.repo.RenewLease(leaseCtx, task.ID, time.Now().Add(w.cfg.LeaseDuration)); err != nil {
+					w.log.Warn("Failed to renew task lease", zap.String("id", task.ID), zap.Error(err))
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// process выполняет запрошенные операции. Ошибка одной операции не мешает остальным,
+// а временная ошибка повторяется с задержкой. Операции, уже выполненные для этой
+// команды до повторной доставки, пропускаются. Возвращает наибольшее число попыток
+// среди неудачных операций.
+// Отмена проверяется перед каждой операцией и перед публикацией ее результата:
+// результат сначала пишется во временный файл и переносится на место, только
+// если задачу не отменили.
+func (w *Worker) process(ctx context.Context, task *models.ProcessingCommand) (int, error) {
+	var errs []error
+	maxAttempts := 0
+	completed := w.completedOperations(ctx, task)
+	for _, operation := range task.RequestedOperations {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if completed[operation] {
+			w.log.Debug("Operation already completed", zap.String("command", task.CommandID()), zap.String("operation", operation))
+			continue
+		}
+		if w.cancelled(ctx, task) {
+			return 0, errCancelled
+		}
+
+		processedPath := w.cfg.Keys.RunDerivativePath(operation, task.OriginalPath, task.OutputRuns[operation])
+		if !task.Replace {
+			// Производные адресуются дайджестом оригинала и параметрами операции,
+			// поэтому готовый результат для того же содержимого можно переиспользовать
+			exists, err := w.storage.Exists(processedPath)
 			if err != nil {
-				w.log.Warn("Error reading message", zap.Error(err))
-				_ = w.consume.CommitMessage(ctx, msg)
-				continue
+				w.log.Warn("Failed to check derivative", zap.String("path", processedPath), zap.Error(err))
 			}
-			for _, operation := range task.RequestedOperations {
-				baseFilename := filepath.Base(task.OriginalPath)
-				processedPath := fmt.Sprintf(models.ProcessPath, operation, baseFilename)
-				switch operation {
-				case "resize":
-					if err := w.modifier.Resize(task.OriginalPath, processedPath, models.ResizeToWidth, models.ResizeToHeight); err != nil {
-						w.failed(ctx, task.ID)
-						w.log.Error("Error resizing image", zap.Error(err))
-					}
-				case "thumbnail":
-					if err := w.modifier.Thumbnail(task.OriginalPath, processedPath, models.ThumbnailToWidth, models.ThumbnailToHeight); err != nil {
-						w.failed(ctx, task.ID)
-						w.log.Error("Error thumbnailing image", zap.Error(err))
-					}
-				case "watermark":
-					if err := w.modifier.Watermark(task.OriginalPath, processedPath); err != nil {
-						w.failed(ctx, task.ID)
-						w.log.Error("Error watermarking image", zap.Error(err))
-					}
-				}
+			if exists {
+				w.log.Debug("Reusing existing derivative", zap.String("path", processedPath))
+				continue
 			}
+		}
+
+		workPath := fmt.Sprintf(models.WorkPath, task.CommandID(), w.cfg.Keys.Key(operation), filepath.Base(processedPath))
+		attempts, err := w.withRetry(ctx, func() error {
+			return w.render(task, operation, workPath)
+		})
+		if err != nil {
+			w.log.Error("Error processing image", zap.String("id", task.ID), zap.String("operation", operation),
+				zap.Int("attempts", attempts), zap.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", operation, err))
+			maxAttempts = max(maxAttempts, attempts)
+			w.deleteFile(workPath)
+			continue
+		}
+
+		if w.cancelled(ctx, task) {
+			w.deleteFile(workPath)
+			return 0, errCancelled
+		}
+		attempts, err = w.withRetry(ctx, func() error {
+			return w.storage.Rename(workPath, processedPath)
+		})
+		if err != nil {
+			w.log.Error("Error publishing derivative", zap.String("path", processedPath), zap.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", operation, err))
+			maxAttempts = max(maxAttempts, attempts)
+			w.deleteFile(workPath)
+			continue
+		}
+		if w.discardOrphan(ctx, task, operation, processedPath) {
+			return 0, errCancelled
+		}
+		if err := w.repo.CompleteOperation(ctx, task.CommandID(), task.ID, operation, time.Now()); err != nil {
+			w.log.Warn("Failed to record completed operation", zap.String("operation", operation), zap.Error(err))
+		}
+	}
+	return maxAttempts, errors.Join(errs...)
+}
+
+func (w *Worker) render(task *models.ProcessingCommand, operation, workPath string) error {
+	switch operation {
+	case "resize":
+		return w.modifier.Resize(task.OriginalPath, workPath, models.ResizeToWidth, models.ResizeToHeight)
+	case "thumbnail":
+		return w.modifier.Thumbnail(task.OriginalPath, workPath, models.ThumbnailToWidth, models.ThumbnailToHeight)
+	case "watermark":
+		return w.modifier.Watermark(task.OriginalPath, workPath)
+	}
+	return fmt.Errorf("%w: %s", models.ErrUnknownOperation, operation)
+}
 
-			_ = w.consume.CommitMessage(ctx, msg)
-			if err := w.repo.UpdateStatus(ctx, task.ID, models.StatusComplete); err != nil {
-				w.log.Error("Error updating status", zap.Error(err))
+// withRetry повторяет fn с нарастающей задержкой, пока ошибка временная и попытки
+// не исчерпаны. Возвращает число сделанных попыток.
+func (w *Worker) withRetry(ctx context.Context, fn func() error) (int, error) {
+	delay := w.cfg.Retry.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !transient(err) || attempt >= w.cfg.Retry.Attempts {
+			return attempt, err
+		}
+		w.log.Warn("Operation failed, retrying", zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return attempt, errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = time.Duration(float64(delay) * w.cfg.Retry.Backoff)
+	}
+}
+
+// transient отделяет временные ошибки от тех, что повтор не исправит:
+// оригинал удален или не является корректным изображением в любом формате.
+func transient(err error) bool {
+	return !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrDecode) &&
+		!errors.Is(err, models.ErrUnknownOperation)
+}
+
+// deadLetter отправляет сообщение в очередь недоставленных. Сообщение подтверждается
+// в любом случае, поэтому ошибка отправки только логируется.
+func (w *Worker) deadLetter(ctx context.Context, msg kafkaGo.Message, cause error, attempts int) {
+	if err := w.deadLetters.Send(ctx, msg, cause, attempts); err != nil {
+		w.log.Error("Failed to dead-letter message", zap.ByteString("key", msg.Key), zap.Error(err))
+	}
+}
+
+// completedOperations возвращает операции, уже выполненные для команды. Без этих
+// сведений операции просто выполняются заново.
+func (w *Worker) completedOperations(ctx context.Context, task *models.ProcessingCommand) map[string]bool {
+	operations, err := w.repo.CompletedOperations(ctx, task.CommandID())
+	if err != nil {
+		w.log.Warn("Failed to get completed operations", zap.String("command", task.CommandID()), zap.Error(err))
+		return nil
+	}
+	completed := make(map[string]bool, len(operations))
+	for _, operation := range operations {
+		completed[operation] = true
+	}
+	return completed
+}
+
+// cancelled сообщает, что результаты задачи больше не нужны: ее отменили или удалили.
+// Повторную обработку отменяет только удаление.
+func (w *Worker) cancelled(ctx context.Context, task *models.ProcessingCommand) bool {
+	status, err := w.repo.GetStatus(ctx, task.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrTaskNotFound) {
+			return true
+		}
+		// Без статуса продолжаем: потерять работу хуже, чем опубликовать лишний результат
+		w.log.Warn("Failed to check task status", zap.String("id", task.ID), zap.Error(err))
+		return false
+	}
+	return task.RunID == "" && status == models.StatusCancelled
+}
+
+// discardOrphan сообщает, что задачу удалили, пока результат переносился на место,
+// и удаляет этот результат. Общий результат удаляется, только если исчез и
+// оригинал: иначе он нужен другим изображениям с тем же содержимым, а при
+// удалении оригинала позже его удалит сервис вместе с остальными производными.
+func (w *Worker) discardOrphan(ctx context.Context, task *models.ProcessingCommand, operation, path string) bool {
+	if _, err := w.repo.GetStatus(ctx, task.ID); !errors.Is(err, models.ErrTaskNotFound) {
+		return false
+	}
+	if task.OutputRuns[operation] == "" {
+		exists, err := w.storage.Exists(task.OriginalPath)
+		if err != nil || exists {
+			return true
+		}
+	}
+	w.log.Info("Task was deleted during processing, removing derivative", zap.String("path", path))
+	w.deleteFile(path)
+	return true
+}
+
+func (w *Worker) deleteFile(path string) {
+	if err := w.storage.Delete(path); err != nil {
+		w.log.Error("Error deleting file", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// finishRun фиксирует итог повторной обработки. Успешный запуск переключает
+// изображение на новые результаты, а при замене удаляет прежние; результаты неудачного запуска с версией удаляются,
+// потому что на них никто не ссылается.
+func (w *Worker) finishRun(ctx context.Context, task *models.ProcessingCommand, processErr error) {
+	if processErr == nil {
+		err := w.repo.CompleteRun(ctx, task.RunID, task.ID, task.OutputRuns, time.Now())
+		if err == nil {
+			w.log.Info("Processing run completed", zap.String("id", task.ID), zap.String("run", task.RunID))
+			for operation, run := range task.ReplacedRuns {
+				w.deleteFile(w.cfg.Keys.RunDerivativePath(operation, task.OriginalPath, run))
 			}
+			return
+		}
+		w.log.Error("Error completing processing run", zap.String("run", task.RunID), zap.Error(err))
+		processErr = err
+	}
+
+	if err := w.repo.FailRun(ctx, task.RunID, processErr.Error(), time.Now()); err != nil {
+		w.log.Error("Error failing processing run", zap.String("run", task.RunID), zap.Error(err))
+	}
+	w.discardRunOutputs(task)
+}
+
+// discardRunOutputs удаляет результаты запуска с версией, на которые никто не ссылается.
+func (w *Worker) discardRunOutputs(task *models.ProcessingCommand) {
+	if task.RunID == "" {
+		return
+	}
+	for operation, run := range task.OutputRuns {
+		if run == task.RunID {
+			w.deleteFile(w.cfg.Keys.RunDerivativePath(operation, task.OriginalPath, run))
 		}
 	}
 }
 
-func (w *Worker) GetTask(msg kafkaGo.Message) (*models.Task, error) {
+func (w *Worker) GetTask(msg kafkaGo.Message) (*models.ProcessingCommand, error) {
 	w.log.Debug("Getting task", zap.ByteString("msg", msg.Value))
-	var task models.Task
+	var task models.ProcessingCommand
 	err := json.Unmarshal(msg.Value, &task)
 	if err != nil {
 		w.log.Error("Failed to unmarshal task", zap.String("task", string(msg.Value)), zap.Error(err))
@@ -109,6 +434,27 @@ func (w *Worker) GetTask(msg kafkaGo.Message) (*models.Task, error) {
 	return &task, nil
 }
 
-func (w *Worker) failed(ctx context.Context, id string) {
-	w.repo.UpdateStatus(ctx, id, models.StatusFailed)
+// storeHashes считает перцептивные хэши оригинала для поиска похожих изображений.
+// Ошибка хэширования не считается ошибкой обработки задачи.
+func (w *Worker) storeHashes(ctx context.Context, task *models.ProcessingCommand) {
+	hash, err := w.hasher.Hash(task.OriginalPath)
+	if err != nil {
+		w.log.Error("Error hashing image", zap.String("id", task.ID), zap.Error(err))
+		return
+	}
+	if err := w.repo.UpdateHashes(ctx, task.ID, hash); err != nil {
+		w.log.Error("Error saving image hashes", zap.String("id", task.ID), zap.Error(err))
+	}
+}
+
+// storeDimensions сохраняет размеры оригинала для фильтрации в списке изображений.
+func (w *Worker) storeDimensions(ctx context.Context, task *models.ProcessingCommand) {
+	width, height, err := w.storage.ImageSize(task.OriginalPath)
+	if err != nil {
+		w.log.Error("Error reading image size", zap.String("id", task.ID), zap.Error(err))
+		return
+	}
+	if err := w.repo.UpdateDimensions(ctx, task.ID, width, height); err != nil {
+		w.log.Error("Error saving image dimensions", zap.String("id", task.ID), zap.Error(err))
+	}
 }
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"ImageProcessor/internal/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type collectionRequest struct {
+	Name string `json:"name"`
+}
+
+type collectionImagesRequest struct {
+	ImageIDs []string `json:"image_ids"`
+}
+
+func (h *ImageHandler) CreateCollection(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	var req collectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object with name"})
+		return
+	}
+	collection, err := h.imageService.CreateCollection(c.Request.Context(), req.Name)
+	if err != nil {
+		writeCollectionError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"collection": collection})
+}
+
+// GetCollection отдает коллекцию с изображениями в заданном порядке
+// и подписанными ссылками на их файлы.
+func (h *ImageHandler) GetCollection(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	collection, err := h.imageService.GetCollection(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		writeCollectionError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"collection": collection})
+}
+
+func (h *ImageHandler) RenameCollection(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	var req collectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object with name"})
+		return
+	}
+	collection, err := h.imageService.RenameCollection(c.Request.Context(), c.Param("id"), req.Name)
+	if err != nil {
+		writeCollectionError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"collection": collection})
+}
+
+func (h *ImageHandler) DeleteCollection(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	if err := h.imageService.DeleteCollection(c.Request.Context(), c.Param("id")); err != nil {
+		writeCollectionError(c, log, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// AddToCollection добавляет изображения из image_ids в конец коллекции.
+func (h *ImageHandler) AddToCollection(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	var req collectionImagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object with image_ids"})
+		return
+	}
+	if err := h.imageService.AddToCollection(c.Request.Context(), c.Param("id"), req.ImageIDs); err != nil {
+		writeCollectionError(c, log, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ImageHandler) RemoveFromCollection(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	if err := h.imageService.RemoveFromCollection(c.Request.Context(), c.Param("id"), c.Param("image_id")); err != nil {
+		writeCollectionError(c, log, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ReorderCollection задает порядок изображений: image_ids должен перечислять
+// все изображения коллекции.
+func (h *ImageHandler) ReorderCollection(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	var req collectionImagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object with image_ids"})
+		return
+	}
+	if err := h.imageService.ReorderCollection(c.Request.Context(), c.Param("id"), req.ImageIDs); err != nil {
+		writeCollectionError(c, log, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func writeCollectionError(c *gin.Context, log *zap.Logger, err error) {
+	switch {
+	case errors.Is(err, models.ErrInvalidCollection):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrCollectionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "collection not found"})
+	case errors.Is(err, models.ErrNotInCollection):
+		c.JSON(http.StatusNotFound, gin.H{"error": "image is not in the collection"})
+	case errors.Is(err, models.ErrTaskNotFound):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "some images do not exist"})
+	default:
+		log.Error("Image service failed to update collection", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process collection"})
+	}
+}
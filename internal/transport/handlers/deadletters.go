@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"ImageProcessor/internal/models"
+	"ImageProcessor/internal/service/deadletter_service"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+// DeadLetterHandler отдает администратору очередь недоставленных сообщений.
+type DeadLetterHandler struct {
+	deadLetterService *deadletter_service.DeadLetterService
+}
+
+func NewDeadLetterHandler(deadLetterService *deadletter_service.DeadLetterService) *DeadLetterHandler {
+	return &DeadLetterHandler{deadLetterService: deadLetterService}
+}
+
+// List принимает limit, offset и pending=true, чтобы скрыть уже повторенные сообщения.
+func (h *DeadLetterHandler) List(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+
+	var limit, offset int
+	for name, target := range map[string]*int{"limit": &limit, "offset": &offset} {
+		if raw := c.Query(name); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a non-negative integer"})
+				return
+			}
+			*target = value
+		}
+	}
+	pending := false
+	if raw := c.Query("pending"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pending must be a boolean"})
+			return
+		}
+		pending = value
+	}
+
+	letters, err := h.deadLetterService.ListDeadLetters(c.Request.Context(), pending, limit, offset)
+	if err != nil {
+		writeDeadLetterError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dead_letters": letters})
+}
+
+func (h *DeadLetterHandler) Get(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	letter, err := h.deadLetterService.GetDeadLetter(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		writeDeadLetterError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dead_letter": letter})
+}
+
+// Replay возвращает сообщение в исходный топик.
+func (h *DeadLetterHandler) Replay(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	letter, err := h.deadLetterService.ReplayDeadLetter(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		writeDeadLetterError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"dead_letter": letter})
+}
+
+func writeDeadLetterError(c *gin.Context, log *zap.Logger, err error) {
+	switch {
+	case errors.Is(err, models.ErrDeadLetterNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "dead letter not found"})
+	case errors.Is(err, models.ErrAlreadyReplayed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Error("Dead letter service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process dead letter"})
+	}
+}
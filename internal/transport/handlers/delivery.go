@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	storage "ImageProcessor/internal/file_storage"
+	"ImageProcessor/internal/models"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+)
+
+const (
+	sniffLen = 512
+	// Производные адресуются содержимым, поэтому их можно кэшировать бессрочно
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Варианты, зависящие от изменяемых данных, кэшируются только с перепроверкой
+	revalidateCacheControl = "public, no-cache"
+	// Ответ по подписанной ссылке нельзя хранить дольше самой ссылки и отдавать другим
+	signedCacheControl = "private, max-age=300"
+	retryAfterSeconds  = "2"
+)
+
+// GetDerivative отдает оригинал или результат операции с поддержкой ETag,
+// условных запросов и Range.
+func (h *ImageHandler) GetDerivative(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	taskID := c.Param("id")
+	operation := c.Param("operation")
+
+	derivative, err := h.imageService.GetDerivative(c.Request.Context(), taskID, operation)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDerivativeNotReady):
+			c.Header("Retry-After", retryAfterSeconds)
+			c.JSON(http.StatusAccepted, gin.H{"status": models.StatusProcessing})
+		case errors.Is(err, models.ErrTaskNotFound), errors.Is(err, models.ErrUnknownOperation), errors.Is(err, models.ErrDerivativeMissing):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Error("Image service failed to get derivative", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get image"})
+		}
+		return
+	}
+
+	serveObject(c, h.storage, derivative.Path, cacheHeaders{ETag: derivative.ETag, CacheControl: immutableCacheControl}, log)
+}
+
+// cacheHeaders выставляются только для успешно открытого объекта, чтобы ошибки
+// хранилища не попали в кэш браузеров и CDN.
+type cacheHeaders struct {
+	ETag         string
+	CacheControl string
+}
+
+// serveObject отдает объект хранилища через http.ServeContent, который
+// обрабатывает If-None-Match, If-Modified-Since и Range.
+func serveObject(c *gin.Context, fileStorage FileStorage, key string, cache cacheHeaders, log *zap.Logger) {
+	info, err := fileStorage.Stat(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+			return
+		}
+		log.Error("Failed to stat file", zap.String("key", key), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+		return
+	}
+
+	file, err := fileStorage.Open(key)
+	if err != nil {
+		log.Error("Failed to open file", zap.String("key", key), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	content, err := asReadSeeker(file, info.Size)
+	if err != nil {
+		log.Error("Failed to read file", zap.String("key", key), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+		return
+	}
+
+	contentType, err := sniffContentType(content)
+	if err != nil {
+		log.Error("Failed to detect content type", zap.String("key", key), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+		return
+	}
+	c.Header("Content-Type", contentType)
+	if cache.ETag != "" {
+		c.Header("ETag", cache.ETag)
+	}
+	c.Header("Cache-Control", cache.CacheControl)
+	http.ServeContent(c.Writer, c.Request, key, info.ModTime, content)
+}
+
+// asReadSeeker возвращает поток с произвольным доступом. Локальные файлы уже
+// им являются, а ответы удаленных хранилищ читаются в память.
+func asReadSeeker(file io.Reader, size int64) (io.ReadSeeker, error) {
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		return seeker, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := io.Copy(buf, file); err != nil {
+		return nil, fmt.Errorf("failed to buffer file: %w", err)
+	}
+	return bytes.NewReader(buf.Bytes()), nil
+}
+
+// sniffContentType определяет тип по сигнатуре файла, а не по расширению.
+func sniffContentType(content io.ReadSeeker) (string, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
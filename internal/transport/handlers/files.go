@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	storage "ImageProcessor/internal/file_storage"
+	"ImageProcessor/internal/urlsigner"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type FileStorage interface {
+	Open(path string) (io.ReadCloser, error)
+	Stat(path string) (*storage.ObjectInfo, error)
+}
+
+type URLVerifier interface {
+	Verify(path string, query url.Values) error
+}
+
+// FileHandler отдает файлы хранилища только по подписанным ссылкам с ограниченным сроком действия.
+type FileHandler struct {
+	storage  FileStorage
+	verifier URLVerifier
+}
+
+func NewFileHandler(storage FileStorage, verifier URLVerifier) *FileHandler {
+	return &FileHandler{storage: storage, verifier: verifier}
+}
+
+func (h *FileHandler) ServeSigned(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	key := strings.TrimPrefix(path.Clean(c.Param("filepath")), "/")
+
+	if err := h.verifier.Verify(c.Request.URL.Path, c.Request.URL.Query()); err != nil {
+		log.Warn("Rejected file request", zap.String("key", key), zap.Error(err))
+		status := http.StatusForbidden
+		if errors.Is(err, urlsigner.ErrExpired) {
+			status = http.StatusGone
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Ссылка сама ограничена по времени, поэтому кэшировать ответ дольше нельзя
+	serveObject(c, h.storage, key, cacheHeaders{CacheControl: signedCacheControl}, log)
+}
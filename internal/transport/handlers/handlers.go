@@ -1,20 +1,51 @@
 package handlers
 
 import (
+	"ImageProcessor/internal/fetcher"
 	"ImageProcessor/internal/models"
 	"ImageProcessor/internal/service/image_service"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	// defaultSimilarDistance не выходит за расстояние, при котором поиск похожих
+	// отбирает кандидатов по индексам, а не просматривает всю таблицу
+	defaultSimilarDistance = 10
+	maxBatchFiles          = 100
+)
+
+// RemoteFetcher скачивает изображение по ссылке и возвращает его расширение.
+type RemoteFetcher interface {
+	Fetch(ctx context.Context, rawURL string) (io.Reader, string, error)
+}
+
 type ImageHandler struct {
 	imageService *image_service.ImageService
+	storage      FileStorage
+	fetcher      RemoteFetcher
+	// maxUploadSize ограничивает тело потоковой загрузки.
+	maxUploadSize int64
+}
+
+type uploadURLRequest struct {
+	URL string `json:"url" binding:"required"`
 }
 
-func NewImageHandler(imageService *image_service.ImageService) *ImageHandler {
-	return &ImageHandler{imageService: imageService}
+func NewImageHandler(imageService *image_service.ImageService, storage FileStorage, fetcher RemoteFetcher, maxUploadSize int64) *ImageHandler {
+	return &ImageHandler{imageService: imageService, storage: storage, fetcher: fetcher, maxUploadSize: maxUploadSize}
 }
 
 func (h *ImageHandler) UploadImage(c *gin.Context) {
@@ -47,14 +78,402 @@ func (h *ImageHandler) UploadImage(c *gin.Context) {
 	}
 	defer file.Close()
 
-	taskID, err := h.imageService.UploadImage(c.Request.Context(), file, extension)
+	h.upload(c, log, file, extension)
+}
+
+// UploadBatch загружает несколько файлов из поля images одним запросом.
+// Каждый файл обрабатывается отдельно: ошибка одного не отменяет остальные.
+func (h *ImageHandler) UploadBatch(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	log.Debug("Uploading batch")
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		log.Error("Failed to parse multipart form", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+		return
+	}
+	files := form.File["images"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no files in field images"})
+		return
+	}
+	if len(files) > maxBatchFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("batch must contain at most %d files", maxBatchFiles)})
+		return
+	}
+
+	policy, ok := duplicatePolicy(c, log)
+	if !ok {
+		return
+	}
+
+	metadata, err := uploadMetadata(c)
+	if err == nil {
+		// Метаданные общие для всех файлов, поэтому проверяем их один раз
+		err = metadata.Validate()
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Пакет по умолчанию обрабатывается в очереди bulk
+	priority, err := uploadPriority(c, models.PriorityBulk)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	batchID, err := h.imageService.CreateBatch(c.Request.Context())
+	if err != nil {
+		log.Error("Image service failed to create batch", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create batch"})
+		return
+	}
+
+	result := models.BatchResult{BatchID: batchID, Items: make([]models.BatchItem, 0, len(files))}
+	opts := models.UploadOptions{DuplicatePolicy: policy, BatchID: batchID, Tags: parseTags(c.Query("tags")), Metadata: metadata, Priority: priority}
+	for _, fileHeader := range files {
+		result.Items = append(result.Items, h.uploadBatchItem(c.Request.Context(), log, fileHeader, opts))
+	}
+	// Ответ уже содержит ошибки файлов, поэтому сбой записи только логируется
+	if err := h.imageService.RecordBatchRejections(c.Request.Context(), batchID, result.Items); err != nil {
+		log.Error("Image service failed to record rejected batch files", zap.String("batchID", batchID), zap.Error(err))
+	}
+	log.Debug("Batch uploaded", zap.String("batchID", batchID), zap.Int("files", len(files)))
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *ImageHandler) uploadBatchItem(ctx context.Context, log *zap.Logger, fileHeader *multipart.FileHeader, opts models.UploadOptions) models.BatchItem {
+	item := models.BatchItem{Filename: fileHeader.Filename}
+
+	extension := filepath.Ext(fileHeader.Filename)
+	if !models.AllowedExtensions[extension] {
+		item.Error = "file extension is not allowed"
+		return item
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Error("Failed to open file", zap.String("filename", fileHeader.Filename), zap.Error(err))
+		item.Error = "failed to open file"
+		return item
+	}
+	defer file.Close()
+
+	result, err := h.imageService.UploadImage(ctx, file, extension, opts)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateImage) {
+			item.Error = "near-duplicate image already exists"
+			item.Duplicates = result.Duplicates
+			return item
+		}
+		log.Error("Image service failed to upload batch file", zap.String("filename", fileHeader.Filename), zap.Error(err))
+		item.Error = "failed to start image processing"
+		return item
+	}
+	item.TaskID = result.TaskID
+	item.Duplicates = result.Duplicates
+	return item
+}
+
+func (h *ImageHandler) GetBatch(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	batchID := c.Param("id")
+	batch, err := h.imageService.GetBatch(c.Request.Context(), batchID)
+	if err != nil {
+		if errors.Is(err, models.ErrBatchNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "batch not found"})
+			return
+		}
+		log.Error("Image service failed to get batch", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get batch"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"batch": batch})
+}
+
+// StreamUpload принимает изображение телом запроса (PUT /upload) и передает его
+// в хранилище потоком, без буферизации multipart-формы. Формат проверяется
+// по сигнатуре первых байт, размер ограничивается по мере чтения.
+func (h *ImageHandler) StreamUpload(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	log.Debug("Streaming Image upload")
+
+	extension, ok := models.MediaTypeExtensions[c.ContentType()]
+	if !ok {
+		log.Warn("Unsupported content type", zap.String("contentType", c.ContentType()))
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be one of: image/jpeg, image/png, image/gif"})
+		return
+	}
+	if c.Request.ContentLength > h.maxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("image must not exceed %d bytes", h.maxUploadSize)})
+		return
+	}
+
+	body := bufio.NewReaderSize(http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadSize), sniffLen)
+	// Peek не продвигает поток, поэтому проверенные байты попадут в хранилище
+	head, err := body.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Warn("Failed to read request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+		return
+	}
+	if detected := http.DetectContentType(head); detected != c.ContentType() {
+		log.Warn("Content does not match declared type", zap.String("contentType", c.ContentType()), zap.String("detected", detected))
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "image content does not match Content-Type"})
+		return
+	}
+
+	h.upload(c, log, body, extension)
+}
+
+// UploadFromURL скачивает изображение по ссылке и загружает его так же, как UploadImage.
+func (h *ImageHandler) UploadFromURL(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	log.Debug("Uploading Image from URL")
+
+	var req uploadURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Warn("Invalid upload url request", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+
+	image, extension, err := h.fetcher.Fetch(c.Request.Context(), req.URL)
+	if err != nil {
+		log.Warn("Failed to fetch remote image", zap.Error(err))
+		switch {
+		case errors.Is(err, fetcher.ErrInvalidURL), errors.Is(err, fetcher.ErrBlockedAddress), errors.Is(err, fetcher.ErrTooManyRedirects):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, fetcher.ErrTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		case errors.Is(err, fetcher.ErrUnsupportedType):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch remote image"})
+		}
+		return
+	}
+
+	h.upload(c, log, image, extension)
+}
+
+// upload передает файл в сервис и пишет ответ; общая часть всех способов загрузки.
+func (h *ImageHandler) upload(c *gin.Context, log *zap.Logger, image io.Reader, extension string) {
+	policy, ok := duplicatePolicy(c, log)
+	if !ok {
+		return
+	}
+
+	metadata, err := uploadMetadata(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	priority, err := uploadPriority(c, models.PriorityNormal)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := models.UploadOptions{
+		DuplicatePolicy: policy,
+		Tags:            parseTags(c.Query("tags")),
+		Metadata:        metadata,
+		Priority:        priority,
+		IdempotencyKey:  c.GetHeader("Idempotency-Key"),
+	}
+	result, err := h.imageService.UploadImage(c.Request.Context(), image, extension, opts)
 	if err != nil {
+		if errors.Is(err, models.ErrInvalidMetadata) || errors.Is(err, models.ErrInvalidIdempotencyKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrIdempotencyKeyInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a request with this idempotency key is in progress"})
+			return
+		}
+		if errors.Is(err, models.ErrIdempotencyKeyReused) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrDuplicateImage) {
+			log.Info("Near-duplicate upload rejected")
+			c.JSON(http.StatusConflict, gin.H{"error": "near-duplicate image already exists", "duplicates": result.Duplicates})
+			return
+		}
+		// Потоковая загрузка прерывается, как только тело превышает лимит
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Warn("Upload exceeds size limit", zap.Int64("limit", maxBytesErr.Limit))
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("image must not exceed %d bytes", maxBytesErr.Limit)})
+			return
+		}
 		log.Error("Image service failed to upload image", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start image processing"})
 		return
 	}
-	log.Debug("Image uploaded successfully", zap.String("taskID", taskID))
-	c.JSON(http.StatusOK, gin.H{"taskID": taskID})
+	if result.Replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+	log.Debug("Image uploaded successfully", zap.String("taskID", result.TaskID))
+	c.JSON(http.StatusOK, result)
+}
+
+// duplicatePolicy читает параметр duplicates; при неверном значении сам пишет ответ 400.
+func duplicatePolicy(c *gin.Context, log *zap.Logger) (models.DuplicatePolicy, bool) {
+	policy := models.DuplicatePolicy(c.Query("duplicates"))
+	if policy != models.DuplicateAllow && policy != models.DuplicateWarn && policy != models.DuplicateReject {
+		log.Warn("Unknown duplicate policy", zap.String("duplicates", string(policy)))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duplicates must be one of: warn, reject"})
+		return "", false
+	}
+	return policy, true
+}
+
+// uploadPriority читает приоритет обработки из поля формы или параметра priority.
+func uploadPriority(c *gin.Context, fallback models.Priority) (models.Priority, error) {
+	raw, ok := c.GetPostForm("priority")
+	if !ok {
+		raw = c.Query("priority")
+	}
+	return models.ParsePriority(raw, fallback)
+}
+
+// parseTags разбирает список тегов через запятую.
+func parseTags(raw string) []string {
+	return models.NormalizeTags(strings.Split(raw, ","))
+}
+
+// uploadMetadata читает title, description, alt и labels (JSON-объект)
+// из полей формы или параметров запроса.
+func uploadMetadata(c *gin.Context) (models.ImageMetadata, error) {
+	value := func(key string) string {
+		if v, ok := c.GetPostForm(key); ok {
+			return v
+		}
+		return c.Query(key)
+	}
+	metadata := models.ImageMetadata{
+		Title:       value("title"),
+		Description: value("description"),
+		Alt:         value("alt"),
+	}
+	if raw := value("labels"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &metadata.Labels); err != nil {
+			return metadata, fmt.Errorf("%w: labels must be a JSON object with string values", models.ErrInvalidMetadata)
+		}
+	}
+	return metadata, nil
+}
+
+// UpdateMetadata частично изменяет метаданные изображения (PATCH /image/:id).
+func (h *ImageHandler) UpdateMetadata(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	taskID := c.Param("id")
+
+	var patch models.MetadataPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		log.Warn("Invalid metadata patch", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object"})
+		return
+	}
+
+	task, err := h.imageService.UpdateMetadata(c.Request.Context(), taskID, patch)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidMetadata):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrTaskNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		default:
+			log.Error("Image service failed to update metadata", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update metadata"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task": task})
+}
+
+// ListImages возвращает страницу изображений с фильтрами:
+// status, created_from, created_to (RFC 3339), format, min_width, max_width,
+// min_height, max_height, tags (все перечисленные), q (поиск по названию,
+// описанию и alt-тексту), label=key:value (можно повторять), sort, cursor, limit.
+func (h *ImageHandler) ListImages(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+
+	filter, err := parseImageFilter(c)
+	if err != nil {
+		log.Warn("Invalid image filter", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.imageService.ListImages(c.Request.Context(), filter)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidFilter) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Error("Image service failed to list images", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list images"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func parseImageFilter(c *gin.Context) (models.ImageFilter, error) {
+	filter := models.ImageFilter{
+		Status: models.TaskStatus(strings.ToUpper(c.Query("status"))),
+		Format: models.FormatFromExtension(c.Query("format")),
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+	if raw := c.Query("format"); raw != "" && filter.Format == "" {
+		return filter, fmt.Errorf("%w: unknown format %q", models.ErrInvalidFilter, raw)
+	}
+	if raw := c.Query("tags"); raw != "" {
+		filter.Tags = parseTags(raw)
+	}
+	filter.Query = strings.TrimSpace(c.Query("q"))
+	for _, raw := range c.QueryArray("label") {
+		key, value, ok := strings.Cut(raw, ":")
+		if !ok || key == "" {
+			return filter, fmt.Errorf("%w: label must look like key:value", models.ErrInvalidFilter)
+		}
+		if filter.Labels == nil {
+			filter.Labels = make(map[string]string)
+		}
+		filter.Labels[key] = value
+	}
+
+	for name, target := range map[string]*time.Time{"created_from": &filter.CreatedFrom, "created_to": &filter.CreatedTo} {
+		if raw := c.Query(name); raw != "" {
+			value, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return filter, fmt.Errorf("%w: %s must be an RFC 3339 timestamp", models.ErrInvalidFilter, name)
+			}
+			*target = value
+		}
+	}
+
+	for name, target := range map[string]*int{
+		"min_width": &filter.MinWidth, "max_width": &filter.MaxWidth,
+		"min_height": &filter.MinHeight, "max_height": &filter.MaxHeight,
+		"limit": &filter.Limit,
+	} {
+		if raw := c.Query(name); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 0 {
+				return filter, fmt.Errorf("%w: %s must be a non-negative integer", models.ErrInvalidFilter, name)
+			}
+			*target = value
+		}
+	}
+	return filter, nil
 }
 
 func (h *ImageHandler) GetImage(c *gin.Context) {
@@ -63,6 +482,10 @@ func (h *ImageHandler) GetImage(c *gin.Context) {
 	taskID := c.Param("id")
 	task, err := h.imageService.GetImage(c.Request.Context(), taskID)
 	if err != nil {
+		if errors.Is(err, models.ErrTaskNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+			return
+		}
 		log.Error("Image service failed to get image", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get image"})
 		return
@@ -71,6 +494,57 @@ func (h *ImageHandler) GetImage(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"task": task})
 }
 
+func (h *ImageHandler) GetSimilar(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	log.Debug("Getting similar images")
+	taskID := c.Param("id")
+
+	maxDistance := defaultSimilarDistance
+	if raw := c.Query("maxDistance"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 || value > 64 {
+			log.Warn("Invalid maxDistance", zap.String("maxDistance", raw))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "maxDistance must be an integer between 0 and 64"})
+			return
+		}
+		maxDistance = value
+	}
+
+	similar, err := h.imageService.FindSimilar(c.Request.Context(), taskID, maxDistance)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTaskNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		case errors.Is(err, models.ErrHashNotReady):
+			c.JSON(http.StatusAccepted, gin.H{"error": "image is still being processed"})
+		default:
+			log.Error("Image service failed to find similar images", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find similar images"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"similar": similar})
+}
+
+// CancelImage останавливает обработку изображения. Повторная отмена не считается ошибкой.
+func (h *ImageHandler) CancelImage(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	taskID := c.Param("id")
+	if err := h.imageService.CancelImage(c.Request.Context(), taskID); err != nil {
+		switch {
+		case errors.Is(err, models.ErrTaskNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		case errors.Is(err, models.ErrNotCancellable):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Error("Image service failed to cancel image", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel image"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": taskID, "status": models.StatusCancelled})
+}
+
 func (h *ImageHandler) DeleteImage(c *gin.Context) {
 	log := c.MustGet("logger").(*zap.Logger)
 	log.Debug("Deleting Image")
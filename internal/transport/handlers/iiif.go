@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"ImageProcessor/internal/iiif"
+	"ImageProcessor/internal/models"
+	"ImageProcessor/internal/service/render_service"
+	"ImageProcessor/internal/urlsigner"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+const (
+	iiifPrefix      = "/iiif/"
+	iiifInfo        = "info.json"
+	jsonLDMediaType = "application/ld+json"
+)
+
+// IIIFHandler реализует IIIF Image API 3.0 (уровень 1 и часть возможностей уровня 2).
+type IIIFHandler struct {
+	renderService *render_service.RenderService
+	storage       FileStorage
+	verifier      URLVerifier
+}
+
+func NewIIIFHandler(renderService *render_service.RenderService, storage FileStorage, verifier URLVerifier) *IIIFHandler {
+	return &IIIFHandler{renderService: renderService, storage: storage, verifier: verifier}
+}
+
+// Serve разбирает {region}/{size}/{rotation}/{quality}.{format} или info.json.
+// Подписанные запросы сохраняются в кэше всегда, неподписанные — только для всего
+// изображения максимального или разрешенного размера.
+func (h *IIIFHandler) Serve(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	taskID := c.Param("id")
+	rest := strings.Trim(c.Param("path"), "/")
+	c.Header("Access-Control-Allow-Origin", "*")
+
+	switch rest {
+	case "":
+		// Базовый URI изображения перенаправляет на info.json
+		c.Redirect(http.StatusSeeOther, iiifPrefix+taskID+"/"+iiifInfo)
+		return
+	case iiifInfo:
+		h.info(c, log, taskID)
+		return
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) != 4 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expected {region}/{size}/{rotation}/{quality}.{format}"})
+		return
+	}
+	req, err := iiif.Parse(parts[0], parts[1], parts[2], parts[3])
+	if err != nil {
+		h.writeError(c, log, err)
+		return
+	}
+
+	trusted := false
+	if c.Query(urlsigner.ParamSignature) != "" {
+		if err := h.verifier.Verify(c.Request.URL.Path, c.Request.URL.Query()); err != nil {
+			log.Warn("Rejected signed iiif request", zap.Error(err))
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		trusted = true
+	}
+
+	derivative, err := h.renderService.RenderIIIF(c.Request.Context(), taskID, req, trusted)
+	if err != nil {
+		h.writeError(c, log, err)
+		return
+	}
+	defer h.renderService.Discard(derivative)
+
+	c.Header("Link", fmt.Sprintf(`<%s>;rel="profile"`, iiif.ProfileLink))
+	cache := cacheHeaders{ETag: derivative.ETag, CacheControl: immutableCacheControl}
+	if trusted {
+		// Регион и размер вне списка разрешены только подписью
+		cache.CacheControl = signedCacheControl
+	}
+	serveObject(c, h.storage, derivative.Path, cache, log)
+}
+
+func (h *IIIFHandler) info(c *gin.Context, log *zap.Logger, taskID string) {
+	width, height, limits, err := h.renderService.ImageInfo(c.Request.Context(), taskID)
+	if err != nil {
+		h.writeError(c, log, err)
+		return
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	id := fmt.Sprintf("%s://%s%s%s", scheme, c.Request.Host, iiifPrefix, taskID)
+
+	// c.JSON не перезаписывает уже выставленный Content-Type
+	if strings.Contains(c.GetHeader("Accept"), jsonLDMediaType) {
+		c.Header("Content-Type", fmt.Sprintf(`%s;profile="%s"`, jsonLDMediaType, iiif.Context))
+	}
+	c.Header("Link", fmt.Sprintf(`<%s>;rel="profile"`, iiif.ProfileLink))
+	c.JSON(http.StatusOK, iiif.NewInfo(id, width, height, limits))
+}
+
+func (h *IIIFHandler) writeError(c *gin.Context, log *zap.Logger, err error) {
+	switch {
+	case errors.Is(err, iiif.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, iiif.ErrNotImplemented):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrTaskNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+	default:
+		log.Error("Failed to serve iiif request", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process image"})
+	}
+}
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	storage "ImageProcessor/internal/file_storage"
+	"ImageProcessor/internal/iiif"
+	"ImageProcessor/internal/models"
+	"ImageProcessor/internal/modifer"
+	"ImageProcessor/internal/service/render_service"
+	"ImageProcessor/internal/urlsigner"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Набор проверок соответствия IIIF Image API 3.0 уровня 1 повторяет проверки
+// официального валидатора (https://iiif.io/api/image/validator/), но работает
+// локально: запросы идут в настоящий обработчик, рендер и файловое хранилище.
+// Тестовое изображение 200x100 разбито на четыре цветные четверти, поэтому по
+// цвету углов результата видно, какая область вырезана и как она повернута.
+
+const (
+	testImageID     = "a6b1c2d3-0000-4000-8000-000000000001"
+	testImageDigest = "digest"
+)
+
+var (
+	red    = color.RGBA{R: 255, A: 255}
+	green  = color.RGBA{G: 255, A: 255}
+	blue   = color.RGBA{B: 255, A: 255}
+	yellow = color.RGBA{R: 255, G: 255, A: 255}
+)
+
+type fakeTaskRepo struct{}
+
+func (fakeTaskRepo) GetTask(_ context.Context, id string) (*models.Task, error) {
+	if id != testImageID {
+		return nil, models.ErrTaskNotFound
+	}
+	return &models.Task{ID: id, Digest: testImageDigest, OriginalPath: "originals/" + testImageDigest + ".png"}, nil
+}
+
+type iiifServer struct {
+	engine  *gin.Engine
+	storage *storage.FileStorage
+	signer  *urlsigner.Signer
+}
+
+func newIIIFServer(t *testing.T) *iiifServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := zap.NewNop()
+
+	backend, err := storage.NewLocalBackend(t.TempDir(), nil, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileStorage := storage.NewFileStorage(backend, log)
+	original := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := range 100 {
+		for x := range 200 {
+			c := red
+			switch {
+			case x >= 100 && y >= 50:
+				c = yellow
+			case x >= 100:
+				c = green
+			case y >= 50:
+				c = blue
+			}
+			original.Set(x, y, c)
+		}
+	}
+	task, _ := fakeTaskRepo{}.GetTask(context.Background(), testImageID)
+	if err := fileStorage.SaveImage(task.OriginalPath, original, "png"); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := urlsigner.New("/files", map[string]string{"test": "secret"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderService := render_service.NewRenderService(fakeTaskRepo{}, fileStorage, modifer.NewRenderer(models.BasePath, fileStorage, log), render_service.Config{
+		MaxDimension:  1000,
+		AllowedWidths: []uint{50},
+	}, log)
+	handler := NewIIIFHandler(renderService, fileStorage, signer)
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { c.Set("logger", log) })
+	engine.GET("/iiif/:id", handler.Serve)
+	engine.GET("/iiif/:id/*path", handler.Serve)
+	return &iiifServer{engine: engine, storage: fileStorage, signer: signer}
+}
+
+func (s *iiifServer) get(t *testing.T, target string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+	return rec
+}
+
+// image запрашивает изображение по параметрам IIIF и декодирует ответ.
+func (s *iiifServer) image(t *testing.T, params string) image.Image {
+	t.Helper()
+	rec := s.get(t, "/iiif/"+testImageID+"/"+params, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: status %d: %s", params, rec.Code, rec.Body)
+	}
+	img, _, err := image.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("%s: decode: %v", params, err)
+	}
+	return img
+}
+
+// corners возвращает цвета центров четвертей: левой верхней, правой верхней,
+// левой нижней и правой нижней.
+func corners(img image.Image) [4]color.Color {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	at := func(x, y int) color.Color { return img.At(b.Min.X+x, b.Min.Y+y) }
+	return [4]color.Color{at(w/4, h/4), at(3*w/4, h/4), at(w/4, 3*h/4), at(3*w/4, 3*h/4)}
+}
+
+// similar сравнивает цвета с допуском на сжатие и сглаживание при масштабировании.
+func similar(a, b color.Color) bool {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	near := func(x, y uint32) bool {
+		d := int(x>>8) - int(y>>8)
+		return d > -48 && d < 48
+	}
+	return near(ar, br) && near(ag, bg) && near(ab, bb)
+}
+
+func expectCorners(t *testing.T, params string, img image.Image, want [4]color.Color) {
+	t.Helper()
+	got := corners(img)
+	for i := range got {
+		if !similar(got[i], want[i]) {
+			t.Fatalf("%s: corner %d is %v, want %v", params, i, got[i], want[i])
+		}
+	}
+}
+
+func expectSize(t *testing.T, params string, img image.Image, width, height int) {
+	t.Helper()
+	if b := img.Bounds(); b.Dx() != width || b.Dy() != height {
+		t.Fatalf("%s: size %dx%d, want %dx%d", params, b.Dx(), b.Dy(), width, height)
+	}
+}
+
+func TestIIIFInfo(t *testing.T) {
+	s := newIIIFServer(t)
+	rec := s.get(t, "/iiif/"+testImageID+"/info.json", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("CORS header %q", got)
+	}
+	if got := rec.Header().Get("Link"); !strings.Contains(got, iiif.ProfileLink) {
+		t.Errorf("Link header %q", got)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("Content-Type %q", got)
+	}
+
+	var info map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"@context": iiif.Context,
+		"id":       "http://example.com/iiif/" + testImageID,
+		"type":     "ImageService3",
+		"protocol": iiif.Protocol,
+		"profile":  "level1",
+		"width":    float64(200),
+		"height":   float64(100),
+	}
+	for key, value := range want {
+		if info[key] != value {
+			t.Errorf("%s is %v, want %v", key, info[key], value)
+		}
+	}
+
+	rec = s.get(t, "/iiif/"+testImageID+"/info.json", http.Header{"Accept": {"application/ld+json"}})
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, `application/ld+json;profile="`+iiif.Context+`"`) {
+		t.Errorf("JSON-LD Content-Type %q", got)
+	}
+}
+
+func TestIIIFBaseURIRedirect(t *testing.T) {
+	s := newIIIFServer(t)
+	rec := s.get(t, "/iiif/"+testImageID, nil)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/iiif/"+testImageID+"/info.json" {
+		t.Fatalf("status %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestIIIFRegion(t *testing.T) {
+	s := newIIIFServer(t)
+	tests := []struct {
+		params        string
+		width, height int
+		corners       [4]color.Color
+	}{
+		{"full/max/0/default.png", 200, 100, [4]color.Color{red, green, blue, yellow}},
+		{"square/max/0/default.png", 100, 100, [4]color.Color{red, green, blue, yellow}},
+		{"100,0,100,50/max/0/default.png", 100, 50, [4]color.Color{green, green, green, green}},
+		{"0,50,100,50/max/0/default.png", 100, 50, [4]color.Color{blue, blue, blue, blue}},
+		{"pct:50,50,50,50/max/0/default.png", 100, 50, [4]color.Color{yellow, yellow, yellow, yellow}},
+		{"pct:0,0,50,100/max/0/default.png", 100, 100, [4]color.Color{red, red, blue, blue}},
+		// Область за краем изображения обрезается по нему
+		{"150,50,100,100/max/0/default.png", 50, 50, [4]color.Color{yellow, yellow, yellow, yellow}},
+	}
+	for _, tt := range tests {
+		img := s.image(t, tt.params)
+		expectSize(t, tt.params, img, tt.width, tt.height)
+		expectCorners(t, tt.params, img, tt.corners)
+	}
+}
+
+func TestIIIFSize(t *testing.T) {
+	s := newIIIFServer(t)
+	tests := []struct {
+		params        string
+		width, height int
+	}{
+		{"full/max/0/default.png", 200, 100},
+		{"full/100,/0/default.png", 100, 50},
+		{"full/,50/0/default.png", 100, 50},
+		{"full/pct:50/0/default.png", 100, 50},
+		{"full/40,40/0/default.png", 40, 40},
+		{"full/!50,50/0/default.png", 50, 25},
+		{"full/^400,/0/default.png", 400, 200},
+		{"full/^pct:150/0/default.png", 300, 150},
+		{"full/^max/0/default.png", 1000, 500},
+	}
+	for _, tt := range tests {
+		img := s.image(t, tt.params)
+		expectSize(t, tt.params, img, tt.width, tt.height)
+		expectCorners(t, tt.params, img, [4]color.Color{red, green, blue, yellow})
+	}
+}
+
+func TestIIIFRotation(t *testing.T) {
+	s := newIIIFServer(t)
+	tests := []struct {
+		params        string
+		width, height int
+		corners       [4]color.Color
+	}{
+		{"full/max/90/default.png", 100, 200, [4]color.Color{blue, red, yellow, green}},
+		{"full/max/180/default.png", 200, 100, [4]color.Color{yellow, blue, green, red}},
+		{"full/max/270/default.png", 100, 200, [4]color.Color{green, yellow, red, blue}},
+		{"full/max/360/default.png", 200, 100, [4]color.Color{red, green, blue, yellow}},
+		{"full/max/!0/default.png", 200, 100, [4]color.Color{green, red, yellow, blue}},
+		{"full/max/!180/default.png", 200, 100, [4]color.Color{blue, yellow, red, green}},
+	}
+	for _, tt := range tests {
+		img := s.image(t, tt.params)
+		expectSize(t, tt.params, img, tt.width, tt.height)
+		expectCorners(t, tt.params, img, tt.corners)
+	}
+}
+
+func TestIIIFQuality(t *testing.T) {
+	s := newIIIFServer(t)
+	for _, params := range []string{"full/max/0/default.png", "full/max/0/color.png"} {
+		expectCorners(t, params, s.image(t, params), [4]color.Color{red, green, blue, yellow})
+	}
+
+	img := s.image(t, "full/max/0/gray.png")
+	for i, c := range corners(img) {
+		r, g, b, _ := c.RGBA()
+		if r != g || g != b {
+			t.Fatalf("gray: corner %d is %v", i, c)
+		}
+	}
+}
+
+func TestIIIFFormat(t *testing.T) {
+	s := newIIIFServer(t)
+	for format, contentType := range map[string]string{"jpg": "image/jpeg", "png": "image/png", "gif": "image/gif"} {
+		params := "full/max/0/default." + format
+		rec := s.get(t, "/iiif/"+testImageID+"/"+params, nil)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentType {
+			t.Fatalf("%s: status %d, Content-Type %q", params, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if rec.Header().Get("Access-Control-Allow-Origin") != "*" || !strings.Contains(rec.Header().Get("Link"), iiif.ProfileLink) {
+			t.Fatalf("%s: missing CORS or profile link", params)
+		}
+		img, _, err := image.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("%s: decode: %v", params, err)
+		}
+		expectCorners(t, params, img, [4]color.Color{red, green, blue, yellow})
+	}
+}
+
+func TestIIIFErrors(t *testing.T) {
+	s := newIIIFServer(t)
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{testImageID + "/full/max/0", http.StatusBadRequest},
+		{testImageID + "/bogus/max/0/default.png", http.StatusBadRequest},
+		{testImageID + "/0,0,0,10/max/0/default.png", http.StatusBadRequest},
+		{testImageID + "/300,300,10,10/max/0/default.png", http.StatusBadRequest},
+		{testImageID + "/full/0,/0/default.png", http.StatusBadRequest},
+		{testImageID + "/full/!50,/0/default.png", http.StatusBadRequest},
+		// Без ^ увеличивать изображение нельзя
+		{testImageID + "/full/400,/0/default.png", http.StatusBadRequest},
+		{testImageID + "/full/pct:150/0/default.png", http.StatusBadRequest},
+		{testImageID + "/full/^2000,/0/default.png", http.StatusBadRequest},
+		{testImageID + "/full/max/-90/default.png", http.StatusBadRequest},
+		{testImageID + "/full/max/0/fancy.png", http.StatusBadRequest},
+		{testImageID + "/full/max/45/default.png", http.StatusNotImplemented},
+		{testImageID + "/full/max/0/bitonal.png", http.StatusNotImplemented},
+		{testImageID + "/full/max/0/default.webp", http.StatusNotImplemented},
+		{"unknown/full/max/0/default.png", http.StatusNotFound},
+		{"unknown/info.json", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := s.get(t, "/iiif/"+tt.path, nil); rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, rec.Code, tt.status)
+		}
+	}
+}
+
+// TestIIIFPersistsOnlyBoundedRenditions проверяет, что неподписанные запросы
+// произвольных областей и размеров не оседают в хранилище.
+func TestIIIFPersistsOnlyBoundedRenditions(t *testing.T) {
+	s := newIIIFServer(t)
+	renditions := func() int {
+		objects, err := s.storage.List("renditions/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(objects)
+	}
+
+	for _, params := range []string{"10,10,20,20/max/0/default.png", "full/77,/0/default.png", "square/33,/90/gray.jpg"} {
+		s.image(t, params)
+	}
+	if n := renditions(); n != 0 {
+		t.Fatalf("unsigned arbitrary requests persisted %d renditions", n)
+	}
+	if tmp, err := s.storage.List("tmp/"); err != nil || len(tmp) != 0 {
+		t.Fatalf("temporary renditions left behind: %v (err %v)", tmp, err)
+	}
+
+	for _, params := range []string{"full/max/0/default.png", "square/max/90/default.jpg", "full/50,/0/default.png"} {
+		s.image(t, params)
+	}
+	if n := renditions(); n != 3 {
+		t.Fatalf("got %d persisted renditions, want 3", n)
+	}
+
+	signed := s.signer.SignPath("/iiif/"+testImageID+"/10,10,20,20/max/0/default.png", nil, time.Minute)
+	rec := s.get(t, signed, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signed request: status %d", rec.Code)
+	}
+	// Ответ по подписи не должен пережить ссылку в общих кэшах
+	if got := rec.Header().Get("Cache-Control"); got != signedCacheControl {
+		t.Fatalf("signed request: Cache-Control %q", got)
+	}
+	if got := s.get(t, "/iiif/"+testImageID+"/full/max/0/default.png", nil).Header().Get("Cache-Control"); got != immutableCacheControl {
+		t.Fatalf("unsigned request: Cache-Control %q", got)
+	}
+	if n := renditions(); n != 4 {
+		t.Fatalf("signed request was not persisted: %d renditions", n)
+	}
+
+	forged := strings.Replace(signed, "10,10,20,20", "0,0,20,20", 1)
+	if rec := s.get(t, forged, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("forged signature: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"ImageProcessor/internal/service/reaper_service"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+)
+
+// ReaperHandler показывает, сколько зависших задач подобрал сборщик.
+type ReaperHandler struct {
+	reaper *reaper_service.Reaper
+}
+
+func NewReaperHandler(reaper *reaper_service.Reaper) *ReaperHandler {
+	return &ReaperHandler{reaper: reaper}
+}
+
+// Stats отдает счетчики этого процесса с момента запуска. За балансировщиком
+// ответы разных реплик различаются, поэтому в ответе указан экземпляр.
+func (h *ReaperHandler) Stats(c *gin.Context) {
+	instance, _ := os.Hostname()
+	c.JSON(http.StatusOK, gin.H{"reaper": h.reaper.Stats(), "instance": instance})
+}
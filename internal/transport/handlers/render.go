@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"ImageProcessor/internal/models"
+	"ImageProcessor/internal/service/render_service"
+	"ImageProcessor/internal/urlsigner"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type RenderHandler struct {
+	renderService *render_service.RenderService
+	storage       FileStorage
+	verifier      URLVerifier
+}
+
+func NewRenderHandler(renderService *render_service.RenderService, storage FileStorage, verifier URLVerifier) *RenderHandler {
+	return &RenderHandler{renderService: renderService, storage: storage, verifier: verifier}
+}
+
+// Render отдает вариант изображения, построенный по параметрам w, h, fit и fmt.
+// caption=1 добавляет текстовый водяной знак из метаданных изображения.
+// Подписанные запросы могут использовать любые размеры в пределах максимального,
+// неподписанные — только размеры из списка разрешенных.
+func (h *RenderHandler) Render(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	taskID := c.Param("id")
+
+	trusted := false
+	if c.Query(urlsigner.ParamSignature) != "" {
+		if err := h.verifier.Verify(c.Request.URL.Path, c.Request.URL.Query()); err != nil {
+			log.Warn("Rejected signed render request", zap.Error(err))
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		trusted = true
+	}
+
+	opts, err := parseRenderOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	derivative, err := h.renderService.Render(c.Request.Context(), taskID, opts, trusted)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidRender):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrRenderNotAllowed):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrTaskNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		default:
+			log.Error("Render service failed to render image", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render image"})
+		}
+		return
+	}
+
+	cache := cacheHeaders{ETag: derivative.ETag, CacheControl: immutableCacheControl}
+	switch {
+	case trusted:
+		// Без подписи такой ответ не получить, поэтому общие кэши его не хранят
+		cache.CacheControl = signedCacheControl
+	case opts.Caption:
+		// Текст зависит от метаданных, которые можно изменить, поэтому ответ перепроверяется по ETag
+		cache.CacheControl = revalidateCacheControl
+	}
+	serveObject(c, h.storage, derivative.Path, cache, log)
+}
+
+func parseRenderOptions(c *gin.Context) (models.RenderOptions, error) {
+	width, err := parseDimension(c.Query("w"))
+	if err != nil {
+		return models.RenderOptions{}, errors.New("w must be a positive integer")
+	}
+	height, err := parseDimension(c.Query("h"))
+	if err != nil {
+		return models.RenderOptions{}, errors.New("h must be a positive integer")
+	}
+
+	opts := models.RenderOptions{Width: width, Height: height, Fit: c.Query("fit")}
+	if caption := c.Query("caption"); caption != "" {
+		if opts.Caption, err = strconv.ParseBool(caption); err != nil {
+			return models.RenderOptions{}, errors.New("caption must be a boolean")
+		}
+	}
+	if format := c.Query("fmt"); format != "" {
+		opts.Format = models.FormatFromExtension(format)
+		if opts.Format == "" {
+			return models.RenderOptions{}, errors.New("fmt must be one of: jpg, png, gif")
+		}
+	}
+	return opts, nil
+}
+
+func parseDimension(raw string) (uint, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil || value == 0 {
+		return 0, errors.New("invalid dimension")
+	}
+	return uint(value), nil
+}
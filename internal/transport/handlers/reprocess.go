@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"ImageProcessor/internal/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+)
+
+// Reprocess повторно запускает обработку оригинала. Тело необязательно:
+// {"operations": [...], "mode": "version"|"replace"}.
+func (h *ImageHandler) Reprocess(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+
+	req, ok := bindReprocessRequest(c, log)
+	if !ok {
+		return
+	}
+	run, err := h.imageService.Reprocess(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		writeReprocessError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"run": run})
+}
+
+// BulkReprocess повторно запускает обработку изображений, выбранных теми же
+// параметрами запроса, что и в ListImages (кроме cursor и limit).
+func (h *ImageHandler) BulkReprocess(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+
+	filter, err := parseImageFilter(c)
+	if err != nil {
+		log.Warn("Invalid image filter", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req, ok := bindReprocessRequest(c, log)
+	if !ok {
+		return
+	}
+
+	result, err := h.imageService.BulkReprocess(c.Request.Context(), filter, req)
+	if err != nil {
+		writeReprocessError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, result)
+}
+
+func (h *ImageHandler) GetRun(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	run, err := h.imageService.GetRun(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		writeReprocessError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"run": run})
+}
+
+func (h *ImageHandler) ListRuns(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	runs, err := h.imageService.ListRuns(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		writeReprocessError(c, log, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+func bindReprocessRequest(c *gin.Context, log *zap.Logger) (models.ReprocessRequest, bool) {
+	var req models.ReprocessRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		log.Warn("Invalid reprocess request", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object"})
+		return req, false
+	}
+	return req, true
+}
+
+func writeReprocessError(c *gin.Context, log *zap.Logger, err error) {
+	switch {
+	case errors.Is(err, models.ErrInvalidReprocess), errors.Is(err, models.ErrUnknownOperation), errors.Is(err, models.ErrInvalidFilter):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrTaskNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+	case errors.Is(err, models.ErrRunNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "processing run not found"})
+	case errors.Is(err, models.ErrTaskBusy):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Error("Image service failed to reprocess image", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reprocess image"})
+	}
+}
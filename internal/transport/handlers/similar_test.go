@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"ImageProcessor/internal/models"
+	"ImageProcessor/internal/service/image_service"
+	"context"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeHashRepo знает только testImageID, хэш которого еще не посчитан.
+// Остальные методы Repo в этих проверках не вызываются.
+type fakeHashRepo struct {
+	image_service.Repo
+}
+
+func (fakeHashRepo) GetHash(_ context.Context, id string) (*models.ImageHash, error) {
+	if id != testImageID {
+		return nil, models.ErrTaskNotFound
+	}
+	return nil, models.ErrHashNotReady
+}
+
+func TestGetSimilarStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log := zap.NewNop()
+	service := image_service.NewImageService(fakeHashRepo{}, nil, nil, nil, image_service.Config{}, log)
+	handler := NewImageHandler(service, nil, nil, 0)
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { c.Set("logger", log) })
+	engine.GET("/image/:id/similar", handler.GetSimilar)
+
+	tests := []struct {
+		name string
+		id   string
+		want int
+	}{
+		{"unknown id", "a6b1c2d3-0000-4000-8000-000000000002", http.StatusNotFound},
+		{"malformed id", "not-a-uuid", http.StatusNotFound},
+		{"hash not ready", testImageID, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/image/"+tt.id+"/similar", nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
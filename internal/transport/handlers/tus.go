@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"ImageProcessor/internal/models"
+	"ImageProcessor/internal/service/upload_service"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	tusVersion       = "1.0.0"
+	tusExtensions    = "creation,termination,expiration"
+	tusPrefix        = "/files/"
+	tusContentType   = "application/offset+octet-stream"
+	headerTaskID     = "X-Task-ID"
+	headerUploadMeta = "Upload-Metadata"
+)
+
+// TusHandler реализует протокол возобновляемых загрузок tus 1.0
+// с расширениями creation, termination и expiration.
+type TusHandler struct {
+	uploadService *upload_service.UploadService
+}
+
+func NewTusHandler(uploadService *upload_service.UploadService) *TusHandler {
+	return &TusHandler{uploadService: uploadService}
+}
+
+// Options сообщает клиенту версию протокола и поддерживаемые расширения.
+func (h *TusHandler) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.uploadService.MaxSize(), 10))
+	c.Status(http.StatusNoContent)
+}
+
+// RequireResumable отклоняет запросы без поддерживаемой версии протокола.
+func (h *TusHandler) RequireResumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+	c.Next()
+}
+
+func (h *TusHandler) Create(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Defer-Length is not supported"})
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length must be a non-negative integer"})
+		return
+	}
+
+	metadata := c.GetHeader(headerUploadMeta)
+	extension, err := tusExtension(metadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upload, err := h.uploadService.Create(c.Request.Context(), length, extension, metadata)
+	if err != nil {
+		h.writeError(c, log, err)
+		return
+	}
+	log.Debug("Upload created", zap.String("id", upload.ID), zap.Int64("length", length))
+	c.Header("Location", tusPrefix+upload.ID)
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+func (h *TusHandler) Head(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	upload, err := h.uploadService.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.writeError(c, log, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		c.Header(headerUploadMeta, upload.Metadata)
+	}
+	h.writeProgress(c, upload)
+	c.Status(http.StatusOK)
+}
+
+func (h *TusHandler) Patch(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+
+	if c.ContentType() != tusContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + tusContentType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset must be a non-negative integer"})
+		return
+	}
+
+	upload, err := h.uploadService.Append(c.Request.Context(), c.Param("id"), offset, c.Request.Body)
+	if err != nil {
+		h.writeError(c, log, err)
+		return
+	}
+	h.writeProgress(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TusHandler) Terminate(c *gin.Context) {
+	log := c.MustGet("logger").(*zap.Logger)
+	if err := h.uploadService.Terminate(c.Request.Context(), c.Param("id")); err != nil {
+		h.writeError(c, log, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TusHandler) writeProgress(c *gin.Context, upload *models.Upload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Completed() {
+		if upload.TaskID != "" {
+			c.Header(headerTaskID, upload.TaskID)
+		}
+		return
+	}
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+func (h *TusHandler) writeError(c *gin.Context, log *zap.Logger, err error) {
+	switch {
+	case errors.Is(err, models.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+	case errors.Is(err, models.ErrUploadExpired):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrUploadOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrUploadLocked):
+		c.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrUploadTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrInvalidUpload):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Error("Upload service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process upload"})
+	}
+}
+
+// tusExtension определяет расширение файла по метаданным filename или filetype.
+func tusExtension(header string) (string, error) {
+	metadata, err := parseTusMetadata(header)
+	if err != nil {
+		return "", err
+	}
+	if extension := strings.ToLower(filepath.Ext(metadata["filename"])); extension != "" {
+		if extension == ".jpeg" {
+			extension = ".jpg"
+		}
+		return extension, nil
+	}
+	if filetype, ok := strings.CutPrefix(metadata["filetype"], "image/"); ok {
+		return "." + models.FormatExtension(filetype), nil
+	}
+	return "", errors.New("Upload-Metadata must contain filename or filetype")
+}
+
+// parseTusMetadata разбирает заголовок вида "key base64value,key2 base64value".
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata contains an empty key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("Upload-Metadata values must be base64 encoded")
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
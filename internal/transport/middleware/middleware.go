@@ -1,8 +1,11 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"github.com/wb-go/wbf/ginext"
 	"go.uber.org/zap"
+	"net/http"
+	"strings"
 )
 
 func LoggingMiddleware(log *zap.Logger) ginext.HandlerFunc {
@@ -20,3 +23,18 @@ func LoggingMiddleware(log *zap.Logger) ginext.HandlerFunc {
 		requestLog.Info("Request completed")
 	}
 }
+
+// AdminAuthMiddleware пропускает только запросы с заголовком Authorization: Bearer <token>.
+// Пустой token запрещает все запросы.
+func AdminAuthMiddleware(token string) ginext.HandlerFunc {
+	return func(c *ginext.Context) {
+		provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.MustGet("logger").(*zap.Logger).Warn("Rejected admin request")
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ginext.H{"error": "admin token is required"})
+			return
+		}
+		c.Next()
+	}
+}
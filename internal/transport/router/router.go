@@ -1,6 +1,7 @@
 package router
 
 import (
+	"ImageProcessor/internal/models"
 	"ImageProcessor/internal/transport/handlers"
 	"ImageProcessor/internal/transport/middleware"
 	"github.com/wb-go/wbf/ginext"
@@ -8,16 +9,30 @@ import (
 )
 
 type Router struct {
-	rout    *ginext.Engine
-	handler *handlers.ImageHandler
-	log     *zap.Logger
+	rout          *ginext.Engine
+	handler       *handlers.ImageHandler
+	fileHandler   *handlers.FileHandler
+	renderHandler *handlers.RenderHandler
+	iiifHandler   *handlers.IIIFHandler
+	tusHandler    *handlers.TusHandler
+	deadLetters   *handlers.DeadLetterHandler
+	reaper        *handlers.ReaperHandler
+	adminToken    string
+	log           *zap.Logger
 }
 
-func NewRouter(mode string, handler *handlers.ImageHandler, log *zap.Logger) *Router {
+func NewRouter(mode string, handler *handlers.ImageHandler, fileHandler *handlers.FileHandler, renderHandler *handlers.RenderHandler, iiifHandler *handlers.IIIFHandler, tusHandler *handlers.TusHandler, deadLetters *handlers.DeadLetterHandler, reaper *handlers.ReaperHandler, adminToken string, log *zap.Logger) *Router {
 	router := Router{
-		rout:    ginext.New(mode),
-		handler: handler,
-		log:     log.Named("router"),
+		rout:          ginext.New(mode),
+		handler:       handler,
+		fileHandler:   fileHandler,
+		renderHandler: renderHandler,
+		iiifHandler:   iiifHandler,
+		tusHandler:    tusHandler,
+		deadLetters:   deadLetters,
+		reaper:        reaper,
+		adminToken:    adminToken,
+		log:           log.Named("router"),
 	}
 	router.setupRouter()
 	return &router
@@ -26,15 +41,53 @@ func NewRouter(mode string, handler *handlers.ImageHandler, log *zap.Logger) *Ro
 func (r *Router) setupRouter() {
 	r.rout.Use(middleware.LoggingMiddleware(r.log))
 	r.rout.POST("/upload", r.handler.UploadImage)
+	r.rout.PUT("/upload", r.handler.StreamUpload)
+	r.rout.POST("/upload/url", r.handler.UploadFromURL)
+	r.rout.POST("/upload/batch", r.handler.UploadBatch)
+	r.rout.GET("/batch/:id", r.handler.GetBatch)
+	r.rout.GET("/images", r.handler.ListImages)
 	r.rout.GET("/image/:id", r.handler.GetImage)
+	r.rout.GET("/image/:id/similar", r.handler.GetSimilar)
+	r.rout.GET("/image/:id/:operation", r.handler.GetDerivative)
+	r.rout.GET("/render/:id", r.renderHandler.Render)
+	r.rout.GET("/iiif/:id", r.iiifHandler.Serve)
+	r.rout.GET("/iiif/:id/*path", r.iiifHandler.Serve)
+	r.rout.PATCH("/image/:id", r.handler.UpdateMetadata)
+	r.rout.POST("/image/:id/reprocess", r.handler.Reprocess)
+	r.rout.POST("/image/:id/cancel", r.handler.CancelImage)
+	r.rout.GET("/image/:id/runs", r.handler.ListRuns)
+	r.rout.POST("/images/reprocess", r.handler.BulkReprocess)
+	r.rout.GET("/runs/:id", r.handler.GetRun)
 	r.rout.DELETE("/image/:id", r.handler.DeleteImage)
 
+	collections := r.rout.Group("/collections")
+	collections.POST("", r.handler.CreateCollection)
+	collections.GET("/:id", r.handler.GetCollection)
+	collections.PATCH("/:id", r.handler.RenameCollection)
+	collections.DELETE("/:id", r.handler.DeleteCollection)
+	collections.POST("/:id/images", r.handler.AddToCollection)
+	collections.DELETE("/:id/images/:image_id", r.handler.RemoveFromCollection)
+	collections.PUT("/:id/order", r.handler.ReorderCollection)
+
+	admin := r.rout.Group("/admin", middleware.AdminAuthMiddleware(r.adminToken))
+	admin.GET("/dead-letters", r.deadLetters.List)
+	admin.GET("/dead-letters/:id", r.deadLetters.Get)
+	admin.POST("/dead-letters/:id/replay", r.deadLetters.Replay)
+	admin.GET("/reaper", r.reaper.Stats)
+
+	files := r.rout.Group("/files", r.tusHandler.RequireResumable)
+	files.OPTIONS("", r.tusHandler.Options)
+	files.POST("", r.tusHandler.Create)
+	files.HEAD("/:id", r.tusHandler.Head)
+	files.PATCH("/:id", r.tusHandler.Patch)
+	files.DELETE("/:id", r.tusHandler.Terminate)
+
 	r.rout.GET("/", func(c *ginext.Context) {
 		c.File("./static/index.html")
 	})
 
 	r.rout.Static("/static", "static")
-	r.rout.Static("/images", "./images")
+	r.rout.GET(models.ImagesURLPrefix+"/*filepath", r.fileHandler.ServeSigned)
 }
 
 func (r *Router) GetEngine() *ginext.Engine {
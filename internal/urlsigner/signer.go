@@ -0,0 +1,116 @@
+package urlsigner
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ParamExpires   = "expires"
+	ParamKeyID     = "kid"
+	ParamSignature = "sig"
+)
+
+var (
+	ErrMissingSignature = errors.New("url is not signed")
+	ErrExpired          = errors.New("signed url has expired")
+	ErrUnknownKey       = errors.New("signed url uses unknown key")
+	ErrInvalidSignature = errors.New("invalid url signature")
+)
+
+// Signer подписывает ссылки HMAC-SHA256 с ограниченным сроком действия.
+// Подписывается всегда активным ключом, а проверяются подписи любым из
+// известных ключей, что позволяет ротировать ключи без инвалидации выданных ссылок.
+type Signer struct {
+	prefix    string
+	keys      map[string][]byte
+	activeKey string
+}
+
+func New(prefix string, keys map[string]string, activeKey string) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys configured")
+	}
+	if _, ok := keys[activeKey]; !ok {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeKey)
+	}
+	secrets := make(map[string][]byte, len(keys))
+	for id, secret := range keys {
+		if secret == "" {
+			return nil, fmt.Errorf("signing key %q is empty", id)
+		}
+		secrets[id] = []byte(secret)
+	}
+	return &Signer{
+		prefix:    strings.TrimSuffix(prefix, "/"),
+		keys:      secrets,
+		activeKey: activeKey,
+	}, nil
+}
+
+// SignURL возвращает ссылку на ключ хранилища, действительную в течение ttl.
+func (s *Signer) SignURL(key string, ttl time.Duration) string {
+	path := s.prefix + "/" + strings.TrimPrefix(key, "/")
+	return s.SignPath(path, nil, ttl)
+}
+
+// SignPath подписывает произвольный путь вместе с его query-параметрами.
+func (s *Signer) SignPath(path string, query url.Values, ttl time.Duration) string {
+	signed := url.Values{}
+	for k, v := range query {
+		signed[k] = v
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	signed.Set(ParamExpires, expires)
+	signed.Set(ParamKeyID, s.activeKey)
+	signed.Set(ParamSignature, s.sign(s.keys[s.activeKey], path, signed))
+	return path + "?" + signed.Encode()
+}
+
+// Verify проверяет подпись и срок действия ссылки.
+func (s *Signer) Verify(path string, query url.Values) error {
+	signature := query.Get(ParamSignature)
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	expires, err := strconv.ParseInt(query.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	secret, ok := s.keys[query.Get(ParamKeyID)]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	expected := s.sign(secret, path, query)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+// sign считает подпись пути и всех параметров, кроме самой подписи.
+func (s *Signer) sign(secret []byte, path string, query url.Values) string {
+	payload := url.Values{}
+	for k, v := range query {
+		if k != ParamSignature {
+			payload[k] = v
+		}
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	// Encode сортирует параметры по ключу, поэтому порядок в ссылке не важен
+	mac.Write([]byte(payload.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,15 @@
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS ahash BIGINT,
+    ADD COLUMN IF NOT EXISTS dhash BIGINT,
+    ADD COLUMN IF NOT EXISTS phash BIGINT,
+    -- 16-битные части pHash для поиска по принципу Дирихле (multi-index hashing)
+    ADD COLUMN IF NOT EXISTS phash_b0 INTEGER,
+    ADD COLUMN IF NOT EXISTS phash_b1 INTEGER,
+    ADD COLUMN IF NOT EXISTS phash_b2 INTEGER,
+    ADD COLUMN IF NOT EXISTS phash_b3 INTEGER;
+
+CREATE INDEX IF NOT EXISTS images_phash_idx ON images (phash) WHERE phash IS NOT NULL;
+CREATE INDEX IF NOT EXISTS images_phash_b0_idx ON images (phash_b0);
+CREATE INDEX IF NOT EXISTS images_phash_b1_idx ON images (phash_b1);
+CREATE INDEX IF NOT EXISTS images_phash_b2_idx ON images (phash_b2);
+CREATE INDEX IF NOT EXISTS images_phash_b3_idx ON images (phash_b3);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS blobs (
+    digest TEXT PRIMARY KEY,
+    path TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    refcount INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS digest TEXT;
+
+CREATE INDEX IF NOT EXISTS images_digest_idx ON images (digest);
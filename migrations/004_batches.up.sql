@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS batches (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS batch_id UUID REFERENCES batches (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS images_batch_id_idx ON images (batch_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS uploads (
+    id UUID PRIMARY KEY,
+    length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    metadata TEXT NOT NULL DEFAULT '',
+    extension TEXT NOT NULL,
+    task_id UUID,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS uploads_expires_at_idx ON uploads (expires_at);
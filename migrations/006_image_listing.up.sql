@@ -0,0 +1,22 @@
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS width INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS height INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+UPDATE images SET format = CASE lower(substring(original_path FROM '\.([^.]+)$'))
+    WHEN 'jpg' THEN 'jpeg'
+    WHEN 'jpeg' THEN 'jpeg'
+    WHEN 'png' THEN 'png'
+    WHEN 'gif' THEN 'gif'
+    ELSE ''
+END
+WHERE format = '';
+
+-- Индексы под сортировку с курсором: (колонка сортировки, id)
+CREATE INDEX IF NOT EXISTS images_created_at_id_idx ON images (created_at, id);
+CREATE INDEX IF NOT EXISTS images_status_created_at_id_idx ON images (status, created_at, id);
+CREATE INDEX IF NOT EXISTS images_width_id_idx ON images (width, id);
+CREATE INDEX IF NOT EXISTS images_height_id_idx ON images (height, id);
+CREATE INDEX IF NOT EXISTS images_format_idx ON images (format);
+CREATE INDEX IF NOT EXISTS images_tags_idx ON images USING GIN (tags);
@@ -0,0 +1,12 @@
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS alt_text TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+
+-- Полнотекстовый поиск по названию, описанию и alt-тексту
+ALTER TABLE images ADD COLUMN IF NOT EXISTS search tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', title || ' ' || description || ' ' || alt_text)) STORED;
+
+CREATE INDEX IF NOT EXISTS images_search_idx ON images USING GIN (search);
+CREATE INDEX IF NOT EXISTS images_labels_idx ON images USING GIN (labels jsonb_path_ops);
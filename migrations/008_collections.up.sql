@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- Удаление коллекции или изображения убирает его из коллекций каскадно
+CREATE TABLE IF NOT EXISTS collection_images (
+    collection_id UUID NOT NULL REFERENCES collections (id) ON DELETE CASCADE,
+    image_id UUID NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    added_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (collection_id, image_id)
+);
+
+CREATE INDEX IF NOT EXISTS collection_images_position_idx ON collection_images (collection_id, position);
+CREATE INDEX IF NOT EXISTS collection_images_image_id_idx ON collection_images (image_id);
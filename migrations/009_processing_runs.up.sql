@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS processing_runs (
+    id UUID PRIMARY KEY,
+    image_id UUID NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    operations TEXT[] NOT NULL,
+    mode TEXT NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS processing_runs_image_id_idx ON processing_runs (image_id, created_at);
+
+-- Для каждой операции — запуск, чьи результаты сейчас отдаются; операции без записи
+-- используют общие content-addressed пути
+ALTER TABLE images ADD COLUMN IF NOT EXISTS derivative_runs JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,14 @@
+-- Копия очереди недоставленных для просмотра и повтора. Идентификатор выводится
+-- из позиции сообщения в топике, поэтому повторное чтение не создает дублей
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id UUID PRIMARY KEY,
+    topic TEXT NOT NULL,
+    message_key BYTEA NOT NULL,
+    payload BYTEA NOT NULL,
+    error TEXT NOT NULL,
+    attempts INT NOT NULL,
+    failed_at TIMESTAMP NOT NULL,
+    replayed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS dead_letters_failed_at_idx ON dead_letters (failed_at DESC);
@@ -0,0 +1,21 @@
+-- Ключи Idempotency-Key запросов загрузки; истекший ключ можно занять заново
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key TEXT PRIMARY KEY,
+    task_id UUID NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+-- Операции, уже выполненные воркером для команды (задачи или запуска):
+-- при повторной доставке команды они пропускаются
+CREATE TABLE IF NOT EXISTS completed_operations (
+    command_id UUID NOT NULL,
+    image_id UUID NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    operation TEXT NOT NULL,
+    completed_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (command_id, operation)
+);
+
+CREATE INDEX IF NOT EXISTS completed_operations_image_id_idx ON completed_operations (image_id);
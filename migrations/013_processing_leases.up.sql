@@ -0,0 +1,7 @@
+-- Аренда задачи воркером: пока задача обрабатывается, воркер продлевает
+-- lease_expires_at; истекшую аренду подбирает сборщик
+ALTER TABLE images ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processing_attempts INT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS images_lease_expires_at_idx ON images (lease_expires_at)
+    WHERE status = 'PROCESSING' AND lease_expires_at IS NOT NULL;
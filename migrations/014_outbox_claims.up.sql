@@ -0,0 +1,8 @@
+-- Записи забираются короткой транзакцией с арендой claimed_until, а отправка идет
+-- вне транзакции. Запись, исчерпавшая попытки, откладывается (parked_at) и больше
+-- не задерживает остальные
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS parked_at TIMESTAMP;
+
+DROP INDEX IF EXISTS outbox_pending_idx;
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL AND parked_at IS NULL;
@@ -0,0 +1,7 @@
+-- Приоритет задачи хранится вместе с ней, чтобы сборщик ставил зависшую задачу
+-- обратно в ту же очередь. Задачи без аренды (воркер упал до ее взятия или строка
+-- создана до 013) ищутся по времени создания
+ALTER TABLE images ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'normal';
+
+CREATE INDEX IF NOT EXISTS images_unleased_created_at_idx ON images (created_at)
+    WHERE status = 'PROCESSING' AND lease_expires_at IS NULL;
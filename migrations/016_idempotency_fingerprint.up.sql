@@ -0,0 +1,3 @@
+-- Отпечаток запроса, занявшего ключ: повтор ключа с другим запросом отклоняется.
+-- У ключей, сохраненных до этой миграции, отпечатка нет, и они не сверяются
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS fingerprint TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Расстояние Хэмминга не упорядочено по значению pHash, поэтому btree-индекс
+-- по нему поиску похожих не помогает; кандидатов отбирают индексы частей
+DROP INDEX IF EXISTS images_phash_idx;
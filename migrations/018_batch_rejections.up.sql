@@ -0,0 +1,11 @@
+-- Файлы пакета, отклоненные при загрузке: задачи для них нет, но прогресс
+-- пакета должен их учитывать
+CREATE TABLE IF NOT EXISTS batch_rejections (
+    id BIGSERIAL PRIMARY KEY,
+    batch_id UUID NOT NULL REFERENCES batches (id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    error TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS batch_rejections_batch_id_idx ON batch_rejections (batch_id);
@@ -0,0 +1,7 @@
+-- Сборщик снимает аренду с поставленной заново задачи и запоминает время в
+-- queued_at: задача без аренды ждет воркера от этого момента, а не от создания
+ALTER TABLE images ADD COLUMN IF NOT EXISTS queued_at TIMESTAMP;
+
+DROP INDEX IF EXISTS images_unleased_created_at_idx;
+CREATE INDEX IF NOT EXISTS images_unleased_queued_at_idx ON images (COALESCE(queued_at, created_at))
+    WHERE status = 'PROCESSING' AND lease_expires_at IS NULL;
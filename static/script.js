@@ -88,27 +88,28 @@ document.addEventListener('DOMContentLoaded', () => {
 
         let resultsHTML = '<p>В обработке...</p>';
         if (task.status === 'COMPLETE') {
-            const filename = getFilename(task.original_path); // Получаем чистое имя файла, например, 'uuid.jpg'
+            // Ссылки подписаны сервером и действуют ограниченное время
+            const urls = task.urls || {};
 
             resultsHTML = `
             <div class="image-results">
                 <div>
                     <h4>Оригинал</h4>
-                    <a href="/${task.original_path}" target="_blank">
-                       <img src="/${task.original_path}" alt="Original">
+                    <a href="${urls.original}" target="_blank">
+                       <img src="${urls.original}" alt="Original">
                     </a>
                 </div>
                 <div>
                     <h4>Resize</h4>
-                    <img src="/processed/resize/${filename}" alt="Resized">
+                    <img src="${urls.resize}" alt="Resized">
                 </div>
                 <div>
                     <h4>Thumbnail</h4>
-                    <img src="/processed/thumbnail/${filename}" alt="Thumbnail">
+                    <img src="${urls.thumbnail}" alt="Thumbnail">
                 </div>
                  <div>
                     <h4>Watermark</h4>
-                    <img src="/processed/watermark/${filename}" alt="Watermarked">
+                    <img src="${urls.watermark}" alt="Watermarked">
                 </div>
             </div>
         `;
@@ -195,8 +196,4 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
-    // Вспомогательная функция для получения имени файла из пути
-    function getFilename(path) {
-        return path.split('/').pop();
-    }
-});
\ No newline at end of file
+});
\ No newline at end of file